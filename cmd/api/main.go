@@ -0,0 +1,36 @@
+// Command api serves the HTTP API; images are processed by cmd/worker
+package main
+
+import (
+	"context"
+	"flag"
+	"imageProcessor/internal/app"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func main() {
+	configPath := flag.String("config", "", "path to the config file, CONFIG_PATH is used when empty")
+	addr := flag.String("addr", ":8081", "address of the API and its /healthz endpoint")
+	flag.Parse()
+
+	cfg := app.LoadConfig(*configPath)
+	logger := app.NewLogger()
+
+	application, err := app.New(cfg, logger)
+	if err != nil {
+		panic(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	err = application.RunAPI(ctx, *addr)
+	application.Close()
+	if err != nil {
+		logger.Error("API server failed", "err", err)
+		os.Exit(1)
+	}
+	logger.Info("API server stopped gracefully")
+}
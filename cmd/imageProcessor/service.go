@@ -1,141 +1,61 @@
+// Command imageProcessor runs the API and the worker in one process for local development;
+// in production cmd/api and cmd/worker are scaled independently
 package main
 
 import (
-	"errors"
-	"fmt"
-	"imageProcessor/internal/config"
-	"imageProcessor/internal/handlers"
-	img_storage "imageProcessor/internal/img-storage"
-	"imageProcessor/internal/kafka"
-	"imageProcessor/internal/storage/sqlite"
-	"log"
-	"log/slog"
-	"net/http"
+	"context"
+	"flag"
+	"imageProcessor/internal/app"
 	"os"
 	"os/signal"
 	"sync"
+	"sync/atomic"
 	"syscall"
-
-	"github.com/IBM/sarama"
-	"github.com/joho/godotenv"
-
-	"github.com/go-chi/chi/v5"
-	"github.com/go-chi/chi/v5/middleware"
-	"github.com/go-chi/cors"
-)
-
-const configPath = "CONFIG_PATH"
-
-// some broker topics
-const (
-	imgUploadTopic  = "image-upload"
-	consumerGroupId = "image-processor"
 )
 
 func main() {
-	// config creator
-	err := godotenv.Load()
-	if err != nil {
-		panic(err)
-	}
-	// gotten config
-	cfg := config.MustLoad(os.Getenv(configPath))
+	configPath := flag.String("config", "", "path to the config file, CONFIG_PATH is used when empty")
+	addr := flag.String("addr", ":8081", "address of the API and its /healthz endpoint")
+	flag.Parse()
 
-	// logger init
-	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}))
-	slog.SetDefault(logger)
+	cfg := app.LoadConfig(*configPath)
+	logger := app.NewLogger()
 
-	// storage init
-	storage, err := sqlite.New(cfg.Storage.StoragePath)
+	application, err := app.New(cfg, logger)
 	if err != nil {
 		panic(err)
 	}
 
-	// kafka manager init
-	manager, err := kafka.NewKafkaManager(cfg.Brokers)
-	if err != nil {
-		panic(err)
-	}
-	defer manager.Close()
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	// one failed part stops the other one
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-	// kafka topics init
-	log.Println("Initializing kafka topics...")
-	topics := map[string]sarama.TopicDetail{
-		imgUploadTopic: {
-			NumPartitions:     3,
-			ReplicationFactor: 1,
-		},
-	}
-	err = manager.InitTopics(topics)
-	if err != nil {
-		panic(fmt.Errorf("creating topics failed; error: %w", err))
-	}
-	// kafka producer init
-	producer, err := kafka.NewProducer(cfg.Brokers, imgUploadTopic, logger)
-	if err != nil {
-		panic(fmt.Errorf("kafka producer does not create; err: %w", err))
-	}
-	defer producer.Close()
-
-	// kafka consumer init
-	//consumer, err := kafka.NewConsumer(cfg.Brokers, consumerGroupId)
-	//if err != nil {
-	//	panic(fmt.Errorf("kafka consumer does not create; err: %w", err))
-	//}
-	//defer consumer.Close()
-
-	//uploadDir := "./uploads"
-	logger.Info(cfg.ImgStoragePath.Path)
-	if err := os.MkdirAll(cfg.ImgStoragePath.Path, os.ModePerm); err != nil {
-		panic("image storage creating error" + fmt.Sprintf("%v", err))
-	}
-	// image storage
-	imgStorage := img_storage.ImageStorage{
-		ImgStoragePath: cfg.ImgStoragePath.Path,
-	}
-
-	// TODO:
-	router := chi.NewRouter()
-
-	router.Use(cors.Handler(cors.Options{
-		AllowedOrigins:   []string{"*"},
-		AllowedMethods:   []string{"GET", "POST", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Content-Type"},
-		AllowCredentials: false,
-		MaxAge:           300,
-	}))
-	router.Use(middleware.Logger)
-	router.Use(middleware.Recoverer)
-
-	router.Post("/upload", handlers.UploadImage(logger, storage, imgStorage, producer))
-	router.Group(func(r chi.Router) {
-		r.Get("/image/{id}", handlers.DownloadImage(logger, storage))
-		r.Delete("/image/{id}", handlers.DeleteImage(logger, storage))
-	})
-
-	doneChannel := make(chan struct{})
+	var failed atomic.Bool
 	var wg sync.WaitGroup
-	wg.Add(1)
+	wg.Add(2)
 	go func() {
 		defer wg.Done()
-		if err := kafka.Consumer(logger, cfg.Brokers, imgUploadTopic, doneChannel, storage); err != nil {
-			panic(err)
+		defer cancel()
+		if err := application.RunWorker(ctx); err != nil {
+			logger.Error("worker failed", "err", err)
+			failed.Store(true)
 		}
 	}()
-
 	go func() {
-		logger.Info("Server is starting...")
-		if err := http.ListenAndServe(":8081", router); err != nil && !errors.Is(err, http.ErrServerClosed) {
-			panic(err)
+		defer wg.Done()
+		defer cancel()
+		if err := application.RunAPI(ctx, *addr); err != nil {
+			logger.Error("API server failed", "err", err)
+			failed.Store(true)
 		}
 	}()
-
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-
-	<-quit
-
-	// Stop server
-	close(doneChannel)
 	wg.Wait()
+
+	application.Close()
+	if failed.Load() {
+		os.Exit(1)
+	}
+	logger.Info("service stopped gracefully")
 }
@@ -0,0 +1,37 @@
+// Command worker consumes upload messages and processes images;
+// several workers with the same consumer group share the partitions
+package main
+
+import (
+	"context"
+	"flag"
+	"imageProcessor/internal/app"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func main() {
+	configPath := flag.String("config", "", "path to the config file, CONFIG_PATH is used when empty")
+	healthAddr := flag.String("health-addr", ":8082", "address of the /healthz endpoint")
+	flag.Parse()
+
+	cfg := app.LoadConfig(*configPath)
+	logger := app.NewLogger()
+
+	application, err := app.New(cfg, logger)
+	if err != nil {
+		panic(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	err = application.RunWorkerWithHealth(ctx, *healthAddr)
+	application.Close()
+	if err != nil {
+		logger.Error("worker failed", "err", err)
+		os.Exit(1)
+	}
+	logger.Info("worker stopped gracefully")
+}
@@ -0,0 +1,151 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"imageProcessor/internal/handlers"
+	"imageProcessor/internal/outbox"
+	"imageProcessor/internal/queue"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/cors"
+)
+
+// shutdownTimeout bounds graceful shutdown of HTTP servers
+const shutdownTimeout = 10 * time.Second
+
+// Router registers API routes and the /healthz endpoint
+func (a *App) Router() http.Handler {
+	logger, storage, imgStorage := a.Log, a.Storage, a.ImgStorage
+
+	router := chi.NewRouter()
+
+	router.Use(cors.Handler(cors.Options{
+		AllowedOrigins:   []string{"*"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Content-Type", "Authorization", "X-API-Key"},
+		AllowCredentials: false,
+		MaxAge:           300,
+	}))
+	router.Use(middleware.Logger)
+	router.Use(middleware.Recoverer)
+	router.Use(middleware.GetHead)
+
+	router.Get("/healthz", handlers.Health("api", map[string]func() error{"storage": storage.Ping}))
+
+	// API keys of tenants are required once authentication is enabled
+	authenticated := func(r chi.Router) {
+		if a.Config.Auth.Enabled {
+			r.Use(handlers.RequireAPIKey(logger, storage, a.Config.Auth.AdminKey))
+		}
+	}
+
+	// a signed URL works without the API key, so links to images can be shared
+	router.Group(func(r chi.Router) {
+		if a.Signer != nil {
+			r.Use(handlers.RequireSignatureOrAPIKey(logger, a.Signer, storage, a.Config.Auth.AdminKey))
+		} else {
+			authenticated(r)
+		}
+		r.Get("/image/{id}", handlers.TransformImage(logger, storage, imgStorage, a.Variants, handlers.TransformLimits{
+			MaxWidth:  a.Config.Transform.MaxWidth,
+			MaxHeight: a.Config.Transform.MaxHeight,
+		}, handlers.DownloadImage(logger, storage, imgStorage)))
+		r.Get("/image/{id}/derivatives/{derivativeId}", handlers.DownloadDerivative(logger, storage, imgStorage))
+		r.Get("/image/{id}/thumbnail/{size}", handlers.DownloadThumbnail(logger, storage, imgStorage))
+	})
+
+	router.Group(func(r chi.Router) {
+		authenticated(r)
+
+		r.Post("/upload", handlers.UploadImage(logger, storage, imgStorage))
+		r.Get("/images", handlers.ListImages(logger, storage))
+		// signing keys are accepted by the configuration only together with API keys
+		if a.Signer != nil {
+			r.Post("/image/{id}/signed-url", handlers.SignImageURL(logger, storage, a.Signer))
+		}
+		r.Delete("/image/{id}", handlers.DeleteImage(logger, storage))
+		r.Post("/image/{id}/actions", handlers.RequestImageAction(logger, storage, imgStorage))
+		r.Get("/pipelines/{pipelineId}", handlers.GetPipeline(logger, storage))
+		r.Get("/jobs/{jobId}", handlers.GetJob(logger, storage))
+		r.Route("/presets", func(r chi.Router) {
+			r.Post("/", handlers.CreatePreset(logger, storage, imgStorage))
+			r.Get("/", handlers.ListPresets(logger, storage))
+			r.Get("/{name}", handlers.GetPreset(logger, storage))
+			r.Put("/{name}", handlers.UpdatePreset(logger, storage, imgStorage))
+			r.Delete("/{name}", handlers.DeletePreset(logger, storage))
+		})
+		r.Post("/watermarks", handlers.UploadWatermarkAsset(logger, storage, imgStorage))
+		r.Get("/watermarks", handlers.ListWatermarkAssets(logger, storage))
+		r.Post("/fonts", handlers.UploadFont(logger, storage, imgStorage))
+		r.Get("/fonts", handlers.ListFonts(logger, storage))
+
+		r.Group(func(r chi.Router) {
+			if a.Config.Auth.Enabled {
+				r.Use(handlers.RequireAdmin)
+				r.Post("/admin/tenants", handlers.CreateTenant(logger, storage))
+				r.Post("/admin/tenants/{tenantId}/keys", handlers.CreateAPIKey(logger, storage))
+				r.Delete("/admin/keys/{keyId}", handlers.RevokeAPIKey(logger, storage))
+			}
+			if replayer, ok := a.Queue.(queue.Replayer); ok {
+				r.Post("/admin/dlq/replay", handlers.ReplayDeadLetters(logger, replayer))
+			}
+		})
+	})
+
+	return router
+}
+
+// RunAPI serves the API until ctx is cancelled and then shuts the server down gracefully;
+// the outbox relay publishes messages of accepted requests meanwhile
+func (a *App) RunAPI(ctx context.Context, addr string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	cfg := a.Config.Outbox
+	relay := outbox.NewRelay(a.Storage, a.Queue, ImgUploadTopic, outbox.Config{
+		PollInterval:   cfg.PollInterval,
+		BatchSize:      cfg.BatchSize,
+		InitialBackoff: cfg.InitialBackoff,
+		MaxBackoff:     cfg.MaxBackoff,
+		Retention:      cfg.Retention,
+	}, a.Log)
+	relayDone := make(chan struct{})
+	go func() {
+		defer close(relayDone)
+		relay.Run(ctx)
+	}()
+
+	err := serve(ctx, a, addr, a.Router(), "API server")
+	cancel()
+	<-relayDone
+	return err
+}
+
+// serve runs the HTTP server until ctx is cancelled; in-flight requests are finished
+func serve(ctx context.Context, a *App, addr string, handler http.Handler, name string) error {
+	server := &http.Server{Addr: addr, Handler: handler}
+
+	errs := make(chan error, 1)
+	go func() {
+		a.Log.Info(name+" is starting...", "addr", addr)
+		errs <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		if errors.Is(err, http.ErrServerClosed) {
+			return nil
+		}
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	a.Log.Info(name+" is stopping...", "addr", addr)
+	return server.Shutdown(shutdownCtx)
+}
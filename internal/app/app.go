@@ -0,0 +1,170 @@
+// Package app wires the HTTP API and the processing worker;
+// cmd/api, cmd/worker and the all-in-one cmd/imageProcessor share it
+package app
+
+import (
+	"context"
+	"fmt"
+	"imageProcessor/internal/blobstore"
+	"imageProcessor/internal/cache"
+	"imageProcessor/internal/config"
+	img_storage "imageProcessor/internal/img-storage"
+	"imageProcessor/internal/kafka"
+	"imageProcessor/internal/queue"
+	"imageProcessor/internal/signing"
+	"imageProcessor/internal/storage/sqlite"
+	"log/slog"
+	"os"
+
+	"github.com/IBM/sarama"
+	"github.com/joho/godotenv"
+)
+
+// ConfigPathEnv is used when the -config flag is not set
+const ConfigPathEnv = "CONFIG_PATH"
+
+// some broker topics
+const (
+	ImgUploadTopic = "image-upload"
+)
+
+// App keeps dependencies shared by the API and the worker
+type App struct {
+	Config     *config.Config
+	Log        *slog.Logger
+	Storage    *sqlite.StorageSqlite
+	ImgStorage img_storage.ImageStorage
+	Queue      queue.Queue
+	Variants   *cache.Disk     // variants computed on request by the API
+	Signer     *signing.Signer // nil when signed URLs are not configured
+}
+
+// LoadConfig reads variables from .env when it exists and loads the configuration;
+// an empty path falls back to CONFIG_PATH
+func LoadConfig(path string) *config.Config {
+	_ = godotenv.Load()
+	if path == "" {
+		path = os.Getenv(ConfigPathEnv)
+	}
+	return config.MustLoad(path)
+}
+
+func NewLogger() *slog.Logger {
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	slog.SetDefault(logger)
+	return logger
+}
+
+// New opens the storages and the message queue
+func New(cfg *config.Config, logger *slog.Logger) (*App, error) {
+	const op = "app.New"
+
+	// storage init
+	storage, err := sqlite.New(cfg.Storage.StoragePath)
+	if err != nil {
+		return nil, fmt.Errorf("%s,%w", op, err)
+	}
+
+	// message queue init
+	logger.Info("message queue", "backend", cfg.Queue.Backend)
+	messages, err := newQueue(cfg, storage, logger)
+	if err != nil {
+		return nil, fmt.Errorf("message queue does not create; %s,%w", op, err)
+	}
+
+	// blob storage keeps originals, derivatives and uploaded assets
+	logger.Info("blob storage", "driver", cfg.BlobStorage.Driver, "path", cfg.ImgStoragePath.Path)
+	blobs, err := blobstore.New(cfg.BlobStorage.Driver, cfg.ImgStoragePath.Path, cfg.BlobStorage.S3.S3Config())
+	if err != nil {
+		messages.Close()
+		return nil, fmt.Errorf("image storage creating error; %s,%w", op, err)
+	}
+
+	// variants computed on request are cached on the local disk of the API
+	variants, err := cache.NewDisk(context.Background(), cfg.Transform.CacheDir, cfg.Transform.CacheSizeMB<<20)
+	if err != nil {
+		messages.Close()
+		return nil, fmt.Errorf("variant cache creating error; %s,%w", op, err)
+	}
+
+	// image routes require signed URLs once signing keys are configured
+	var signer *signing.Signer
+	if len(cfg.Signing.Keys) > 0 {
+		signer, err = signing.NewSigner(signing.Config{
+			Keys:       cfg.Signing.Keys,
+			ActiveKey:  cfg.Signing.ActiveKey,
+			DefaultTTL: cfg.Signing.DefaultTTL,
+			MaxTTL:     cfg.Signing.MaxTTL,
+		})
+		if err != nil {
+			messages.Close()
+			return nil, fmt.Errorf("url signer creating error; %s,%w", op, err)
+		}
+	}
+
+	return &App{
+		Config:  cfg,
+		Log:     logger,
+		Storage: storage,
+		ImgStorage: img_storage.ImageStorage{
+			Blobs:          blobs,
+			ThumbnailSizes: cfg.Thumbnails.Sizes,
+		},
+		Queue:    messages,
+		Variants: variants,
+		Signer:   signer,
+	}, nil
+}
+
+func (a *App) Close() {
+	if err := a.Queue.Close(); err != nil {
+		a.Log.Error("closing message queue error", "err", err)
+	}
+}
+
+// newQueue creates the queue backend selected in the configuration;
+// kafka topics are created before the producer
+func newQueue(cfg *config.Config, storage *sqlite.StorageSqlite, logger *slog.Logger) (queue.Queue, error) {
+	switch cfg.Queue.Backend {
+	case queue.KafkaBackend:
+		if err := initTopics(cfg.Brokers, logger); err != nil {
+			return nil, err
+		}
+		return kafka.NewQueue(cfg.Brokers, ImgUploadTopic, cfg.ConsumerGroup, logger)
+	case queue.SQLiteBackend:
+		return queue.NewSQLite(storage, ImgUploadTopic, logger), nil
+	case queue.MemoryBackend:
+		return queue.NewMemory(ImgUploadTopic), nil
+	default:
+		return nil, fmt.Errorf("unknown queue backend %q", cfg.Queue.Backend)
+	}
+}
+
+// initTopics creates the upload topic with its retry and dead-letter topics
+func initTopics(brokers []string, logger *slog.Logger) error {
+	manager, err := kafka.NewKafkaManager(brokers)
+	if err != nil {
+		return err
+	}
+	defer manager.Close()
+
+	logger.Info("Initializing kafka topics...")
+	topics := map[string]sarama.TopicDetail{
+		ImgUploadTopic: {
+			NumPartitions:     3,
+			ReplicationFactor: 1,
+		},
+		queue.RetryTopic(ImgUploadTopic): {
+			NumPartitions:     3,
+			ReplicationFactor: 1,
+		},
+		queue.DeadLetterTopic(ImgUploadTopic): {
+			NumPartitions:     1,
+			ReplicationFactor: 1,
+		},
+	}
+	if err = manager.InitTopics(topics); err != nil {
+		return fmt.Errorf("creating topics failed; error: %w", err)
+	}
+	return nil
+}
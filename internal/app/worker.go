@@ -0,0 +1,67 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"imageProcessor/internal/handlers"
+	consumer2 "imageProcessor/internal/kafka/consumer"
+	"imageProcessor/internal/queue"
+	"net/http"
+	"sync/atomic"
+)
+
+// RunWorker consumes and processes messages until ctx is cancelled;
+// messages in progress are interrupted and released before it returns, so they are delivered again
+func (a *App) RunWorker(ctx context.Context) error {
+	cfg := a.Config
+	retryPolicy := queue.RetryPolicy{
+		MaxAttempts:    cfg.Retry.MaxAttempts,
+		InitialBackoff: cfg.Retry.InitialBackoff,
+		MaxBackoff:     cfg.Retry.MaxBackoff,
+	}
+	poolConfig := queue.PoolConfig{
+		Workers:          cfg.WorkerPool.Workers,
+		QueueDepth:       cfg.WorkerPool.QueueDepth,
+		SimulatedLatency: cfg.WorkerPool.SimulatedLatency,
+	}
+	// limits are taken by every operation, so steps of pipelines and presets are bounded as well
+	limiter := queue.NewActionLimiter(cfg.WorkerPool.ActionLimits)
+	process := func(ctx context.Context, value []byte, lastAttempt bool) error {
+		return consumer2.ConsumedHandler(ctx, value, lastAttempt, a.Storage, a.ImgStorage, limiter, a.Log)
+	}
+	return queue.Run(ctx, a.Log, a.Queue, ImgUploadTopic, retryPolicy, poolConfig, process)
+}
+
+// RunWorkerWithHealth runs the worker together with a health server on addr;
+// the health check fails once the consumer has stopped
+func (a *App) RunWorkerWithHealth(ctx context.Context, addr string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var consuming atomic.Bool
+	consuming.Store(true)
+	checks := map[string]func() error{
+		"storage": a.Storage.Ping,
+		"consumer": func() error {
+			if !consuming.Load() {
+				return errors.New("consumer is stopped")
+			}
+			return nil
+		},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", handlers.Health("worker", checks))
+
+	healthErrs := make(chan error, 1)
+	go func() {
+		healthErrs <- serve(ctx, a, addr, mux, "worker health server")
+	}()
+
+	err := a.RunWorker(ctx)
+	consuming.Store(false)
+	cancel()
+	if healthErr := <-healthErrs; err == nil {
+		err = healthErr
+	}
+	return err
+}
@@ -0,0 +1,56 @@
+// Package auth describes the caller of an API request authenticated by an API key
+// and decides which images the caller sees.
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+)
+
+// keyPrefix makes keys of the service recognizable, e.g. in leaked logs
+const keyPrefix = "ipk_"
+
+// Principal is the caller of the request
+type Principal struct {
+	KeyId    int // 0 for the admin key of the configuration
+	TenantId int
+	Admin    bool
+}
+
+type principalKey struct{}
+
+// NewContext returns a copy of ctx carrying the principal
+func NewContext(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// FromContext returns the principal of the request, nil when authentication is disabled
+func FromContext(ctx context.Context) *Principal {
+	principal, _ := ctx.Value(principalKey{}).(*Principal)
+	return principal
+}
+
+// CanAccess reports whether the caller sees an image of the tenant;
+// without a principal authentication is disabled and every image is visible
+func CanAccess(ctx context.Context, tenantID int) bool {
+	principal := FromContext(ctx)
+	return principal == nil || principal.Admin || principal.TenantId == tenantID
+}
+
+// GenerateKey returns a new random API key
+func GenerateKey() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return keyPrefix + base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// HashKey returns the hex encoded sha256 under which the key is stored
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,54 @@
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Drivers which can be selected in the configuration
+const (
+	FileSystemDriver = "fs"
+	S3Driver         = "s3"
+)
+
+var ErrNotFound = errors.New("blob not found")
+
+// BlobInfo describes a stored blob
+type BlobInfo struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// BlobStore keeps originals, derivatives and uploaded assets by slash separated keys,
+// e.g. blobs/ab/cd/abcd... or derivatives/1_resize_1700000000.jpg.
+// Readers are streamed, so blobs are never loaded into memory by the store itself.
+type BlobStore interface {
+	// Put stores size bytes from r under the key, replacing a previous blob
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	// Get opens the blob for reading; the caller closes the reader
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// GetRange opens length bytes of the blob starting at offset
+	GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+	Stat(ctx context.Context, key string) (*BlobInfo, error)
+	// Delete removes the blob; deleting a missing blob is not an error
+	Delete(ctx context.Context, key string) error
+	// List returns blobs whose keys start with the prefix
+	List(ctx context.Context, prefix string) ([]BlobInfo, error)
+}
+
+// New creates the store selected by the driver name;
+// root is used by the file system driver and s3 by the S3-compatible one
+func New(driver, root string, s3 S3Config) (BlobStore, error) {
+	switch driver {
+	case FileSystemDriver, "":
+		return NewFileSystem(root)
+	case S3Driver:
+		return NewS3(s3)
+	default:
+		return nil, fmt.Errorf("unknown blob storage driver %q", driver)
+	}
+}
@@ -0,0 +1,95 @@
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// testBlobStore checks the behaviour every driver has to provide
+func testBlobStore(t *testing.T, store BlobStore) {
+	ctx := context.Background()
+
+	blobs := map[string]string{
+		"blobs/ab/cd/abcd":          "original",
+		"derivatives/1_resize.jpg":  "resized",
+		"derivatives/1_convert.png": "converted",
+	}
+	for key, content := range blobs {
+		if err := store.Put(ctx, key, strings.NewReader(content), int64(len(content))); err != nil {
+			t.Fatalf("put %s: %v", key, err)
+		}
+	}
+
+	reader, err := store.Get(ctx, "blobs/ab/cd/abcd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := io.ReadAll(reader)
+	reader.Close()
+	if err != nil || string(content) != "original" {
+		t.Errorf("unexpected content %q, %v", content, err)
+	}
+
+	reader, err = store.GetRange(ctx, "blobs/ab/cd/abcd", 2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err = io.ReadAll(reader)
+	reader.Close()
+	if err != nil || string(content) != "igi" {
+		t.Errorf("unexpected range %q, %v", content, err)
+	}
+
+	info, err := store.Stat(ctx, "derivatives/1_resize.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size != int64(len("resized")) {
+		t.Errorf("unexpected size %d", info.Size)
+	}
+
+	listed, err := store.List(ctx, "derivatives/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := make([]string, 0, len(listed))
+	for _, blob := range listed {
+		keys = append(keys, blob.Key)
+	}
+	sort.Strings(keys)
+	if strings.Join(keys, ",") != "derivatives/1_convert.png,derivatives/1_resize.jpg" {
+		t.Errorf("unexpected list %v", keys)
+	}
+
+	if err = store.Delete(ctx, "derivatives/1_resize.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = store.Stat(ctx, "derivatives/1_resize.jpg"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("deleted blob is found: %v", err)
+	}
+	if _, err = store.Get(ctx, "derivatives/1_resize.jpg"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("deleted blob is opened: %v", err)
+	}
+	if err = store.Delete(ctx, "derivatives/1_resize.jpg"); err != nil {
+		t.Errorf("deleting a missing blob failed: %v", err)
+	}
+}
+
+func TestFileSystem(t *testing.T) {
+	store, err := NewFileSystem(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testBlobStore(t, store)
+
+	if err = store.Put(context.Background(), "../escape", strings.NewReader("x"), 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = store.Stat(context.Background(), "escape"); err != nil {
+		t.Errorf("key is not kept inside the root: %v", err)
+	}
+}
@@ -0,0 +1,173 @@
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// FileSystem stores blobs as files under the root directory
+type FileSystem struct {
+	root string
+}
+
+func NewFileSystem(root string) (*FileSystem, error) {
+	const op = "blobstore.NewFileSystem"
+
+	if root == "" {
+		return nil, fmt.Errorf("%s, root directory is not set", op)
+	}
+	if err := os.MkdirAll(root, os.ModePerm); err != nil {
+		return nil, fmt.Errorf("%s,%w", op, err)
+	}
+	return &FileSystem{root: root}, nil
+}
+
+// filePath maps the key into the root directory; keys can not escape it
+func (s *FileSystem) filePath(key string) (string, error) {
+	cleaned := path.Clean("/" + key)
+	if cleaned == "/" || strings.Contains(key, "\\") {
+		return "", fmt.Errorf("invalid blob key %q", key)
+	}
+	return filepath.Join(s.root, filepath.FromSlash(cleaned[1:])), nil
+}
+
+func (s *FileSystem) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	const op = "blobstore.FileSystem.Put"
+
+	filePath, err := s.filePath(key)
+	if err != nil {
+		return fmt.Errorf("%s,%w", op, err)
+	}
+	if err = os.MkdirAll(filepath.Dir(filePath), os.ModePerm); err != nil {
+		return fmt.Errorf("%s,%w", op, err)
+	}
+
+	// the blob appears under its key only when it is written completely
+	tmpFile, err := os.CreateTemp(filepath.Dir(filePath), filepath.Base(filePath)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("%s,%w", op, err)
+	}
+	tmpPath := tmpFile.Name()
+	defer os.Remove(tmpPath)
+
+	written, err := io.Copy(tmpFile, r)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("%s,%w", op, err)
+	}
+	if size >= 0 && written != size {
+		return fmt.Errorf("%s, %d bytes are written instead of %d", op, written, size)
+	}
+
+	if err = os.Rename(tmpPath, filePath); err != nil {
+		return fmt.Errorf("%s,%w", op, err)
+	}
+	return nil
+}
+
+func (s *FileSystem) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	const op = "blobstore.FileSystem.Get"
+
+	filePath, err := s.filePath(key)
+	if err != nil {
+		return nil, fmt.Errorf("%s,%w", op, err)
+	}
+	file, err := os.Open(filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%s,%w", op, ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s,%w", op, err)
+	}
+	return file, nil
+}
+
+func (s *FileSystem) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	const op = "blobstore.FileSystem.GetRange"
+
+	reader, err := s.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	file := reader.(*os.File)
+	if _, err = file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s,%w", op, err)
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(file, length), file}, nil
+}
+
+func (s *FileSystem) Stat(ctx context.Context, key string) (*BlobInfo, error) {
+	const op = "blobstore.FileSystem.Stat"
+
+	filePath, err := s.filePath(key)
+	if err != nil {
+		return nil, fmt.Errorf("%s,%w", op, err)
+	}
+	info, err := os.Stat(filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%s,%w", op, ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s,%w", op, err)
+	}
+	return &BlobInfo{Key: key, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (s *FileSystem) Delete(ctx context.Context, key string) error {
+	const op = "blobstore.FileSystem.Delete"
+
+	filePath, err := s.filePath(key)
+	if err != nil {
+		return fmt.Errorf("%s,%w", op, err)
+	}
+	err = os.Remove(filePath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%s,%w", op, err)
+	}
+	return nil
+}
+
+func (s *FileSystem) List(ctx context.Context, prefix string) ([]BlobInfo, error) {
+	const op = "blobstore.FileSystem.List"
+
+	var blobs []BlobInfo
+	err := filepath.WalkDir(s.root, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || strings.HasSuffix(filePath, ".tmp") {
+			return nil
+		}
+		rel, err := filepath.Rel(s.root, filePath)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		blobs = append(blobs, BlobInfo{Key: key, Size: info.Size(), ModTime: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s,%w", op, err)
+	}
+	return blobs, nil
+}
@@ -0,0 +1,130 @@
+package blobstore
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// s3StandIn is a minimal MinIO-like server: it checks signatures
+// and keeps objects of one bucket in memory
+type s3StandIn struct {
+	bucket string
+	signer *S3
+
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+var authorizationPattern = regexp.MustCompile(`^AWS4-HMAC-SHA256 Credential=([^/]+)/([^,]+), SignedHeaders=([^,]+), Signature=([0-9a-f]+)$`)
+
+func (s *s3StandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	match := authorizationPattern.FindStringSubmatch(r.Header.Get("Authorization"))
+	if match == nil || match[1] != s.signer.cfg.AccessKey {
+		http.Error(w, "<Error><Code>AccessDenied</Code></Error>", http.StatusForbidden)
+		return
+	}
+	_, signature := s.signer.signature(r, r.Header.Get("X-Amz-Content-Sha256"), r.Header.Get("X-Amz-Date"), match[2])
+	if signature != match[4] {
+		http.Error(w, "<Error><Code>SignatureDoesNotMatch</Code></Error>", http.StatusForbidden)
+		return
+	}
+
+	bucketPrefix := "/" + s.bucket
+	if !strings.HasPrefix(r.URL.Path, bucketPrefix) {
+		http.Error(w, "<Error><Code>NoSuchBucket</Code></Error>", http.StatusNotFound)
+		return
+	}
+	key := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, bucketPrefix), "/")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case key == "" && r.Method == http.MethodGet:
+		s.list(w, r.URL.Query().Get("prefix"))
+	case r.Method == http.MethodPut:
+		body, _ := io.ReadAll(r.Body)
+		s.objects[key] = body
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		body, ok := s.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		var start, end int
+		if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end); err == nil {
+			body = body[start : end+1]
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(s.objects[key])))
+			w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+			w.WriteHeader(http.StatusPartialContent)
+		} else {
+			w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		}
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(body)
+		}
+	case r.Method == http.MethodDelete:
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *s3StandIn) list(w http.ResponseWriter, prefix string) {
+	var result listBucketResult
+	keys := make([]string, 0, len(s.objects))
+	for key := range s.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		result.Contents = append(result.Contents, struct {
+			Key          string    `xml:"Key"`
+			Size         int64     `xml:"Size"`
+			LastModified time.Time `xml:"LastModified"`
+		}{Key: key, Size: int64(len(s.objects[key])), LastModified: time.Now().UTC()})
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	_ = xml.NewEncoder(w).Encode(result)
+}
+
+func TestS3(t *testing.T) {
+	cfg := S3Config{Region: "us-east-1", Bucket: "images", AccessKey: "minio", SecretKey: "minio-secret"}
+	standIn := &s3StandIn{bucket: cfg.Bucket, objects: map[string][]byte{}}
+	server := httptest.NewServer(standIn)
+	defer server.Close()
+
+	cfg.Endpoint = server.URL
+	store, err := NewS3(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	standIn.signer = store
+
+	testBlobStore(t, store)
+
+	// requests signed with another secret are rejected
+	cfg.SecretKey = "wrong"
+	forged, err := NewS3(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = forged.Put(context.Background(), "key", strings.NewReader("x"), 1); err == nil || !strings.Contains(err.Error(), "SignatureDoesNotMatch") {
+		t.Errorf("forged signature is accepted: %v", err)
+	}
+}
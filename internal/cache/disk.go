@@ -0,0 +1,147 @@
+// Package cache keeps variants of images computed on request on the local disk;
+// the total size of the files is bounded, the least recently used files are removed first.
+package cache
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"fmt"
+	"imageProcessor/internal/blobstore"
+	"slices"
+	"sync"
+)
+
+// Disk is a bounded cache of files; it is a blob store as well,
+// so cached files are served like other stored files
+type Disk struct {
+	*blobstore.FileSystem
+	maxBytes int64
+
+	mu      sync.Mutex
+	size    int64
+	recent  *list.List // keys of cached files, the most recently used in front
+	entries map[string]*list.Element
+	pending map[string]*call // files being computed, concurrent requests wait for them
+}
+
+type entry struct {
+	key  string
+	size int64
+}
+
+type call struct {
+	done chan struct{}
+	err  error
+}
+
+// NewDisk opens the cache in the directory; files left by the previous run are kept
+// and ordered by modification time
+func NewDisk(ctx context.Context, dir string, maxBytes int64) (*Disk, error) {
+	const op = "cache.NewDisk"
+
+	fs, err := blobstore.NewFileSystem(dir)
+	if err != nil {
+		return nil, fmt.Errorf("%s,%w", op, err)
+	}
+	blobs, err := fs.List(ctx, "")
+	if err != nil {
+		return nil, fmt.Errorf("%s,%w", op, err)
+	}
+	slices.SortFunc(blobs, func(a, b blobstore.BlobInfo) int { return b.ModTime.Compare(a.ModTime) })
+
+	c := &Disk{
+		FileSystem: fs,
+		maxBytes:   maxBytes,
+		recent:     list.New(),
+		entries:    make(map[string]*list.Element),
+		pending:    make(map[string]*call),
+	}
+	for _, blob := range blobs {
+		c.entries[blob.Key] = c.recent.PushBack(&entry{key: blob.Key, size: blob.Size})
+		c.size += blob.Size
+	}
+	c.evict(ctx)
+	return c, nil
+}
+
+// Load makes sure the file is cached under the key; a missing file is produced by create
+// once, concurrent requests of the same key wait for it. hit reports that the file was cached.
+func (c *Disk) Load(ctx context.Context, key string, create func() ([]byte, error)) (hit bool, err error) {
+	const op = "cache.Disk.Load"
+
+	c.mu.Lock()
+	if element, ok := c.entries[key]; ok {
+		c.recent.MoveToFront(element)
+		c.mu.Unlock()
+		return true, nil
+	}
+	if pending, ok := c.pending[key]; ok {
+		c.mu.Unlock()
+		select {
+		case <-pending.done:
+			return false, pending.err
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+	}
+	pending := &call{done: make(chan struct{})}
+	c.pending[key] = pending
+	c.mu.Unlock()
+
+	pending.err = c.store(ctx, key, create)
+
+	c.mu.Lock()
+	delete(c.pending, key)
+	c.mu.Unlock()
+	close(pending.done)
+
+	if pending.err != nil {
+		return false, fmt.Errorf("%s,%w", op, pending.err)
+	}
+	c.evict(ctx)
+	return false, nil
+}
+
+// store writes the produced file and accounts its size
+func (c *Disk) store(ctx context.Context, key string, create func() ([]byte, error)) error {
+	data, err := create()
+	if err != nil {
+		return err
+	}
+	if err = c.Put(ctx, key, bytes.NewReader(data), int64(len(data))); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = c.recent.PushFront(&entry{key: key, size: int64(len(data))})
+	c.size += int64(len(data))
+	return nil
+}
+
+// evict removes the least recently used files until the cache fits its size;
+// the most recently used file is kept even when it is larger than the limit
+func (c *Disk) evict(ctx context.Context) {
+	c.mu.Lock()
+	var victims []string
+	for c.size > c.maxBytes && c.recent.Len() > 1 {
+		oldest := c.recent.Remove(c.recent.Back()).(*entry)
+		delete(c.entries, oldest.key)
+		c.size -= oldest.size
+		victims = append(victims, oldest.key)
+	}
+	c.mu.Unlock()
+
+	for _, key := range victims {
+		// a file which is not removed stays on the disk until the next start
+		_ = c.Delete(ctx, key)
+	}
+}
+
+// Size returns the total size of cached files
+func (c *Disk) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
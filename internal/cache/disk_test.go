@@ -0,0 +1,63 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"imageProcessor/internal/blobstore"
+	"testing"
+)
+
+func TestDiskEvictsLeastRecentlyUsed(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	c, err := NewDisk(ctx, dir, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	create := func(data string) func() ([]byte, error) {
+		return func() ([]byte, error) { return []byte(data), nil }
+	}
+
+	for _, key := range []string{"a", "b"} {
+		if hit, err := c.Load(ctx, key, create("1234")); hit || err != nil {
+			t.Fatalf("%s: expected a miss, got hit %v, err %v", key, hit, err)
+		}
+	}
+	// a is used again, so b is the least recently used one
+	if hit, _ := c.Load(ctx, "a", create("1234")); !hit {
+		t.Fatal("expected a hit")
+	}
+	if _, err = c.Load(ctx, "c", create("1234")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = c.Stat(ctx, "b"); !errors.Is(err, blobstore.ErrNotFound) {
+		t.Errorf("b must be evicted, got %v", err)
+	}
+	if c.Size() != 8 {
+		t.Errorf("expected 8 cached bytes, got %d", c.Size())
+	}
+
+	// files left by the previous run are accounted
+	reopened, err := NewDisk(ctx, dir, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reopened.Size() != 8 {
+		t.Errorf("expected 8 cached bytes after reopening, got %d", reopened.Size())
+	}
+	if hit, _ := reopened.Load(ctx, "c", func() ([]byte, error) { return nil, errors.New("must not be called") }); !hit {
+		t.Error("a file of the previous run must be a hit")
+	}
+	reader, err := reopened.Get(ctx, "c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	var content bytes.Buffer
+	_, _ = content.ReadFrom(reader)
+	if content.String() != "1234" {
+		t.Errorf("unexpected content %q", content.String())
+	}
+}
@@ -2,14 +2,26 @@ package config
 
 import (
 	"fmt"
+	"imageProcessor/internal/blobstore"
+	"time"
 
 	"github.com/ilyakaznacheev/cleanenv"
 )
 
 type Config struct {
-	Storage        StorageParameters `yaml:"storage"`
-	Brokers        []string          `yaml:"brokers" env-required:"true"`
-	ImgStoragePath ImageStoragePath  `yaml:"img_storage"`
+	Storage        StorageParameters    `yaml:"storage"`
+	Queue          QueueParameters      `yaml:"queue"`
+	Outbox         OutboxParameters     `yaml:"outbox"`
+	Brokers        []string             `yaml:"brokers" env:"KAFKA_BROKERS"` // required by the kafka queue backend
+	ConsumerGroup  string               `yaml:"consumer_group" env:"KAFKA_CONSUMER_GROUP" env-default:"image-processor"`
+	Retry          RetryParameters      `yaml:"retry"`
+	WorkerPool     WorkerPoolParameters `yaml:"worker_pool"`
+	ImgStoragePath ImageStoragePath     `yaml:"img_storage"`
+	BlobStorage    BlobStorage          `yaml:"blob_storage"`
+	Thumbnails     ThumbnailParameters  `yaml:"thumbnails"`
+	Transform      TransformParameters  `yaml:"transform"`
+	Signing        SigningParameters    `yaml:"signing"`
+	Auth           AuthParameters       `yaml:"auth"`
 }
 
 type StorageParameters struct {
@@ -20,6 +32,94 @@ type ImageStoragePath struct {
 	Path string `yaml:"path" env:"IMG_STORAGE_PATH" env-required:"true"`
 }
 
+// QueueParameters select the message queue between the API and the worker:
+// "kafka" for production, "sqlite" keeps messages in the database of the service,
+// "memory" works only when the API and the worker run in one process
+type QueueParameters struct {
+	Backend string `yaml:"backend" env:"QUEUE_BACKEND" env-default:"kafka"`
+}
+
+// OutboxParameters configure the relay publishing messages of accepted requests to the queue
+type OutboxParameters struct {
+	PollInterval   time.Duration `yaml:"poll_interval" env:"OUTBOX_POLL_INTERVAL" env-default:"500ms"`
+	BatchSize      int           `yaml:"batch_size" env:"OUTBOX_BATCH_SIZE" env-default:"100"`
+	InitialBackoff time.Duration `yaml:"initial_backoff" env:"OUTBOX_INITIAL_BACKOFF" env-default:"1s"`
+	MaxBackoff     time.Duration `yaml:"max_backoff" env:"OUTBOX_MAX_BACKOFF" env-default:"1m"`
+	Retention      time.Duration `yaml:"retention" env:"OUTBOX_RETENTION" env-default:"24h"` // sent messages are deleted after it, 0 keeps them
+}
+
+// BlobStorage selects where images are kept: "fs" uses img_storage.path,
+// "s3" uses an S3-compatible bucket, e.g. MinIO
+type BlobStorage struct {
+	Driver string       `yaml:"driver" env:"BLOB_STORAGE_DRIVER" env-default:"fs"`
+	S3     S3Parameters `yaml:"s3"`
+}
+
+type S3Parameters struct {
+	Endpoint  string `yaml:"endpoint" env:"S3_ENDPOINT"`
+	Region    string `yaml:"region" env:"S3_REGION" env-default:"us-east-1"`
+	Bucket    string `yaml:"bucket" env:"S3_BUCKET"`
+	AccessKey string `yaml:"access_key" env:"S3_ACCESS_KEY"`
+	SecretKey string `yaml:"secret_key" env:"S3_SECRET_KEY"`
+}
+
+func (p S3Parameters) S3Config() blobstore.S3Config {
+	return blobstore.S3Config{
+		Endpoint:  p.Endpoint,
+		Region:    p.Region,
+		Bucket:    p.Bucket,
+		AccessKey: p.AccessKey,
+		SecretKey: p.SecretKey,
+	}
+}
+
+// RetryParameters bound attempts of a failed message before it is moved to the dead-letter topic
+type RetryParameters struct {
+	MaxAttempts    int           `yaml:"max_attempts" env:"RETRY_MAX_ATTEMPTS" env-default:"5"`
+	InitialBackoff time.Duration `yaml:"initial_backoff" env:"RETRY_INITIAL_BACKOFF" env-default:"1s"`
+	MaxBackoff     time.Duration `yaml:"max_backoff" env:"RETRY_MAX_BACKOFF" env-default:"1m"`
+}
+
+// WorkerPoolParameters configure parallel processing of messages;
+// messages of one image are always processed in order
+type WorkerPoolParameters struct {
+	Workers          int            `yaml:"workers" env:"WORKER_POOL_SIZE" env-default:"4"`
+	QueueDepth       int            `yaml:"queue_depth" env:"WORKER_QUEUE_DEPTH" env-default:"16"`
+	ActionLimits     map[string]int `yaml:"action_limits" env:"WORKER_ACTION_LIMITS"` // e.g. resize:2,watermark:2
+	SimulatedLatency time.Duration  `yaml:"simulated_latency" env:"WORKER_SIMULATED_LATENCY" env-default:"0s"`
+}
+
+// ThumbnailParameters declares square sizes produced by the miniature action
+type ThumbnailParameters struct {
+	Sizes []int `yaml:"sizes" env:"THUMBNAIL_SIZES" env-default:"64,128,256"`
+}
+
+// TransformParameters configure variants computed by GET /image/{id} with transform parameters;
+// computed variants are kept in a bounded cache on the local disk
+type TransformParameters struct {
+	CacheDir    string `yaml:"cache_dir" env:"TRANSFORM_CACHE_DIR" env-default:"./cache/transforms"`
+	CacheSizeMB int64  `yaml:"cache_size_mb" env:"TRANSFORM_CACHE_SIZE_MB" env-default:"512"`
+	MaxWidth    int    `yaml:"max_width" env:"TRANSFORM_MAX_WIDTH" env-default:"4000"`
+	MaxHeight   int    `yaml:"max_height" env:"TRANSFORM_MAX_HEIGHT" env-default:"4000"`
+}
+
+// SigningParameters configure signed URLs of images; without keys the image routes stay public.
+// URLs are signed with the active key and every listed key is accepted: to rotate a key add a new one,
+// make it active and remove the previous one once URLs signed with it have expired
+type SigningParameters struct {
+	Keys       map[string]string `yaml:"keys" env:"SIGNING_KEYS"` // key id: secret, at least 16 bytes
+	ActiveKey  string            `yaml:"active_key" env:"SIGNING_ACTIVE_KEY"`
+	DefaultTTL time.Duration     `yaml:"default_ttl" env:"SIGNING_DEFAULT_TTL" env-default:"15m"`
+	MaxTTL     time.Duration     `yaml:"max_ttl" env:"SIGNING_MAX_TTL" env-default:"24h"`
+}
+
+// AuthParameters enable API keys of tenants; without them the API is open.
+// The admin key sees images of all tenants and creates tenants and their keys
+type AuthParameters struct {
+	Enabled  bool   `yaml:"enabled" env:"AUTH_ENABLED" env-default:"false"`
+	AdminKey string `yaml:"admin_key" env:"AUTH_ADMIN_KEY"` // at least 16 bytes
+}
+
 func MustLoad(pathConfig string) *Config {
 	var cfg Config
 
@@ -27,6 +127,20 @@ func MustLoad(pathConfig string) *Config {
 	if err != nil {
 		panic(fmt.Errorf("to set config error; %w", err))
 	}
+	if cfg.Queue.Backend == "kafka" && len(cfg.Brokers) == 0 {
+		panic(fmt.Errorf("to set config error; brokers are required by the kafka queue backend"))
+	}
+	// without the admin key nobody can create tenants and their keys
+	if cfg.Auth.Enabled && cfg.Auth.AdminKey == "" {
+		panic(fmt.Errorf("to set config error; admin key is required when auth is enabled"))
+	}
+	// signed URLs are issued only to callers with an API key
+	if len(cfg.Signing.Keys) > 0 && !cfg.Auth.Enabled {
+		panic(fmt.Errorf("to set config error; signing keys require auth to be enabled"))
+	}
+	if cfg.Auth.AdminKey != "" && len(cfg.Auth.AdminKey) < 16 {
+		panic(fmt.Errorf("to set config error; admin key must be at least 16 bytes long"))
+	}
 
 	return &cfg
 }
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	img_storage "imageProcessor/internal/img-storage"
+	"imageProcessor/internal/models"
+	storagePkg "imageProcessor/internal/storage"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// maxActionRequestSize bounds the JSON body of an action request
+const maxActionRequestSize = 1 << 20
+
+// NewActionRequest is an action over the stored original;
+// like an upload it holds a single action, a preset or a pipeline
+type NewActionRequest struct {
+	Action     string          `json:"action,omitempty"`
+	Parameters json.RawMessage `json:"parameters,omitempty"` // models.ActionParameters
+	Preset     string          `json:"preset,omitempty"`
+	Pipeline   json.RawMessage `json:"pipeline,omitempty"` // list of models.Operation
+}
+
+// RequestImageAction queues another action over the uploaded original,
+// so new variants are produced without uploading the image again
+func RequestImageAction(log *slog.Logger, storage ImageSqlSaver, imgStorage img_storage.ImageStorage) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.RequestImageAction"
+
+		intID, err := strconv.Atoi(chi.URLParam(r, idQueryParameter))
+		if err != nil {
+			log.Error("id parameter is not number type", "op", op, "err", err)
+			http.Error(w, "incorrect id parameter", http.StatusBadRequest)
+			return
+		}
+
+		metadata, ok := loadImage(w, r, log, op, storage, intID)
+		if !ok {
+			return
+		}
+
+		var request NewActionRequest
+		decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxActionRequestSize))
+		decoder.DisallowUnknownFields()
+		if err = decoder.Decode(&request); err != nil {
+			http.Error(w, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		action, parameters, pipeline, err := prepareAction(storage, imgStorage, metadata.TenantId, request.Action, request.Preset,
+			rawJSON(request.Parameters), rawJSON(request.Pipeline))
+		if err != nil {
+			log.Warn("action parameters are invalid", "op", op, "err", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		message := models.KafkaMessage{
+			Id:         intID,
+			Action:     action,
+			Parameters: parameters,
+			Preset:     request.Preset,
+		}
+		err = storage.RequestAction(pipeline, &message)
+		if errors.Is(err, storagePkg.ErrImageNotFound) {
+			http.Error(w, "image not found", http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, storagePkg.ErrImageDeleted) {
+			http.Error(w, "image is deleted", http.StatusGone)
+			return
+		}
+		if err != nil {
+			log.Error("queueing action failed", "op", op, "err", err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+
+		response := ImageActionResponse{
+			Status:     http.StatusText(http.StatusAccepted),
+			Message:    fmt.Sprintf("action is queued - %s", action),
+			ImageID:    intID,
+			Action:     action,
+			Parameters: parameters,
+			PipelineID: message.PipelineId,
+			Preset:     request.Preset,
+			JobID:      message.JobId,
+			JobURL:     fmt.Sprintf("/jobs/%d", message.JobId),
+			CreatedAt:  time.Now().UTC(),
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Location", response.JobURL)
+		w.WriteHeader(http.StatusAccepted)
+		_ = json.NewEncoder(w).Encode(response)
+	}
+}
+
+// rawJSON returns the raw value, an omitted or null value is empty
+func rawJSON(raw json.RawMessage) string {
+	if string(raw) == "null" {
+		return ""
+	}
+	return string(raw)
+}
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+const (
+	defaultReplayLimit = 100
+	maxReplayLimit     = 1000
+)
+
+type DeadLetterReplayer interface {
+	Replay(ctx context.Context, limit int) (int, error)
+}
+
+type ReplayResponse struct {
+	Replayed int `json:"replayed"`
+}
+
+// ReplayDeadLetters moves messages of the dead-letter topic back to processing;
+// ?limit= bounds the number of replayed messages
+func ReplayDeadLetters(log *slog.Logger, replayer DeadLetterReplayer) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.ReplayDeadLetters"
+
+		limit := defaultReplayLimit
+		if raw := r.URL.Query().Get("limit"); raw != "" {
+			value, err := strconv.Atoi(raw)
+			if err != nil || value < 1 || value > maxReplayLimit {
+				http.Error(w, "limit must be between 1 and 1000", http.StatusBadRequest)
+				return
+			}
+			limit = value
+		}
+
+		replayed, err := replayer.Replay(r.Context(), limit)
+		if err != nil {
+			log.Error("replaying dead letters failed", "op", op, "replayed", replayed, "err", err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+		log.Info("dead letters are replayed", "op", op, "replayed", replayed)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(ReplayResponse{Replayed: replayed})
+	}
+}
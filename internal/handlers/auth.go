@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"imageProcessor/internal/auth"
+	"imageProcessor/internal/models"
+	storagePkg "imageProcessor/internal/storage"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// apiKeyHeader carries the API key; Authorization: Bearer <key> is accepted as well
+const apiKeyHeader = "X-API-Key"
+
+type APIKeyGetter interface {
+	GetAPIKey(keyHash string) (*models.APIKey, error)
+}
+
+// RequireAPIKey authenticates requests by the API key; the principal of the key
+// is put into the request context. adminKey is the key of the configuration
+// which sees all images, it is not stored in the database.
+func RequireAPIKey(log *slog.Logger, keys APIKeyGetter, adminKey string) func(http.Handler) http.Handler {
+	adminHash := auth.HashKey(adminKey)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			const op = "handlers.RequireAPIKey"
+
+			key := requestAPIKey(r)
+			if key == "" {
+				http.Error(w, "api key is required", http.StatusUnauthorized)
+				return
+			}
+
+			hash := auth.HashKey(key)
+			if adminKey != "" && subtle.ConstantTimeCompare([]byte(hash), []byte(adminHash)) == 1 {
+				next.ServeHTTP(w, r.WithContext(auth.NewContext(r.Context(), &auth.Principal{Admin: true})))
+				return
+			}
+
+			apiKey, err := keys.GetAPIKey(hash)
+			if errors.Is(err, storagePkg.ErrAPIKeyNotFound) {
+				http.Error(w, "api key is invalid", http.StatusUnauthorized)
+				return
+			}
+			if err != nil {
+				log.Error("getting api key error", "op", op, "err", err)
+				http.Error(w, "Internal error", http.StatusInternalServerError)
+				return
+			}
+
+			principal := &auth.Principal{KeyId: apiKey.Id, TenantId: apiKey.TenantId, Admin: apiKey.Admin}
+			next.ServeHTTP(w, r.WithContext(auth.NewContext(r.Context(), principal)))
+		})
+	}
+}
+
+// RequireAdmin lets through only requests with an admin key; it follows RequireAPIKey
+func RequireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if principal := auth.FromContext(r.Context()); principal == nil || !principal.Admin {
+			http.Error(w, "admin api key is required", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requestAPIKey returns the key of the X-API-Key or the Authorization header
+func requestAPIKey(r *http.Request) string {
+	if key := r.Header.Get(apiKeyHeader); key != "" {
+		return key
+	}
+	if key, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(key)
+	}
+	return ""
+}
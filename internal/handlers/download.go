@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	img_storage "imageProcessor/internal/img-storage"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	formatQueryParameter = "format"
+	jsonFormat           = "json"
+)
+
+var errUnsatisfiableRange = errors.New("range is not satisfiable")
+
+// storedFile describes a file which is streamed to the client as is
+type storedFile struct {
+	Key      string
+	MimeType string
+	Checksum string // hex encoded sha256, used as the ETag
+}
+
+// wantsJSON reports whether the client asked for the legacy base64 JSON response
+func wantsJSON(r *http.Request) bool {
+	return r.URL.Query().Get(formatQueryParameter) == jsonFormat
+}
+
+// serveFile streams the file with validators and single range support.
+// An error is returned only when nothing has been written yet.
+func serveFile(w http.ResponseWriter, r *http.Request, imgStorage img_storage.ImageStorage, file storedFile) error {
+	const op = "handlers.serveFile"
+
+	info, err := imgStorage.Blobs.Stat(r.Context(), file.Key)
+	if err != nil {
+		return fmt.Errorf("%s,%w", op, err)
+	}
+	size := info.Size
+	modTime := info.ModTime.UTC().Truncate(time.Second)
+
+	etag := strconv.Quote(file.Checksum)
+	if file.Checksum == "" {
+		etag = strconv.Quote(fmt.Sprintf("%x-%x", modTime.Unix(), size))
+	}
+
+	header := w.Header()
+	header.Set("ETag", etag)
+	if !modTime.IsZero() {
+		header.Set("Last-Modified", modTime.Format(http.TimeFormat))
+	}
+	header.Set("Accept-Ranges", "bytes")
+
+	if notModified(r, etag, modTime) {
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+
+	mimeType := file.MimeType
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+	header.Set("Content-Type", mimeType)
+
+	status := http.StatusOK
+	var offset, length int64 = 0, size
+	if rangeHeader := r.Header.Get("Range"); rangeHeader != "" && rangeApplies(r, etag, modTime) {
+		start, end, err := parseRange(rangeHeader, size)
+		if errors.Is(err, errUnsatisfiableRange) {
+			header.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+			http.Error(w, err.Error(), http.StatusRequestedRangeNotSatisfiable)
+			return nil
+		}
+		// malformed and multiple ranges are ignored, the whole file is sent
+		if err == nil {
+			status = http.StatusPartialContent
+			offset, length = start, end-start+1
+			header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, size))
+		}
+	}
+
+	var reader io.ReadCloser
+	if status == http.StatusPartialContent {
+		reader, err = imgStorage.Blobs.GetRange(r.Context(), file.Key, offset, length)
+	} else {
+		reader, err = imgStorage.Blobs.Get(r.Context(), file.Key)
+	}
+	if err != nil {
+		header.Del("Content-Range")
+		return fmt.Errorf("%s,%w", op, err)
+	}
+	defer reader.Close()
+
+	header.Set("Content-Length", strconv.FormatInt(length, 10))
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		_, _ = io.CopyN(w, reader, length)
+	}
+	return nil
+}
+
+// notModified checks If-None-Match and, when it is absent, If-Modified-Since
+func notModified(r *http.Request, etag string, modTime time.Time) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return etagMatches(inm, etag)
+	}
+	ims := r.Header.Get("If-Modified-Since")
+	if ims == "" || modTime.IsZero() {
+		return false
+	}
+	since, err := http.ParseTime(ims)
+	return err == nil && !modTime.After(since)
+}
+
+// rangeApplies checks If-Range: the range is served only for the same version of the file
+func rangeApplies(r *http.Request, etag string, modTime time.Time) bool {
+	ifRange := r.Header.Get("If-Range")
+	if ifRange == "" {
+		return true
+	}
+	if strings.HasPrefix(ifRange, `"`) {
+		return ifRange == etag
+	}
+	since, err := http.ParseTime(ifRange)
+	return err == nil && !modTime.IsZero() && modTime.Equal(since)
+}
+
+// etagMatches compares the list of If-None-Match tags using weak comparison
+func etagMatches(list, etag string) bool {
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRange parses a single "bytes=" range and returns inclusive bounds
+func parseRange(header string, size int64) (int64, int64, error) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return 0, 0, fmt.Errorf("unsupported range %q", header)
+	}
+	first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid range %q", header)
+	}
+
+	if first == "" {
+		// suffix range: the last N bytes
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return 0, 0, fmt.Errorf("invalid range %q", header)
+		}
+		if n == 0 || size == 0 {
+			return 0, 0, errUnsatisfiableRange
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, size - 1, nil
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, fmt.Errorf("invalid range %q", header)
+	}
+	if start >= size {
+		return 0, 0, errUnsatisfiableRange
+	}
+	end := size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return 0, 0, fmt.Errorf("invalid range %q", header)
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	return start, end, nil
+}
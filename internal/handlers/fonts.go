@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	img_storage "imageProcessor/internal/img-storage"
+	"imageProcessor/internal/models"
+	storagePkg "imageProcessor/internal/storage"
+	"io"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+type FontStorage interface {
+	SetFont(font *models.Font) (int, error)
+	ListFonts(tenantID int, allTenants bool) ([]models.Font, error)
+}
+
+const (
+	fontForm       = "font"
+	fontFamilyForm = "family"
+	fontWeightForm = "weight"
+	// maxFontSize limits uploaded font files
+	maxFontSize = 5 * 1024 * 1024
+)
+
+// FontResponse - description of a font available to watermarks
+type FontResponse struct {
+	ID               int        `json:"id,omitempty"`
+	TenantID         int        `json:"tenant_id,omitempty"`
+	Family           string     `json:"family"`
+	Weight           string     `json:"weight"`
+	Builtin          bool       `json:"builtin"`
+	OriginalFilename string     `json:"original_filename,omitempty"`
+	FileSize         int        `json:"file_size,omitempty"`
+	CreatedAt        *time.Time `json:"created_at,omitempty"`
+}
+
+func toFontResponse(font *models.Font) FontResponse {
+	return FontResponse{
+		ID:               font.Id,
+		TenantID:         font.TenantId,
+		Family:           font.Family,
+		Weight:           font.Weight,
+		OriginalFilename: font.OriginalFilename,
+		FileSize:         font.FileSize,
+		CreatedAt:        &font.CreatedAt,
+	}
+}
+
+// UploadFont stores a TrueType font as a weight of a new font family
+func UploadFont(log *slog.Logger, storage FontStorage, imgStorage img_storage.ImageStorage) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.UploadFont"
+
+		r.Body = http.MaxBytesReader(w, r.Body, maxFontSize+1024*1024)
+		if err := r.ParseMultipartForm(maxFontSize); err != nil {
+			http.Error(w, "Gotten data too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		family := r.FormValue(fontFamilyForm)
+		if err := models.ValidateFontFamily(family); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if img_storage.IsBuiltinFontFamily(family) {
+			http.Error(w, "builtin font family can not be replaced", http.StatusConflict)
+			return
+		}
+		weight := r.FormValue(fontWeightForm)
+		if weight == "" {
+			weight = "regular"
+		}
+		if !models.FontWeights[weight] {
+			http.Error(w, "unknown font weight", http.StatusBadRequest)
+			return
+		}
+
+		file, handler, err := r.FormFile(fontForm)
+		if err != nil {
+			log.Warn("error getting font file", "op", op, "err", err)
+			http.Error(w, "font file is required", http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+
+		if handler.Size > maxFontSize {
+			http.Error(w, "font file is too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		data, err := io.ReadAll(file)
+		if err != nil {
+			log.Error("error reading font file", "op", op, "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		if _, err = img_storage.ParseFont(data); err != nil {
+			http.Error(w, "font must be a TrueType font", http.StatusUnsupportedMediaType)
+			return
+		}
+
+		key := imgStorage.NewFontKey(family, weight)
+		if err = imgStorage.Blobs.Put(r.Context(), key, bytes.NewReader(data), int64(len(data))); err != nil {
+			log.Error("error saving font file", "op", op, "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		// the font belongs to the tenant of the API key, other tenants may upload the same family
+		tenantID, _ := tenantScope(r)
+		font := models.Font{
+			TenantId:         tenantID,
+			Family:           family,
+			Weight:           weight,
+			OriginalFilename: filepath.Base(handler.Filename),
+			Path:             key,
+			FileSize:         len(data),
+			CreatedAt:        time.Now(),
+		}
+		font.Id, err = storage.SetFont(&font)
+		if err != nil {
+			_ = imgStorage.Blobs.Delete(r.Context(), key)
+			if errors.Is(err, storagePkg.ErrFontExists) {
+				http.Error(w, "font already exists", http.StatusConflict)
+				return
+			}
+			log.Error("adding font metadata failed", "op", op, "err", err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(toFontResponse(&font))
+	}
+}
+
+// ListFonts returns builtin fonts followed by fonts uploaded by the caller;
+// an admin key sees fonts of all tenants
+func ListFonts(log *slog.Logger, storage FontStorage) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.ListFonts"
+
+		tenantID, allTenants := tenantScope(r)
+		fonts, err := storage.ListFonts(tenantID, allTenants)
+		if err != nil {
+			log.Error("listing fonts failed", "op", op, "err", err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+
+		builtin := img_storage.BuiltinFontWeights()
+		families := make([]string, 0, len(builtin))
+		for family := range builtin {
+			families = append(families, family)
+		}
+		sort.Strings(families)
+
+		resp := make([]FontResponse, 0, len(fonts)+len(families))
+		for _, family := range families {
+			for _, weight := range builtin[family] {
+				resp = append(resp, FontResponse{Family: family, Weight: weight, Builtin: true})
+			}
+		}
+		for i := range fonts {
+			resp = append(resp, toFontResponse(&fonts[i]))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(resp)
+	}
+}
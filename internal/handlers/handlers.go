@@ -2,48 +2,70 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"imageProcessor/internal/auth"
 	img_storage "imageProcessor/internal/img-storage"
-	"imageProcessor/internal/kafka"
 	"imageProcessor/internal/models"
-	"io"
+	storagePkg "imageProcessor/internal/storage"
 	"log/slog"
 	"net/http"
-	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 )
 
 type ImageSqlSaver interface {
-	SetMetadata(metadata *models.ImageMetadata) (int, error)
+	WatermarkAssetGetter
+	SetUpload(metadata *models.ImageMetadata, pipeline []models.Operation, message *models.KafkaMessage) (int, error)
 	GetImageMetadata(id int) (*models.ImageMetadata, error)
-	DeleteImage(id int) error
-	UpdateStatus(id int, status string) error
+	GetDerivative(imageID, id int) (*models.Derivative, error)
+	GetDerivatives(imageID int) ([]models.Derivative, error)
+	GetDerivativeByVariant(imageID int, action, variant string) (*models.Derivative, error)
+	RequestDelete(id int) (int, error)
+	RequestAction(pipeline []models.Operation, message *models.KafkaMessage) error
+	GetPipeline(id int) (*models.Pipeline, error)
+	GetPreset(tenantID int, name string) (*models.Preset, error)
 }
 
 type ImageActionRequest struct {
-	Image  string `json:"image"`
-	Action string `json:"action"`
+	Image      string                   `json:"image"`
+	Action     string                   `json:"action"`
+	Parameters *models.ActionParameters `json:"parameters,omitempty"`
 }
 
 // ImageActionResponse - структура для ответа со статусом 202 Accepted
 type ImageActionResponse struct {
-	Status  string `json:"status"`   // "accepted", "processing", "completed"
-	Message string `json:"message"`  // описание результата
-	ImageID int    `json:"image_id"` // уникальный ID картинки
-	Action  string `json:"action"`   // выполняемое действие
-	//TaskID      string     `json:"task_id"`                // ID асинхронной задачи
-	//CreatedAt   time.Time  `json:"created_at"`             // время создания запроса
-	//CompletedAt *time.Time `json:"completed_at,omitempty"` // время завершения
+	Status     string                   `json:"status"`               // "accepted", "processing", "completed"
+	Message    string                   `json:"message"`              // описание результата
+	ImageID    int                      `json:"image_id"`             // уникальный ID картинки
+	Action     string                   `json:"action"`               // выполняемое действие
+	Parameters *models.ActionParameters `json:"parameters,omitempty"` // параметры действия
+	PipelineID int                      `json:"pipeline_id,omitempty"`
+	Preset     string                   `json:"preset,omitempty"`
+	JobID      int                      `json:"job_id"`     // ID асинхронной задачи
+	JobURL     string                   `json:"job_url"`    // статус задачи: GET /jobs/{id}
+	CreatedAt  time.Time                `json:"created_at"` // время создания запроса
 }
 
 // ImageIncludedResponse - struct to send an image in response to client
 type ImageIncludedResponse struct {
-	Status  string `json:"status"`
-	Image   []byte `json:"image"`
-	Message string `json:"message"`
+	Status      string               `json:"status"`
+	Image       []byte               `json:"image"`
+	MimeType    string               `json:"mime_type,omitempty"`
+	Message     string               `json:"message"`
+	ImageStatus string               `json:"image_status,omitempty"` // processing status of the original
+	Derivatives []DerivativeResponse `json:"derivatives,omitempty"`
+}
+
+// DerivativeResponse - short description of a processed result of the image
+type DerivativeResponse struct {
+	ID     int    `json:"id"`
+	Action string `json:"action"`
+	URL    string `json:"url"`
 }
 
 func (r *ImageActionRequest) RequestValidate() error {
@@ -58,21 +80,34 @@ func (r *ImageActionRequest) RequestValidate() error {
 
 // constants
 const (
-	imgForm    = "image"
-	actionForm = "action"
+	imgForm        = "image"
+	actionForm     = "action"
+	parametersForm = "parameters" // JSON encoded models.ActionParameters
+	pipelineForm   = "pipeline"   // JSON encoded list of models.Operation
+	presetForm     = "preset"     // name of a stored preset
 )
 
-const idQueryParameter = "id"
+const (
+	idQueryParameter      = "id"
+	derivativeIdParameter = "derivativeId"
+	pipelineIdParameter   = "pipelineId"
+	sizeParameter         = "size"
+	jobIdParameter        = "jobId"
+)
 
 // statuses of image handling
 const (
 	modifiedStatus    = "modified"
+	deletedStatus     = "deleted" // the image waits for the worker to remove it
 	resizedStatus     = "image was resized"
 	miniaturedStatus  = "miniature was created"
 	watermarkedStatus = "watermark wad added"
 )
 
-func UploadImage(log *slog.Logger, storage ImageSqlSaver, imgStorage img_storage.ImageStorage, producer kafka.Producer) func(http.ResponseWriter, *http.Request) {
+// UploadImage stores the original and accepts the requested action;
+// the message for the worker is written to the outbox together with the image,
+// so the upload is processed even when the queue is unavailable at the moment
+func UploadImage(log *slog.Logger, storage ImageSqlSaver, imgStorage img_storage.ImageStorage) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		const op = "sqlite.UploadImage"
 
@@ -96,87 +131,203 @@ func UploadImage(log *slog.Logger, storage ImageSqlSaver, imgStorage img_storage
 
 		defer file.Close()
 
-		// check extension
-		extension := filepath.Ext(handler.Filename)
-
-		allowedExtensions := map[string]bool{
-			".jpg": true,
-			".png": true,
-			".gif": true,
-		}
-
-		if !allowedExtensions[extension] {
-			log.Warn("%s; file extension not allowed", op)
-			http.Error(w, fmt.Sprintf("invalid file extension"), http.StatusBadRequest)
+		// the format is detected by the content, the file name is not trusted
+		info, err := img_storage.DetectImage(file)
+		if err != nil {
+			log.Warn("image content is not accepted", "op", op, "filename", handler.Filename, "err", err)
+			status := http.StatusUnsupportedMediaType
+			if !errors.Is(err, img_storage.ErrUnsupportedFormat) && !errors.Is(err, img_storage.ErrFormatMismatch) {
+				status = http.StatusInternalServerError
+			}
+			msg := err.Error()
+			if errors.Is(err, img_storage.ErrUnsupportedFormat) {
+				msg = "unsupported image format, expected JPEG, PNG, GIF, BMP, TIFF or WebP"
+			}
+			http.Error(w, msg, status)
 			return
 		}
-		// get action parameter
+		// the image belongs to the tenant of the API key
+		tenantID, _ := tenantScope(r)
 
-		action := r.FormValue("action")
-
-		// upload file into local storage
-		baseFilename := filepath.Base(handler.Filename)
-		newFilePath := filepath.Join(imgStorage.ImgStoragePath, baseFilename)
-		dst, err := os.Create(newFilePath)
+		// get action and its parameters; a preset or a pipeline replaces a single action
+		presetName := r.FormValue(presetForm)
+		action, parameters, pipeline, err := prepareAction(storage, imgStorage, tenantID, r.FormValue(actionForm), presetName,
+			r.FormValue(parametersForm), r.FormValue(pipelineForm))
 		if err != nil {
-			log.Error("%s; error creating file: %v", op, err)
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			log.Warn("action parameters are invalid", "op", op, "err", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
-		defer dst.Close()
 
-		_, err = io.Copy(dst, file)
+		// the original is stored by its content, identical uploads share the bytes
+		checksum, blobPath, size, err := imgStorage.StoreBlob(r.Context(), file)
 		if err != nil {
-			log.Error("%s; error uploading file: %v", op, err)
+			log.Error("error uploading file", "op", op, "err", err)
 			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 			return
 		}
 
-		// TODO: to add sqlite SetMetaData function
 		imgMetadata := models.ImageMetadata{
-			OriginalFilename: baseFilename,
-			OriginalPath:     newFilePath,
-			MimeType:         extension,
-			FileSize:         int(handler.Size),
+			TenantId:         tenantID,
+			OriginalFilename: filepath.Base(handler.Filename),
+			OriginalPath:     blobPath,
+			Checksum:         checksum,
+			MimeType:         info.MimeType,
+			Width:            info.Width,
+			Height:           info.Height,
+			FileSize:         int(size),
 			Status:           "pending",
 			Action:           action,
+			Parameters:       parameters,
+			Preset:           presetName,
 		}
 
-		id, err := storage.SetMetadata(&imgMetadata)
+		kafkaMessage := models.KafkaMessage{
+			Action:     action,
+			Parameters: parameters,
+			Preset:     presetName,
+		}
+		id, err := storage.SetUpload(&imgMetadata, pipeline, &kafkaMessage)
 		if err != nil {
+			_ = imgStorage.Blobs.Delete(r.Context(), blobPath)
 			log.Error("Adding new image's metadata failed", "op", op, "err", err)
 			http.Error(w, "Internal error", http.StatusInternalServerError)
 			return
 		}
-
-		//TODO: to add kafka producer calling
-		kafkaMessage := models.KafkaMessage{
-			Id:     id,
-			Action: action,
-		}
-		// TODO: To add topic into configuration file
-		err = producer.SendMessage(kafkaMessage)
-		if err != nil {
-			log.Error("sending message into broker failed", "op", op, "err", err)
-			http.Error(w, "Internal error", http.StatusInternalServerError)
+		// identical content is already stored, the image shares that copy
+		if imgMetadata.OriginalPath != blobPath {
+			_ = imgStorage.Blobs.Delete(r.Context(), blobPath)
 		}
+		pipelineID, jobID := kafkaMessage.PipelineId, kafkaMessage.JobId
 
 		// create a response message
 		response := ImageActionResponse{
-			Status:  http.StatusText(http.StatusAccepted),
-			Message: fmt.Sprintf("image is uploaded seccessuly to do - %s", action),
-			ImageID: id,
-			Action:  action,
+			Status:     http.StatusText(http.StatusAccepted),
+			Message:    fmt.Sprintf("image is uploaded seccessuly to do - %s", action),
+			ImageID:    id,
+			Action:     action,
+			Parameters: parameters,
+			PipelineID: pipelineID,
+			Preset:     presetName,
+			JobID:      jobID,
+			JobURL:     fmt.Sprintf("/jobs/%d", jobID),
+			CreatedAt:  time.Now().UTC(),
 		}
 
 		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Location", response.JobURL)
 		w.WriteHeader(http.StatusAccepted)
 		json.NewEncoder(w).Encode(response)
 		//fmt.Fprintf(w, "File %s downloaded seccessfuly", handler.Filename)
 	}
 }
 
-// DownloadImage handler implementation
-func DownloadImage(log *slog.Logger, storage ImageSqlSaver) func(http.ResponseWriter, *http.Request) {
+// prepareAction validates the requested action over an image of the tenant; a preset or a pipeline
+// replaces a single action. Presets, logos and fonts of other tenants are not found.
+func prepareAction(storage ImageSqlSaver, imgStorage img_storage.ImageStorage, tenantID int, action, presetName, rawParameters, rawPipeline string) (
+	string, *models.ActionParameters, []models.Operation, error) {
+	var parameters *models.ActionParameters
+	var pipeline []models.Operation
+	var err error
+	switch {
+	case presetName != "":
+		err = checkPreset(storage, tenantID, presetName, action)
+		action = models.PresetAction
+	case rawPipeline != "":
+		pipeline, err = parsePipeline(rawPipeline, action)
+		action = models.PipelineAction
+	default:
+		parameters, err = parseActionParameters(rawParameters, action)
+	}
+	if err == nil {
+		err = checkThumbnailSizes(imgStorage, parameters, pipeline)
+	}
+	if err == nil {
+		err = checkWatermarkAssets(storage, tenantID, parameters, pipeline)
+	}
+	if err != nil {
+		return "", nil, nil, err
+	}
+	return action, parameters, pipeline, nil
+}
+
+// parseActionParameters decodes JSON parameters of the action
+// and validates them against the action
+func parseActionParameters(raw, action string) (*models.ActionParameters, error) {
+	parameters := &models.ActionParameters{}
+	if raw != "" {
+		decoder := json.NewDecoder(strings.NewReader(raw))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(parameters); err != nil {
+			return nil, fmt.Errorf("invalid parameters: %w", err)
+		}
+	}
+	if err := parameters.Validate(action); err != nil {
+		return nil, err
+	}
+	return parameters, nil
+}
+
+// parsePipeline decodes an ordered list of operations;
+// the action form value may be omitted or set to "pipeline"
+func parsePipeline(raw, action string) ([]models.Operation, error) {
+	if action != "" && action != models.PipelineAction {
+		return nil, fmt.Errorf("pipeline can not be combined with action %q", action)
+	}
+
+	var pipeline []models.Operation
+	decoder := json.NewDecoder(strings.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&pipeline); err != nil {
+		return nil, fmt.Errorf("invalid pipeline: %w", err)
+	}
+	if err := models.ValidatePipeline(pipeline); err != nil {
+		return nil, err
+	}
+	return pipeline, nil
+}
+
+// checkPreset makes sure the referenced preset exists; its definition
+// is resolved later by the worker to apply the latest version
+func checkPreset(storage ImageSqlSaver, tenantID int, name, action string) error {
+	if action != "" && action != models.PresetAction {
+		return fmt.Errorf("preset can not be combined with action %q", action)
+	}
+	if err := models.ValidatePresetName(name); err != nil {
+		return err
+	}
+	if _, err := storage.GetPreset(tenantID, name); err != nil {
+		if errors.Is(err, storagePkg.ErrPresetNotFound) {
+			return fmt.Errorf("preset %q not found", name)
+		}
+		return err
+	}
+	return nil
+}
+
+// checkThumbnailSizes rejects miniature sizes which are not configured
+func checkThumbnailSizes(imgStorage img_storage.ImageStorage, parameters *models.ActionParameters, pipeline []models.Operation) error {
+	all := []*models.ActionParameters{parameters}
+	for _, step := range pipeline {
+		all = append(all, step.Parameters)
+	}
+	for _, p := range all {
+		if p == nil || p.Miniature == nil {
+			continue
+		}
+		for _, size := range p.Miniature.Sizes {
+			if !imgStorage.IsThumbnailSize(size) {
+				return fmt.Errorf("thumbnail size %d is not available, use one of %v", size, imgStorage.ThumbnailSizes)
+			}
+		}
+	}
+	return nil
+}
+
+// DownloadImage handler implementation;
+// it always streams the original, with ?format=json the original is sent
+// base64 encoded together with the list of derivatives
+func DownloadImage(log *slog.Logger, storage ImageSqlSaver, imgStorage img_storage.ImageStorage) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		const op = "sqlite.GetImageMetadata"
 
@@ -193,49 +344,54 @@ func DownloadImage(log *slog.Logger, storage ImageSqlSaver) func(http.ResponseWr
 			return
 		}
 
-		// TODO: to call sql storage to get metadata
-		// Create check status of gotten image
-		metadata, err := storage.GetImageMetadata(intID)
-		if err != nil {
-			log.Error("getting data error", "op", op, "err", err)
-			http.Error(w, "Internal error", http.StatusInternalServerError)
+		metadata, ok := loadImage(w, r, log, op, storage, intID)
+		if !ok {
 			return
 		}
-		// if status is pending or processing
-		if metadata.Status != "modified" {
-			resp := ImageActionResponse{
-				Status:  metadata.Status,
-				Message: "Server is handling an image",
-				ImageID: intID,
-				Action:  metadata.Action,
+
+		if !wantsJSON(r) {
+			original := storedFile{Key: metadata.OriginalPath, MimeType: metadata.MimeType, Checksum: metadata.Checksum}
+			if err = serveFile(w, r, imgStorage, original); err != nil {
+				log.Error("serving original image error", "op", op, "err", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 			}
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusAccepted)
-			_ = json.NewEncoder(w).Encode(resp)
 			return
 		}
-		// TODO: to call image storage
-		image, err := img_storage.GetUpdatedImage(metadata.OriginalPath)
+
+		derivatives, err := storage.GetDerivatives(intID)
 		if err != nil {
-			log.Error("Get updated image error", "op", op, "err", err)
+			log.Error("getting derivatives error", "op", op, "err", err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+
+		image, err := imgStorage.GetUpdatedImage(r.Context(), metadata.OriginalPath)
+		if err != nil {
+			log.Error("Get original image error", "op", op, "err", err)
 			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 			return
 		}
 
-		var preparedRespMessage string
-		switch metadata.Action {
-		case "resize":
-			preparedRespMessage = "Imaged was resized"
-		case "miniature":
-			preparedRespMessage = "Imaged was miniatured"
-		case "watermark":
-			preparedRespMessage = "Watermark was added to image"
+		// if status is pending or processing there are no results yet
+		preparedRespMessage := "Server is handling an image"
+		if metadata.Status == modifiedStatus {
+			preparedRespMessage = actionMessage(metadata.Action)
 		}
 
 		respWithImage := ImageIncludedResponse{
-			Status:  http.StatusText(http.StatusOK),
-			Image:   image,
-			Message: preparedRespMessage,
+			Status:      http.StatusText(http.StatusOK),
+			Image:       image,
+			MimeType:    metadata.MimeType,
+			Message:     preparedRespMessage,
+			ImageStatus: metadata.Status,
+			Derivatives: make([]DerivativeResponse, 0, len(derivatives)),
+		}
+		for _, derivative := range derivatives {
+			respWithImage.Derivatives = append(respWithImage.Derivatives, DerivativeResponse{
+				ID:     derivative.Id,
+				Action: derivative.Action,
+				URL:    fmt.Sprintf("/image/%d/derivatives/%d", intID, derivative.Id),
+			})
 		}
 
 		w.Header().Set("Content-Type", "application/json")
@@ -244,53 +400,283 @@ func DownloadImage(log *slog.Logger, storage ImageSqlSaver) func(http.ResponseWr
 	}
 }
 
-func DeleteImage(log *slog.Logger, storage ImageSqlSaver) func(http.ResponseWriter, *http.Request) {
+// DownloadDerivative streams a result of an action over the original image,
+// ?format=json keeps the base64 JSON response
+func DownloadDerivative(log *slog.Logger, storage ImageSqlSaver, imgStorage img_storage.ImageStorage) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		const op = "sqlite.DeleteImage"
+		const op = "handlers.DownloadDerivative"
+
+		intID, err := strconv.Atoi(chi.URLParam(r, idQueryParameter))
+		if err != nil {
+			log.Error("id parameter is not number type", "op", op, "err", err)
+			http.Error(w, "incorrect id parameter", http.StatusBadRequest)
+			return
+		}
+		derivativeID, err := strconv.Atoi(chi.URLParam(r, derivativeIdParameter))
+		if err != nil {
+			log.Error("derivative id parameter is not number type", "op", op, "err", err)
+			http.Error(w, "incorrect derivative id parameter", http.StatusBadRequest)
+			return
+		}
 
-		id := chi.URLParam(r, idQueryParameter)
+		if _, ok := loadImage(w, r, log, op, storage, intID); !ok {
+			return
+		}
 
-		if id == "" {
-			log.Error("id parameter is empty", "op", op)
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte("id is required"))
+		derivative, err := storage.GetDerivative(intID, derivativeID)
+		if errors.Is(err, storagePkg.ErrDerivativeNotFound) {
+			http.Error(w, "derivative not found", http.StatusNotFound)
 			return
 		}
-		intID, err := strconv.Atoi(id)
+		if err != nil {
+			log.Error("getting derivative error", "op", op, "err", err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+
+		if !wantsJSON(r) {
+			file := storedFile{Key: derivative.Path, MimeType: derivative.MimeType, Checksum: derivative.Checksum}
+			if err = serveFile(w, r, imgStorage, file); err != nil {
+				log.Error("serving derivative error", "op", op, "err", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			}
+			return
+		}
+
+		image, err := imgStorage.GetUpdatedImage(r.Context(), derivative.Path)
+		if err != nil {
+			log.Error("Get derivative image error", "op", op, "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		respWithImage := ImageIncludedResponse{
+			Status:   http.StatusText(http.StatusOK),
+			Image:    image,
+			MimeType: derivative.MimeType,
+			Message:  actionMessage(derivative.Action),
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(respWithImage)
+	}
+}
+
+// DownloadThumbnail streams the square miniature of the configured size,
+// ?format=json keeps the base64 JSON response
+func DownloadThumbnail(log *slog.Logger, storage ImageSqlSaver, imgStorage img_storage.ImageStorage) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.DownloadThumbnail"
+
+		intID, err := strconv.Atoi(chi.URLParam(r, idQueryParameter))
 		if err != nil {
 			log.Error("id parameter is not number type", "op", op, "err", err)
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte("invalid id"))
+			http.Error(w, "incorrect id parameter", http.StatusBadRequest)
+			return
+		}
+		size, err := strconv.Atoi(chi.URLParam(r, sizeParameter))
+		if err != nil || !imgStorage.IsThumbnailSize(size) {
+			http.Error(w, fmt.Sprintf("thumbnail size must be one of %v", imgStorage.ThumbnailSizes), http.StatusNotFound)
+			return
+		}
+
+		if _, ok := loadImage(w, r, log, op, storage, intID); !ok {
+			return
+		}
+
+		derivative, err := storage.GetDerivativeByVariant(intID, models.MiniatureAction, strconv.Itoa(size))
+		if errors.Is(err, storagePkg.ErrDerivativeNotFound) {
+			http.Error(w, "thumbnail not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Error("getting thumbnail error", "op", op, "err", err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+
+		if !wantsJSON(r) {
+			file := storedFile{Key: derivative.Path, MimeType: derivative.MimeType, Checksum: derivative.Checksum}
+			if err = serveFile(w, r, imgStorage, file); err != nil {
+				log.Error("serving thumbnail error", "op", op, "err", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			}
+			return
+		}
+
+		image, err := imgStorage.GetUpdatedImage(r.Context(), derivative.Path)
+		if err != nil {
+			log.Error("Get thumbnail image error", "op", op, "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 			return
 		}
 
-		// first act to get metadata from database
-		metadata, err := storage.GetImageMetadata(intID)
+		respWithImage := ImageIncludedResponse{
+			Status:   http.StatusText(http.StatusOK),
+			Image:    image,
+			MimeType: derivative.MimeType,
+			Message:  actionMessage(derivative.Action),
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(respWithImage)
+	}
+}
+
+// actionMessage describes a result of the action for clients
+func actionMessage(action string) string {
+	switch action {
+	case "resize":
+		return "Imaged was resized"
+	case "miniature":
+		return "Imaged was miniatured"
+	case "watermark":
+		return "Watermark was added to image"
+	case "pipeline":
+		return "Pipeline was completed"
+	case "preset":
+		return "Preset is applied"
+	}
+	return ""
+}
+
+// DeleteImage marks the image as deleted and queues its removal;
+// the job of the response tracks cleanup of the files by the worker
+func DeleteImage(log *slog.Logger, storage ImageSqlSaver) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.DeleteImage"
+
+		intID, err := strconv.Atoi(chi.URLParam(r, idQueryParameter))
 		if err != nil {
-			log.Error("getting data error", "op", op, "err", err)
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte("internal error"))
+			log.Error("id parameter is not number type", "op", op, "err", err)
+			http.Error(w, "incorrect id parameter", http.StatusBadRequest)
 			return
 		}
 
-		// firstly delete image itself
-		if metadata.OriginalPath == "" {
-			log.Error("original image path is empty", "op", op, "err", err)
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte("file path empty"))
+		if _, ok := loadImage(w, r, log, op, storage, intID); !ok {
 			return
 		}
 
-		const deletedStatus = "deleted"
-		err = storage.UpdateStatus(intID, deletedStatus)
+		jobID, err := storage.RequestDelete(intID)
+		if errors.Is(err, storagePkg.ErrImageNotFound) {
+			http.Error(w, "image not found", http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, storagePkg.ErrImageDeleted) {
+			http.Error(w, "image is deleted", http.StatusGone)
+			return
+		}
 		if err != nil {
 			log.Error("image deleting is failed", "op", op, "err", err)
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte("Internal server error"))
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+
+		response := ImageActionResponse{
+			Status:    http.StatusText(http.StatusAccepted),
+			Message:   "image is queued for deletion",
+			ImageID:   intID,
+			Action:    models.DeleteAction,
+			JobID:     jobID,
+			JobURL:    fmt.Sprintf("/jobs/%d", jobID),
+			CreatedAt: time.Now().UTC(),
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Location", response.JobURL)
+		w.WriteHeader(http.StatusAccepted)
+		_ = json.NewEncoder(w).Encode(response)
+	}
+}
+
+// loadImage returns metadata of the image; unknown images and images of other tenants
+// are answered with 404 and images waiting for removal with 410
+func loadImage(w http.ResponseWriter, r *http.Request, log *slog.Logger, op string, storage ImageSqlSaver, id int) (*models.ImageMetadata, bool) {
+	metadata, err := storage.GetImageMetadata(id)
+	if errors.Is(err, storagePkg.ErrImageNotFound) || err == nil && !auth.CanAccess(r.Context(), metadata.TenantId) {
+		http.Error(w, "image not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		log.Error("getting data error", "op", op, "err", err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return nil, false
+	}
+	if metadata.Status == deletedStatus {
+		http.Error(w, "image is deleted", http.StatusGone)
+		return nil, false
+	}
+	return metadata, true
+}
+
+// PipelineResponse - execution state of a pipeline and its steps
+type PipelineResponse struct {
+	ID      int                    `json:"id"`
+	ImageID int                    `json:"image_id"`
+	Status  string                 `json:"status"`
+	Steps   []PipelineStepResponse `json:"steps"`
+}
+
+type PipelineStepResponse struct {
+	Position   int                      `json:"position"`
+	Action     string                   `json:"action"`
+	Parameters *models.ActionParameters `json:"parameters,omitempty"`
+	Status     string                   `json:"status"`
+	Error      string                   `json:"error,omitempty"`
+	StartedAt  *time.Time               `json:"started_at,omitempty"`
+	FinishedAt *time.Time               `json:"finished_at,omitempty"`
+	DurationMs int64                    `json:"duration_ms"`
+}
+
+// GetPipeline returns per-step status and timing of the pipeline
+func GetPipeline(log *slog.Logger, storage ImageSqlSaver) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.GetPipeline"
+
+		pipelineID, err := strconv.Atoi(chi.URLParam(r, pipelineIdParameter))
+		if err != nil {
+			log.Error("pipeline id parameter is not number type", "op", op, "err", err)
+			http.Error(w, "incorrect pipeline id parameter", http.StatusBadRequest)
+			return
 		}
 
-		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		pipeline, err := storage.GetPipeline(pipelineID)
+		if errors.Is(err, storagePkg.ErrPipelineNotFound) {
+			http.Error(w, "pipeline not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Error("getting pipeline error", "op", op, "err", err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+		if _, ok := loadImage(w, r, log, op, storage, pipeline.ImageId); !ok {
+			return
+		}
+
+		resp := PipelineResponse{
+			ID:      pipeline.Id,
+			ImageID: pipeline.ImageId,
+			Status:  pipeline.Status,
+			Steps:   make([]PipelineStepResponse, 0, len(pipeline.Steps)),
+		}
+		for _, step := range pipeline.Steps {
+			resp.Steps = append(resp.Steps, PipelineStepResponse{
+				Position:   step.Position,
+				Action:     step.Operation.Action,
+				Parameters: step.Operation.Parameters,
+				Status:     step.Status,
+				Error:      step.Error,
+				StartedAt:  step.StartedAt,
+				FinishedAt: step.FinishedAt,
+				DurationMs: step.DurationMs,
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("image deleted"))
+		_ = json.NewEncoder(w).Encode(resp)
 	}
 }
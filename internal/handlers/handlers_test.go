@@ -1,42 +1,130 @@
 package handlers
 
 import (
+	"bytes"
+	"cmp"
+	"context"
 	"encoding/json"
 	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"imageProcessor/internal/auth"
+	"imageProcessor/internal/blobstore"
+	"imageProcessor/internal/cache"
 	img_storage "imageProcessor/internal/img-storage"
 	"imageProcessor/internal/models"
+	"imageProcessor/internal/signing"
+	storagePkg "imageProcessor/internal/storage"
 	"io"
 	"log/slog"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
 )
 
 type mockStorage struct {
+	originalPath string
+	status       string
+	tenantID     int
+	derivatives  []models.Derivative
+	presets      []models.Preset
+	assets       []models.WatermarkAsset
+	pipelineErr  error
 }
 
-func (ms *mockStorage) SetMetadata(metadata *models.ImageMetadata) (int, error) {
+func (ms *mockStorage) SetUpload(metadata *models.ImageMetadata, pipeline []models.Operation, message *models.KafkaMessage) (int, error) {
 	if metadata == nil {
 		return 0, fmt.Errorf("test error")
 	}
+	message.Id, message.JobId = 1, 1
 	return 1, nil
 }
 
 func (ms *mockStorage) GetImageMetadata(id int) (*models.ImageMetadata, error) {
 	return &models.ImageMetadata{
+		Id:               id,
+		TenantId:         ms.tenantID,
 		OriginalFilename: "img1.png",
-		OriginalPath:     "./uploads/img1.png",
-		MimeType:         "png",
+		OriginalPath:     ms.originalPath,
+		MimeType:         "image/png",
+		Checksum:         "abc123",
 		FileSize:         3 * 1024 * 1024,
-		Status:           "pending",
+		Status:           cmp.Or(ms.status, "pending"),
 		Action:           "resize",
 	}, nil
 }
 
-func (ms *mockStorage) DeleteImage(id int) error {
+func (ms *mockStorage) GetDerivative(imageID, id int) (*models.Derivative, error) {
+	for _, derivative := range ms.derivatives {
+		if derivative.Id == id && derivative.ImageId == imageID {
+			return &derivative, nil
+		}
+	}
+	return nil, fmt.Errorf("test error")
+}
+
+func (ms *mockStorage) GetDerivativeByVariant(imageID int, action, variant string) (*models.Derivative, error) {
+	for _, derivative := range ms.derivatives {
+		if derivative.ImageId == imageID && derivative.Action == action && derivative.Variant == variant {
+			return &derivative, nil
+		}
+	}
+	return nil, fmt.Errorf("test error")
+}
+
+func (ms *mockStorage) GetDerivatives(imageID int) ([]models.Derivative, error) {
+	return ms.derivatives, nil
+}
+
+func (ms *mockStorage) RequestDelete(id int) (int, error) {
+	if ms.status == deletedStatus {
+		return 0, storagePkg.ErrImageDeleted
+	}
+	ms.status = deletedStatus
+	return 7, nil
+}
+
+func (ms *mockStorage) RequestAction(pipeline []models.Operation, message *models.KafkaMessage) error {
+	if ms.status == deletedStatus {
+		return storagePkg.ErrImageDeleted
+	}
+	message.JobId = 9
 	return nil
 }
 
+func (ms *mockStorage) GetPipeline(id int) (*models.Pipeline, error) {
+	return nil, cmp.Or(ms.pipelineErr, storagePkg.ErrPipelineNotFound)
+}
+
+func (ms *mockStorage) GetPreset(tenantID int, name string) (*models.Preset, error) {
+	for _, preset := range ms.presets {
+		if preset.TenantId == tenantID && preset.Name == name {
+			return &preset, nil
+		}
+	}
+	return nil, storagePkg.ErrPresetNotFound
+}
+
+func (ms *mockStorage) GetWatermarkAsset(id int) (*models.WatermarkAsset, error) {
+	for _, asset := range ms.assets {
+		if asset.Id == id {
+			return &asset, nil
+		}
+	}
+	return nil, storagePkg.ErrAssetNotFound
+}
+
+func (ms *mockStorage) GetFont(tenantID int, family, weight string) (*models.Font, error) {
+	return nil, fmt.Errorf("test error")
+}
+
 func TestDownloadImage(t *testing.T) {
 	type args struct {
 		image  []byte
@@ -46,7 +134,6 @@ func TestDownloadImage(t *testing.T) {
 		name string
 		args args
 	}{
-		// TODO: Add test cases.
 		{
 			name: "test1",
 			args: args{
@@ -56,42 +143,542 @@ func TestDownloadImage(t *testing.T) {
 		},
 	}
 
-	mux := http.NewServeMux()
 	log := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
 		AddSource: false,
 		Level:     slog.LevelError,
 	}))
 
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			blobs, err := blobstore.NewFileSystem(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			imgStorage := img_storage.ImageStorage{Blobs: blobs}
+			originalKey := "originals/img1.png"
+			if err := blobs.Put(context.Background(), originalKey, bytes.NewReader(tt.args.image), int64(len(tt.args.image))); err != nil {
+				t.Fatal(err)
+			}
+			storage := &mockStorage{
+				originalPath: originalKey,
+				derivatives:  []models.Derivative{{Id: 7, ImageId: 1, Action: tt.args.action}},
+			}
+
+			router := chi.NewRouter()
+			router.Get("/image/{id}", DownloadImage(log, storage, imgStorage))
+			server := httptest.NewServer(router)
+			defer server.Close()
+
+			resp, err := http.Get(server.URL + "/image/1?format=json")
+			if err != nil {
+				t.Fatal("get request failed")
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("unexpected status code %d", resp.StatusCode)
+			}
+
+			var respStruct ImageIncludedResponse
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal(body, &respStruct); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(respStruct.Image, tt.args.image) {
+				t.Errorf("original image is expected, got %q", respStruct.Image)
+			}
+			if len(respStruct.Derivatives) != 1 || respStruct.Derivatives[0].URL != "/image/1/derivatives/7" {
+				t.Errorf("unexpected derivatives %+v", respStruct.Derivatives)
+			}
+		})
+	}
+}
+
+func TestDeleteImage(t *testing.T) {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	storage := &mockStorage{originalPath: "originals/img1.png"}
+
+	router := chi.NewRouter()
+	router.Get("/image/{id}", DownloadImage(log, storage, img_storage.ImageStorage{}))
+	router.Delete("/image/{id}", DeleteImage(log, storage))
+
+	request := func(method string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(method, "/image/1", nil))
+		return recorder
+	}
+
+	deleted := request(http.MethodDelete)
+	if deleted.Code != http.StatusAccepted || deleted.Header().Get("Location") != "/jobs/7" {
+		t.Fatalf("expected 202 with the job location, got %d %q", deleted.Code, deleted.Header().Get("Location"))
+	}
+	var response ImageActionResponse
+	if err := json.Unmarshal(deleted.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.JobID != 7 || response.Action != models.DeleteAction {
+		t.Errorf("unexpected response %+v", response)
+	}
+
+	if got := request(http.MethodGet).Code; got != http.StatusGone {
+		t.Errorf("a deleted image must be gone, got %d", got)
+	}
+	if got := request(http.MethodDelete).Code; got != http.StatusGone {
+		t.Errorf("a repeated delete must report the image is gone, got %d", got)
+	}
+}
+
+func TestRequestImageAction(t *testing.T) {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
 	storage := &mockStorage{}
-	imgStorage := img_storage.ImageStorage{ImgStoragePath: "./uploads"}
 
-	mux.HandleFunc("/", DownloadImage(log, storage, imgStorage))
+	router := chi.NewRouter()
+	router.Post("/image/{id}/actions", RequestImageAction(log, storage, img_storage.ImageStorage{}))
 
-	go func() {
-		if err := http.ListenAndServe("localhost:8080", mux); err != nil {
-			panic(err)
-		}
-	}()
+	tests := []struct {
+		name   string
+		status string
+		body   string
+		code   int
+	}{
+		{name: "resize", body: `{"action":"resize","parameters":{"resize":{"width":300}}}`, code: http.StatusAccepted},
+		{name: "missing parameters", body: `{"action":"resize"}`, code: http.StatusBadRequest},
+		{name: "unknown field", body: `{"action":"resize","image":"abc"}`, code: http.StatusBadRequest},
+		{name: "delete is internal", body: `{"action":"delete"}`, code: http.StatusBadRequest},
+		{name: "deleted image", status: deletedStatus, body: `{"action":"resize","parameters":{"resize":{"width":300}}}`, code: http.StatusGone},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage.status = tt.status
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/image/1/actions", strings.NewReader(tt.body)))
+			if recorder.Code != tt.code {
+				t.Fatalf("expected %d, got %d: %s", tt.code, recorder.Code, recorder.Body.String())
+			}
+			if tt.code == http.StatusAccepted && recorder.Header().Get("Location") != "/jobs/9" {
+				t.Errorf("expected the job location, got %q", recorder.Header().Get("Location"))
+			}
+		})
+	}
+}
 
+func TestDownloadImageRaw(t *testing.T) {
+	content := []byte("0123456789")
+	blobs, err := blobstore.NewFileSystem(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := blobs.Put(context.Background(), "originals/img1.png", bytes.NewReader(content), int64(len(content))); err != nil {
+		t.Fatal(err)
+	}
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	router := chi.NewRouter()
+	router.Get("/image/{id}", DownloadImage(log, &mockStorage{originalPath: "originals/img1.png"}, img_storage.ImageStorage{Blobs: blobs}))
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		status  int
+		body    string
+		rng     string
+	}{
+		{name: "full", status: http.StatusOK, body: "0123456789"},
+		{name: "range", headers: map[string]string{"Range": "bytes=2-4"}, status: http.StatusPartialContent, body: "234", rng: "bytes 2-4/10"},
+		{name: "suffix range", headers: map[string]string{"Range": "bytes=-3"}, status: http.StatusPartialContent, body: "789", rng: "bytes 7-9/10"},
+		{name: "unsatisfiable range", headers: map[string]string{"Range": "bytes=20-"}, status: http.StatusRequestedRangeNotSatisfiable, rng: "bytes */10"},
+		{name: "stale if-range", headers: map[string]string{"Range": "bytes=2-4", "If-Range": `"other"`}, status: http.StatusOK, body: "0123456789"},
+		{name: "not modified", headers: map[string]string{"If-None-Match": `"abc123"`}, status: http.StatusNotModified},
+		{name: "changed", headers: map[string]string{"If-None-Match": `"other"`}, status: http.StatusOK, body: "0123456789"},
+	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			body, err := json.Marshal(tt.args)
-			if err != nil {
-				t.Error(err)
+			req, _ := http.NewRequest(http.MethodGet, server.URL+"/image/1", nil)
+			for key, value := range tt.headers {
+				req.Header.Set(key, value)
 			}
-			// TODO: to fix body parameter in test post request
-			resp, err := http.Post("/", "application/json", body)
+			resp, err := http.DefaultClient.Do(req)
 			if err != nil {
-				t.Error("post request failed")
+				t.Fatal(err)
 			}
+			defer resp.Body.Close()
 
-			var respStruct ImageIncludedResponse
-			//if respBody.Status
-			body, err = io.ReadAll(resp.Body)
-			if err != nil {
-				panic(err)
+			if resp.StatusCode != tt.status {
+				t.Fatalf("unexpected status code %d", resp.StatusCode)
+			}
+			if resp.Header.Get("ETag") != `"abc123"` {
+				t.Errorf("unexpected etag %q", resp.Header.Get("ETag"))
+			}
+			if resp.Header.Get("Content-Range") != tt.rng {
+				t.Errorf("unexpected content range %q", resp.Header.Get("Content-Range"))
+			}
+			if tt.body == "" {
+				return
+			}
+			body, _ := io.ReadAll(resp.Body)
+			if string(body) != tt.body {
+				t.Errorf("unexpected body %q", body)
+			}
+			if resp.Header.Get("Content-Type") != "image/png" {
+				t.Errorf("unexpected content type %q", resp.Header.Get("Content-Type"))
 			}
-			json.Unmarshal(body, &respStruct)
 		})
 	}
 }
+
+func TestTransformImage(t *testing.T) {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	blobs, err := blobstore.NewFileSystem(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var original bytes.Buffer
+	if err = png.Encode(&original, image.NewNRGBA(image.Rect(0, 0, 400, 300))); err != nil {
+		t.Fatal(err)
+	}
+	if err = blobs.Put(context.Background(), "originals/img1.png", &original, int64(original.Len())); err != nil {
+		t.Fatal(err)
+	}
+	variants, err := cache.NewDisk(context.Background(), t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storage := &mockStorage{originalPath: "originals/img1.png"}
+	imgStorage := img_storage.ImageStorage{Blobs: blobs}
+	router := chi.NewRouter()
+	router.Get("/image/{id}", TransformImage(log, storage, imgStorage, variants, TransformLimits{MaxWidth: 1000, MaxHeight: 1000},
+		DownloadImage(log, storage, imgStorage)))
+
+	request := func(target, etag string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		router.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	first := request("/image/1?w=100&h=50&fit=cover&fmt=jpeg&q=80", "")
+	if first.Code != http.StatusOK || first.Header().Get("X-Cache") != "MISS" || first.Header().Get("Content-Type") != "image/jpeg" {
+		t.Fatalf("expected a computed jpeg, got %d %v", first.Code, first.Header())
+	}
+	img, err := jpeg.Decode(first.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := img.Bounds().Size(); size.X != 100 || size.Y != 50 {
+		t.Errorf("expected 100x50 variant, got %v", size)
+	}
+
+	// the same variant asked with another order and spelling of parameters
+	second := request("/image/1?q=80&fmt=jpg&fit=fill&h=50&w=100", "")
+	if second.Code != http.StatusOK || second.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("expected the cached variant, got %d %q", second.Code, second.Header().Get("X-Cache"))
+	}
+	etag := first.Header().Get("ETag")
+	if etag == "" || second.Header().Get("ETag") != etag {
+		t.Errorf("equal variants must have equal ETags, got %q and %q", etag, second.Header().Get("ETag"))
+	}
+	if got := request("/image/1?w=100&h=50&fit=cover&fmt=jpeg&q=80", etag).Code; got != http.StatusNotModified {
+		t.Errorf("expected 304 for a known ETag, got %d", got)
+	}
+
+	// "*" matches only a variant which is already cached
+	if got := request("/image/1?w=100&h=50&fit=cover&fmt=jpeg&q=80", "*").Code; got != http.StatusNotModified {
+		t.Errorf("expected 304 for a cached variant, got %d", got)
+	}
+	if got := request("/image/1?w=120&fmt=png", "*"); got.Code != http.StatusOK || got.Header().Get("X-Cache") != "MISS" {
+		t.Errorf("a variant which is not cached must be computed, got %d %q", got.Code, got.Header().Get("X-Cache"))
+	}
+
+	for _, target := range []string{"/image/1?w=2000", "/image/1?w=100&fit=cover", "/image/1?fmt=png&q=80", "/image/1?w=-1"} {
+		if got := request(target, "").Code; got != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", target, got)
+		}
+	}
+	if got := request("/image/1", "").Header().Get("X-Cache"); got != "" {
+		t.Errorf("the original must be served without transform, got X-Cache %q", got)
+	}
+
+	// only resizing is limited, other transforms of a large original keep its size
+	large := &models.ImageMetadata{MimeType: "image/png", Width: 6000, Height: 4000}
+	limits := TransformLimits{MaxWidth: 4000, MaxHeight: 4000}
+	for raw, allowed := range map[string]bool{"fmt=jpeg": true, "wm=sample": true, "w=300": true, "h=4000": false, "w=6000&fmt=jpeg": false} {
+		query, _ := url.ParseQuery(raw)
+		if _, err = parseTransform(query, large, limits); (err == nil) != allowed {
+			t.Errorf("%s: expected allowed %v, got %v", raw, allowed, err)
+		}
+	}
+}
+
+func TestGetPipelineErrors(t *testing.T) {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	for _, tt := range []struct {
+		err  error
+		want int
+	}{
+		{nil, http.StatusNotFound},
+		{fmt.Errorf("database is locked"), http.StatusInternalServerError},
+	} {
+		router := chi.NewRouter()
+		router.Get("/pipelines/{pipelineId}", GetPipeline(log, &mockStorage{pipelineErr: tt.err}))
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/pipelines/1", nil))
+		if recorder.Code != tt.want {
+			t.Errorf("%v: expected %d, got %d", tt.err, tt.want, recorder.Code)
+		}
+	}
+}
+
+// the limit of watermark text is the same for actions and transform URLs, multibyte text included
+func TestWatermarkTextLimit(t *testing.T) {
+	metadata := &models.ImageMetadata{MimeType: "image/png", Width: 400, Height: 300}
+	for length, allowed := range map[int]bool{models.MaxWatermarkTextLength: true, models.MaxWatermarkTextLength + 1: false} {
+		text := strings.Repeat("я", length)
+		_, transformErr := parseTransform(url.Values{watermarkQueryParameter: {text}}, metadata, TransformLimits{})
+		actionErr := (&models.WatermarkParameters{Text: text}).Validate()
+		if (transformErr == nil) != allowed || (actionErr == nil) != allowed {
+			t.Errorf("%d characters: expected allowed %v, got %v and %v", length, allowed, transformErr, actionErr)
+		}
+	}
+}
+
+// evictedCache reports every variant as cached while the file is already evicted
+type evictedCache struct {
+	*blobstore.FileSystem
+}
+
+func (c evictedCache) Load(ctx context.Context, key string, create func() ([]byte, error)) (bool, error) {
+	return true, nil
+}
+
+func TestTransformImageEvictedVariant(t *testing.T) {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	blobs, err := blobstore.NewFileSystem(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var original bytes.Buffer
+	if err = png.Encode(&original, image.NewNRGBA(image.Rect(0, 0, 400, 300))); err != nil {
+		t.Fatal(err)
+	}
+	if err = blobs.Put(context.Background(), "originals/img1.png", &original, int64(original.Len())); err != nil {
+		t.Fatal(err)
+	}
+	variants, err := blobstore.NewFileSystem(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storage := &mockStorage{originalPath: "originals/img1.png"}
+	imgStorage := img_storage.ImageStorage{Blobs: blobs}
+	handler := TransformImage(log, storage, imgStorage, evictedCache{variants}, TransformLimits{}, DownloadImage(log, storage, imgStorage))
+	router := chi.NewRouter()
+	router.Get("/image/{id}", handler)
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/image/1?w=100&fmt=jpeg", nil))
+	if recorder.Code != http.StatusOK || recorder.Header().Get("Content-Type") != "image/jpeg" || recorder.Header().Get("ETag") == "" {
+		t.Fatalf("an evicted variant must be computed again, got %d %v", recorder.Code, recorder.Header())
+	}
+	img, err := jpeg.Decode(recorder.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := img.Bounds().Size(); size.X != 100 || size.Y != 75 {
+		t.Errorf("expected 100x75 variant, got %v", size)
+	}
+}
+
+func TestSignedImageURL(t *testing.T) {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	blobs, err := blobstore.NewFileSystem(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = blobs.Put(context.Background(), "originals/img1.png", strings.NewReader("original"), 8); err != nil {
+		t.Fatal(err)
+	}
+	signer, err := signing.NewSigner(signing.Config{
+		Keys:       map[string]string{"k1": "0123456789abcdef"},
+		ActiveKey:  "k1",
+		DefaultTTL: time.Minute,
+		MaxTTL:     time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storage := &mockStorage{originalPath: "originals/img1.png", tenantID: 1}
+	keys := mockKeys{
+		auth.HashKey("owner-key"): {Id: 1, TenantId: 1},
+		auth.HashKey("other-key"): {Id: 2, TenantId: 2},
+	}
+	router := chi.NewRouter()
+	router.Group(func(r chi.Router) {
+		r.Use(RequireSignatureOrAPIKey(log, signer, keys, ""))
+		r.Get("/image/{id}", DownloadImage(log, storage, img_storage.ImageStorage{Blobs: blobs}))
+	})
+	router.Group(func(r chi.Router) {
+		r.Use(RequireAPIKey(log, keys, ""))
+		r.Post("/image/{id}/signed-url", SignImageURL(log, storage, signer))
+	})
+
+	request := func(method, target, body, key string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if key != "" {
+			req.Header.Set(apiKeyHeader, key)
+		}
+		router.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	// an API key works on image routes as well as a signature
+	for key, want := range map[string]int{"": http.StatusForbidden, "owner-key": http.StatusOK, "other-key": http.StatusNotFound, "lost-key": http.StatusUnauthorized} {
+		if got := request(http.MethodGet, "/image/1", "", key).Code; got != want {
+			t.Errorf("unsigned url with key %q: expected %d, got %d", key, want, got)
+		}
+	}
+
+	// a signed url is a read grant, only an authenticated caller gets one
+	if got := request(http.MethodPost, "/image/1/signed-url", `{}`, "").Code; got != http.StatusUnauthorized {
+		t.Errorf("an unauthenticated caller must not sign urls, got %d", got)
+	}
+	recorder := httptest.NewRecorder()
+	unauthenticated := chi.NewRouter()
+	unauthenticated.Post("/image/{id}/signed-url", SignImageURL(log, storage, signer))
+	unauthenticated.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/image/1/signed-url", strings.NewReader(`{}`)))
+	if recorder.Code != http.StatusUnauthorized {
+		t.Errorf("signing without a principal must be unauthorized, got %d", recorder.Code)
+	}
+
+	signed := request(http.MethodPost, "/image/1/signed-url", `{"query": "format=json", "ttl": "5m", "bind_ip": true}`, "owner-key")
+	if signed.Code != http.StatusOK {
+		t.Fatalf("expected a signed url, got %d %s", signed.Code, signed.Body)
+	}
+	var response SignURLResponse
+	if err = json.Unmarshal(signed.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := request(http.MethodGet, response.URL, "", ""); got.Code != http.StatusOK || !strings.Contains(got.Body.String(), `"image_status"`) {
+		t.Errorf("the signed url must return the json response, got %d %s", got.Code, got.Body)
+	}
+	// the signature covers the query, the raw original is another variant
+	if got := request(http.MethodGet, strings.Replace(response.URL, "format=json", "format=raw", 1), "", "").Code; got != http.StatusForbidden {
+		t.Errorf("a changed url must be forbidden, got %d", got)
+	}
+
+	for _, body := range []string{`{"variant": "../2"}`, `{"ttl": "48h"}`} {
+		if got := request(http.MethodPost, "/image/1/signed-url", body, "owner-key").Code; got != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", body, got)
+		}
+	}
+}
+
+type mockKeys map[string]*models.APIKey
+
+func (k mockKeys) GetAPIKey(keyHash string) (*models.APIKey, error) {
+	if key, ok := k[keyHash]; ok {
+		return key, nil
+	}
+	return nil, storagePkg.ErrAPIKeyNotFound
+}
+
+func TestImageOwnership(t *testing.T) {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	blobs, err := blobstore.NewFileSystem(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = blobs.Put(context.Background(), "originals/img1.png", strings.NewReader("original"), 8); err != nil {
+		t.Fatal(err)
+	}
+	keys := mockKeys{
+		auth.HashKey("owner-key"): {Id: 1, TenantId: 1},
+		auth.HashKey("other-key"): {Id: 2, TenantId: 2},
+	}
+
+	router := chi.NewRouter()
+	router.Use(RequireAPIKey(log, keys, "configured-admin-key"))
+	router.Get("/image/{id}", DownloadImage(log, &mockStorage{originalPath: "originals/img1.png", tenantID: 1}, img_storage.ImageStorage{Blobs: blobs}))
+	router.Delete("/image/{id}", DeleteImage(log, &mockStorage{tenantID: 1}))
+
+	request := func(method, key string) int {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(method, "/image/1", nil)
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		router.ServeHTTP(recorder, req)
+		return recorder.Code
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		key    string
+		want   int
+	}{
+		{"without key", http.MethodGet, "", http.StatusUnauthorized},
+		{"unknown key", http.MethodGet, "lost-key", http.StatusUnauthorized},
+		{"other tenant", http.MethodGet, "other-key", http.StatusNotFound},
+		{"other tenant deletes", http.MethodDelete, "other-key", http.StatusNotFound},
+		{"owner", http.MethodGet, "owner-key", http.StatusOK},
+		{"admin", http.MethodGet, "configured-admin-key", http.StatusOK},
+		{"owner deletes", http.MethodDelete, "owner-key", http.StatusAccepted},
+	}
+	for _, tt := range tests {
+		if got := request(tt.method, tt.key); got != tt.want {
+			t.Errorf("%s: expected %d, got %d", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestTenantResources(t *testing.T) {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	keys := mockKeys{
+		auth.HashKey("owner-key"): {Id: 1, TenantId: 1},
+		auth.HashKey("other-key"): {Id: 2, TenantId: 2},
+	}
+	storage := &mockStorage{
+		tenantID: 1,
+		presets:  []models.Preset{{TenantId: 1, Name: "own"}, {TenantId: 2, Name: "foreign"}},
+		assets:   []models.WatermarkAsset{{Id: 1, TenantId: 1}, {Id: 2, TenantId: 2}},
+	}
+
+	router := chi.NewRouter()
+	router.Use(RequireAPIKey(log, keys, "configured-admin-key"))
+	router.Post("/image/{id}/actions", RequestImageAction(log, storage, img_storage.ImageStorage{}))
+
+	// presets and logos of the action belong to the owner of the image, even when an admin asks for it
+	tests := []struct {
+		name string
+		key  string
+		body string
+		want int
+	}{
+		{"own preset", "owner-key", `{"preset": "own"}`, http.StatusAccepted},
+		{"preset of other tenant", "owner-key", `{"preset": "foreign"}`, http.StatusBadRequest},
+		{"own logo", "owner-key", `{"action": "watermark", "parameters": {"watermark": {"logo": {"asset_id": 1}}}}`, http.StatusAccepted},
+		{"logo of other tenant", "owner-key", `{"action": "watermark", "parameters": {"watermark": {"logo": {"asset_id": 2}}}}`, http.StatusBadRequest},
+		{"admin with logo of other tenant", "configured-admin-key", `{"action": "watermark", "parameters": {"watermark": {"logo": {"asset_id": 2}}}}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/image/1/actions", strings.NewReader(tt.body))
+		req.Header.Set(apiKeyHeader, tt.key)
+		router.ServeHTTP(recorder, req)
+		if recorder.Code != tt.want {
+			t.Errorf("%s: expected %d, got %d %s", tt.name, tt.want, recorder.Code, recorder.Body)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// HealthResponse - state of the component and of its dependencies
+type HealthResponse struct {
+	Status    string            `json:"status"` // "ok" or "unavailable"
+	Component string            `json:"component"`
+	Checks    map[string]string `json:"checks,omitempty"`
+}
+
+// Health reports 200 when every check passes and 503 otherwise
+func Health(component string, checks map[string]func() error) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp := HealthResponse{Status: "ok", Component: component, Checks: make(map[string]string, len(checks))}
+		status := http.StatusOK
+		for name, check := range checks {
+			if err := check(); err != nil {
+				resp.Checks[name] = err.Error()
+				resp.Status = "unavailable"
+				status = http.StatusServiceUnavailable
+				continue
+			}
+			resp.Checks[name] = "ok"
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(resp)
+	}
+}
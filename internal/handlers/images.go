@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"imageProcessor/internal/auth"
+	"imageProcessor/internal/models"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type ImageLister interface {
+	ListImages(tenantID int, allTenants bool, afterID, limit int) ([]models.ImageMetadata, error)
+}
+
+// bounds of the page of GET /images
+const (
+	defaultImageListLimit = 50
+	maxImageListLimit     = 200
+)
+
+type ImageListResponse struct {
+	Images      []ImageSummaryResponse `json:"images"`
+	NextAfterID int                    `json:"next_after_id,omitempty"` // set when there can be more images
+}
+
+type ImageSummaryResponse struct {
+	ID               int       `json:"id"`
+	TenantID         int       `json:"tenant_id,omitempty"`
+	OriginalFilename string    `json:"original_filename"`
+	MimeType         string    `json:"mime_type"`
+	Width            int       `json:"width"`
+	Height           int       `json:"height"`
+	FileSize         int       `json:"file_size"`
+	Status           string    `json:"status"`
+	Action           string    `json:"action"`
+	URL              string    `json:"url"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+// ListImages returns a page of images of the caller ordered by id, ?after_id=&limit=;
+// an admin key sees images of all tenants
+func ListImages(log *slog.Logger, storage ImageLister) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.ListImages"
+
+		query := r.URL.Query()
+		afterID, limit := 0, defaultImageListLimit
+		var err error
+		if raw := query.Get("after_id"); raw != "" {
+			if afterID, err = strconv.Atoi(raw); err != nil || afterID < 0 {
+				http.Error(w, "after_id must be a non-negative number", http.StatusBadRequest)
+				return
+			}
+		}
+		if raw := query.Get("limit"); raw != "" {
+			if limit, err = strconv.Atoi(raw); err != nil || limit <= 0 || limit > maxImageListLimit {
+				http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxImageListLimit), http.StatusBadRequest)
+				return
+			}
+		}
+
+		tenantID, allTenants := tenantScope(r)
+		images, err := storage.ListImages(tenantID, allTenants, afterID, limit)
+		if err != nil {
+			log.Error("listing images error", "op", op, "err", err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+
+		resp := ImageListResponse{Images: make([]ImageSummaryResponse, 0, len(images))}
+		for _, image := range images {
+			resp.Images = append(resp.Images, ImageSummaryResponse{
+				ID:               image.Id,
+				TenantID:         image.TenantId,
+				OriginalFilename: image.OriginalFilename,
+				MimeType:         image.MimeType,
+				Width:            image.Width,
+				Height:           image.Height,
+				FileSize:         image.FileSize,
+				Status:           image.Status,
+				Action:           image.Action,
+				URL:              fmt.Sprintf("/image/%d", image.Id),
+				CreatedAt:        image.CreatedAt,
+			})
+		}
+		if len(images) == limit {
+			resp.NextAfterID = images[len(images)-1].Id
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// tenantScope returns the tenant of the caller; allTenants is set for an admin key
+// and when authentication is disabled, such callers list resources of every tenant
+func tenantScope(r *http.Request) (tenantID int, allTenants bool) {
+	principal := auth.FromContext(r.Context())
+	if principal == nil {
+		return 0, true
+	}
+	return principal.TenantId, principal.Admin
+}
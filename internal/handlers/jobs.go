@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"imageProcessor/internal/auth"
+	"imageProcessor/internal/models"
+	storagePkg "imageProcessor/internal/storage"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type JobStorage interface {
+	GetJob(id int) (*models.Job, error)
+	GetJobDerivatives(jobID int) ([]models.Derivative, error)
+}
+
+// JobResponse - lifecycle of an asynchronous job with links to its results
+type JobResponse struct {
+	ID          int                  `json:"id"`
+	ImageID     int                  `json:"image_id"`
+	Action      string               `json:"action"`
+	Status      string               `json:"status"` // pending, processing, modified, deleted, failed
+	Attempts    int                  `json:"attempts"`
+	Error       string               `json:"error,omitempty"`
+	QueuedAt    time.Time            `json:"queued_at"`
+	StartedAt   *time.Time           `json:"started_at,omitempty"`
+	FinishedAt  *time.Time           `json:"finished_at,omitempty"`
+	ImageURL    string               `json:"image_url"`
+	PipelineURL string               `json:"pipeline_url,omitempty"`
+	Results     []DerivativeResponse `json:"results"`
+}
+
+// GetJob returns the status of the job and links to the derivatives it produced
+func GetJob(log *slog.Logger, storage JobStorage) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.GetJob"
+
+		jobID, err := strconv.Atoi(chi.URLParam(r, jobIdParameter))
+		if err != nil {
+			log.Error("job id parameter is not number type", "op", op, "err", err)
+			http.Error(w, "incorrect job id parameter", http.StatusBadRequest)
+			return
+		}
+
+		job, err := storage.GetJob(jobID)
+		if errors.Is(err, storagePkg.ErrJobNotFound) || err == nil && !auth.CanAccess(r.Context(), job.TenantId) {
+			http.Error(w, "job not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Error("getting job error", "op", op, "err", err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+
+		derivatives, err := storage.GetJobDerivatives(jobID)
+		if err != nil {
+			log.Error("getting job derivatives error", "op", op, "err", err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+
+		resp := JobResponse{
+			ID:         job.Id,
+			ImageID:    job.ImageId,
+			Action:     job.Action,
+			Status:     job.Status,
+			Attempts:   job.Attempts,
+			Error:      job.Error,
+			QueuedAt:   job.QueuedAt,
+			StartedAt:  job.StartedAt,
+			FinishedAt: job.FinishedAt,
+			ImageURL:   fmt.Sprintf("/image/%d", job.ImageId),
+			Results:    make([]DerivativeResponse, 0, len(derivatives)),
+		}
+		if job.PipelineId != 0 {
+			resp.PipelineURL = fmt.Sprintf("/pipelines/%d", job.PipelineId)
+		}
+		for _, derivative := range derivatives {
+			resp.Results = append(resp.Results, DerivativeResponse{
+				ID:     derivative.Id,
+				Action: derivative.Action,
+				URL:    fmt.Sprintf("/image/%d/derivatives/%d", job.ImageId, derivative.Id),
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(resp)
+	}
+}
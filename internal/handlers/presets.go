@@ -0,0 +1,234 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	img_storage "imageProcessor/internal/img-storage"
+	"imageProcessor/internal/models"
+	storagePkg "imageProcessor/internal/storage"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type PresetStorage interface {
+	WatermarkAssetGetter
+	CreatePreset(preset *models.Preset) (int, error)
+	UpdatePreset(preset *models.Preset) (int, error)
+	GetPreset(tenantID int, name string) (*models.Preset, error)
+	ListPresets(tenantID int, allTenants bool) ([]models.Preset, error)
+	DeletePreset(tenantID int, name string) error
+}
+
+const presetNameParameter = "name"
+
+// PresetRequest - body of create and update preset requests;
+// name is taken from the URL on update
+type PresetRequest struct {
+	Name       string                   `json:"name"`
+	Action     string                   `json:"action"`
+	Parameters *models.ActionParameters `json:"parameters,omitempty"`
+	Pipeline   []models.Operation       `json:"pipeline,omitempty"`
+}
+
+type PresetResponse struct {
+	ID         int                      `json:"id"`
+	TenantID   int                      `json:"tenant_id,omitempty"`
+	Name       string                   `json:"name"`
+	Version    int                      `json:"version"`
+	Action     string                   `json:"action"`
+	Parameters *models.ActionParameters `json:"parameters,omitempty"`
+	Pipeline   []models.Operation       `json:"pipeline,omitempty"`
+	CreatedAt  time.Time                `json:"created_at"`
+	UpdatedAt  time.Time                `json:"updated_at"`
+}
+
+func toPresetResponse(preset *models.Preset) PresetResponse {
+	return PresetResponse{
+		ID:         preset.Id,
+		TenantID:   preset.TenantId,
+		Name:       preset.Name,
+		Version:    preset.Version,
+		Action:     preset.Action,
+		Parameters: preset.Parameters,
+		Pipeline:   preset.Pipeline,
+		CreatedAt:  preset.CreatedAt,
+		UpdatedAt:  preset.UpdatedAt,
+	}
+}
+
+// decodePreset reads the preset definition of the caller's tenant from the request body;
+// the name from the URL is used when the body omits it
+func decodePreset(r *http.Request, name string, storage PresetStorage, imgStorage img_storage.ImageStorage) (*models.Preset, error) {
+	var req PresetRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		return nil, err
+	}
+	if req.Name == "" {
+		req.Name = name
+	}
+	if name != "" && req.Name != name {
+		return nil, fmt.Errorf("preset can not be renamed")
+	}
+
+	tenantID, _ := tenantScope(r)
+	preset := &models.Preset{
+		TenantId:   tenantID,
+		Name:       req.Name,
+		Action:     req.Action,
+		Parameters: req.Parameters,
+		Pipeline:   req.Pipeline,
+	}
+	if err := preset.Validate(); err != nil {
+		return nil, err
+	}
+	if err := checkThumbnailSizes(imgStorage, preset.Parameters, preset.Pipeline); err != nil {
+		return nil, err
+	}
+	if err := checkWatermarkAssets(storage, preset.TenantId, preset.Parameters, preset.Pipeline); err != nil {
+		return nil, err
+	}
+	return preset, nil
+}
+
+func writePreset(w http.ResponseWriter, status int, preset *models.Preset) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(toPresetResponse(preset))
+}
+
+// CreatePreset handler stores a new named action or pipeline
+func CreatePreset(log *slog.Logger, storage PresetStorage, imgStorage img_storage.ImageStorage) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.CreatePreset"
+
+		preset, err := decodePreset(r, "", storage, imgStorage)
+		if err != nil {
+			log.Warn("preset is invalid", "op", op, "err", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		preset.Id, err = storage.CreatePreset(preset)
+		if errors.Is(err, storagePkg.ErrPresetExists) {
+			http.Error(w, "preset already exists", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			log.Error("creating preset failed", "op", op, "err", err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+
+		created, err := storage.GetPreset(preset.TenantId, preset.Name)
+		if err != nil {
+			log.Error("getting preset failed", "op", op, "err", err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+		writePreset(w, http.StatusCreated, created)
+	}
+}
+
+// UpdatePreset handler replaces the definition and increments the preset version
+func UpdatePreset(log *slog.Logger, storage PresetStorage, imgStorage img_storage.ImageStorage) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.UpdatePreset"
+
+		name := chi.URLParam(r, presetNameParameter)
+		preset, err := decodePreset(r, name, storage, imgStorage)
+		if err != nil {
+			log.Warn("preset is invalid", "op", op, "err", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		_, err = storage.UpdatePreset(preset)
+		if errors.Is(err, storagePkg.ErrPresetNotFound) {
+			http.Error(w, "preset not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Error("updating preset failed", "op", op, "err", err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+
+		updated, err := storage.GetPreset(preset.TenantId, name)
+		if err != nil {
+			log.Error("getting preset failed", "op", op, "err", err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+		writePreset(w, http.StatusOK, updated)
+	}
+}
+
+// GetPreset returns the preset of the caller's tenant; presets of other tenants are not found
+func GetPreset(log *slog.Logger, storage PresetStorage) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.GetPreset"
+
+		tenantID, _ := tenantScope(r)
+		preset, err := storage.GetPreset(tenantID, chi.URLParam(r, presetNameParameter))
+		if errors.Is(err, storagePkg.ErrPresetNotFound) {
+			http.Error(w, "preset not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Error("getting preset failed", "op", op, "err", err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+		writePreset(w, http.StatusOK, preset)
+	}
+}
+
+// ListPresets returns presets of the caller; an admin key sees presets of all tenants
+func ListPresets(log *slog.Logger, storage PresetStorage) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.ListPresets"
+
+		tenantID, allTenants := tenantScope(r)
+		presets, err := storage.ListPresets(tenantID, allTenants)
+		if err != nil {
+			log.Error("listing presets failed", "op", op, "err", err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+
+		resp := make([]PresetResponse, 0, len(presets))
+		for i := range presets {
+			resp = append(resp, toPresetResponse(&presets[i]))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// DeletePreset removes the preset of the caller's tenant
+func DeletePreset(log *slog.Logger, storage PresetStorage) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.DeletePreset"
+
+		tenantID, _ := tenantScope(r)
+		err := storage.DeletePreset(tenantID, chi.URLParam(r, presetNameParameter))
+		if errors.Is(err, storagePkg.ErrPresetNotFound) {
+			http.Error(w, "preset not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Error("deleting preset failed", "op", op, "err", err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
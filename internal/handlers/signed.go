@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"imageProcessor/internal/auth"
+	"imageProcessor/internal/signing"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// signedVariants are paths after /image/{id} a URL can be signed for
+var signedVariants = regexp.MustCompile(`^((derivatives|thumbnail)/[0-9]+)?$`)
+
+// SignURLRequest describes the variant of the image a signed URL grants
+type SignURLRequest struct {
+	Variant string `json:"variant,omitempty"` // derivatives/{id}, thumbnail/{size}; empty for the original
+	Query   string `json:"query,omitempty"`   // e.g. w=300&h=200&fit=cover or format=json
+	TTL     string `json:"ttl,omitempty"`     // e.g. 15m, signing.default_ttl by default
+	BindIP  bool   `json:"bind_ip,omitempty"` // the URL works only from the IP of the caller
+}
+
+type SignURLResponse struct {
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// SignImageURL returns a URL of the image variant which works until it expires;
+// only a caller authenticated by an API key gets one
+func SignImageURL(log *slog.Logger, storage ImageSqlSaver, signer *signing.Signer) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.SignImageURL"
+
+		if auth.FromContext(r.Context()) == nil {
+			http.Error(w, "api key is required", http.StatusUnauthorized)
+			return
+		}
+
+		intID, err := strconv.Atoi(chi.URLParam(r, idQueryParameter))
+		if err != nil {
+			log.Error("id parameter is not number type", "op", op, "err", err)
+			http.Error(w, "incorrect id parameter", http.StatusBadRequest)
+			return
+		}
+
+		var request SignURLRequest
+		decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxActionRequestSize))
+		decoder.DisallowUnknownFields()
+		if err = decoder.Decode(&request); err != nil {
+			http.Error(w, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+			return
+		}
+		if !signedVariants.MatchString(request.Variant) {
+			http.Error(w, "variant must be empty, derivatives/{id} or thumbnail/{size}", http.StatusBadRequest)
+			return
+		}
+		query, err := url.ParseQuery(request.Query)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid query: %v", err), http.StatusBadRequest)
+			return
+		}
+		var ttl time.Duration
+		if request.TTL != "" {
+			if ttl, err = time.ParseDuration(request.TTL); err != nil {
+				http.Error(w, fmt.Sprintf("invalid ttl: %v", err), http.StatusBadRequest)
+				return
+			}
+		}
+		expiresAt, err := signer.ExpiresAt(ttl)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if _, ok := loadImage(w, r, log, op, storage, intID); !ok {
+			return
+		}
+
+		claims := signing.Claims{
+			ImageID:   intID,
+			Variant:   signing.Variant(request.Variant, query),
+			ExpiresAt: expiresAt,
+		}
+		if request.BindIP {
+			claims.ClientIP = clientIP(r)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(SignURLResponse{URL: signer.Sign(claims), ExpiresAt: expiresAt.UTC()})
+	}
+}
+
+// RequireSignature lets through only requests of image URLs signed by the signer
+// which are not expired; the URL covers the path after /image/{id} and the query
+func RequireSignature(log *slog.Logger, signer *signing.Signer) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			const op = "handlers.RequireSignature"
+
+			id := chi.URLParam(r, idQueryParameter)
+			intID, err := strconv.Atoi(id)
+			if err != nil {
+				http.Error(w, "incorrect id parameter", http.StatusBadRequest)
+				return
+			}
+
+			path := strings.TrimPrefix(r.URL.Path, "/image/"+id)
+			err = signer.Verify(intID, path, r.URL.Query(), clientIP(r))
+			if errors.Is(err, signing.ErrInvalidSignature) || errors.Is(err, signing.ErrUnknownKey) {
+				log.Warn("image url signature is rejected", "op", op, "id", intID, "err", err)
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireSignatureOrAPIKey lets through signed image URLs and requests with an API key;
+// a request carrying a key is authenticated by the key, other requests need the signature
+func RequireSignatureOrAPIKey(log *slog.Logger, signer *signing.Signer, keys APIKeyGetter, adminKey string) func(http.Handler) http.Handler {
+	signed, authenticated := RequireSignature(log, signer), RequireAPIKey(log, keys, adminKey)
+	return func(next http.Handler) http.Handler {
+		bySignature, byKey := signed(next), authenticated(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if requestAPIKey(r) != "" {
+				byKey.ServeHTTP(w, r)
+				return
+			}
+			bySignature.ServeHTTP(w, r)
+		})
+	}
+}
+
+// clientIP returns the address the request came from
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"imageProcessor/internal/auth"
+	"imageProcessor/internal/models"
+	storagePkg "imageProcessor/internal/storage"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type TenantStorage interface {
+	CreateTenant(name string) (*models.Tenant, error)
+	CreateAPIKey(key *models.APIKey) (int, error)
+	RevokeAPIKey(id int) error
+}
+
+const (
+	tenantIdParameter = "tenantId"
+	keyIdParameter    = "keyId"
+)
+
+type TenantRequest struct {
+	Name string `json:"name"`
+}
+
+type TenantResponse struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type APIKeyRequest struct {
+	Name  string `json:"name,omitempty"`
+	Admin bool   `json:"admin,omitempty"`
+}
+
+// APIKeyResponse holds the key itself, it is shown only once
+type APIKeyResponse struct {
+	ID       int    `json:"id"`
+	TenantID int    `json:"tenant_id"`
+	Name     string `json:"name,omitempty"`
+	Admin    bool   `json:"admin"`
+	Key      string `json:"key"`
+}
+
+// CreateTenant registers a new owner of images
+func CreateTenant(log *slog.Logger, storage TenantStorage) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.CreateTenant"
+
+		var req TenantRequest
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&req); err != nil {
+			http.Error(w, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+			return
+		}
+		req.Name = strings.TrimSpace(req.Name)
+		if req.Name == "" {
+			http.Error(w, "tenant name is required", http.StatusBadRequest)
+			return
+		}
+
+		tenant, err := storage.CreateTenant(req.Name)
+		if errors.Is(err, storagePkg.ErrTenantExists) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			log.Error("creating tenant error", "op", op, "err", err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(TenantResponse{ID: tenant.Id, Name: tenant.Name, CreatedAt: tenant.CreatedAt})
+	}
+}
+
+// CreateAPIKey issues a new key of the tenant; only the hash of the key is stored
+func CreateAPIKey(log *slog.Logger, storage TenantStorage) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.CreateAPIKey"
+
+		tenantID, err := strconv.Atoi(chi.URLParam(r, tenantIdParameter))
+		if err != nil {
+			http.Error(w, "incorrect tenant id parameter", http.StatusBadRequest)
+			return
+		}
+
+		var req APIKeyRequest
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		if err = decoder.Decode(&req); err != nil {
+			http.Error(w, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		key, err := auth.GenerateKey()
+		if err != nil {
+			log.Error("generating api key error", "op", op, "err", err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+		apiKey := models.APIKey{TenantId: tenantID, Name: req.Name, KeyHash: auth.HashKey(key), Admin: req.Admin}
+		id, err := storage.CreateAPIKey(&apiKey)
+		if errors.Is(err, storagePkg.ErrTenantNotFound) {
+			http.Error(w, "tenant not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Error("creating api key error", "op", op, "err", err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(APIKeyResponse{ID: id, TenantID: tenantID, Name: req.Name, Admin: req.Admin, Key: key})
+	}
+}
+
+// RevokeAPIKey stops accepting the key
+func RevokeAPIKey(log *slog.Logger, storage TenantStorage) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.RevokeAPIKey"
+
+		keyID, err := strconv.Atoi(chi.URLParam(r, keyIdParameter))
+		if err != nil {
+			http.Error(w, "incorrect key id parameter", http.StatusBadRequest)
+			return
+		}
+
+		err = storage.RevokeAPIKey(keyID)
+		if errors.Is(err, storagePkg.ErrAPIKeyNotFound) {
+			http.Error(w, "api key not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Error("revoking api key error", "op", op, "err", err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
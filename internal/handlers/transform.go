@@ -0,0 +1,274 @@
+package handlers
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"imageProcessor/internal/blobstore"
+	img_storage "imageProcessor/internal/img-storage"
+	"imageProcessor/internal/models"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// query parameters of a variant computed on request
+const (
+	widthQueryParameter     = "w"
+	heightQueryParameter    = "h"
+	fitQueryParameter       = "fit"
+	outputQueryParameter    = "fmt"
+	qualityQueryParameter   = "q"
+	watermarkQueryParameter = "wm"
+)
+
+// fitModes maps the fit parameter to resize modes;
+// contain and cover are the CSS names of fit and fill
+var fitModes = map[string]string{
+	"contain":          models.FitMode,
+	"cover":            models.FillMode,
+	models.FitMode:     models.FitMode,
+	models.FillMode:    models.FillMode,
+	models.CropMode:    models.CropMode,
+	models.StretchMode: models.StretchMode,
+}
+
+// VariantCache keeps computed variants; a missing variant is produced by create
+type VariantCache interface {
+	blobstore.BlobStore
+	Load(ctx context.Context, key string, create func() ([]byte, error)) (hit bool, err error)
+}
+
+// TransformLimits bound the size of a computed variant
+type TransformLimits struct {
+	MaxWidth  int
+	MaxHeight int
+}
+
+// TransformImage computes a variant of the original described by the query,
+// e.g. ?w=300&h=200&fit=cover&fmt=jpeg&q=80; the variant is cached by its normalized parameters
+// and served with an ETag. Requests without transform parameters are passed to next.
+func TransformImage(log *slog.Logger, storage ImageSqlSaver, imgStorage img_storage.ImageStorage, variants VariantCache, limits TransformLimits,
+	next func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.TransformImage"
+
+		query := r.URL.Query()
+		if !hasTransform(query) {
+			next(w, r)
+			return
+		}
+
+		intID, err := strconv.Atoi(chi.URLParam(r, idQueryParameter))
+		if err != nil {
+			log.Error("id parameter is not number type", "op", op, "err", err)
+			http.Error(w, "incorrect id parameter", http.StatusBadRequest)
+			return
+		}
+
+		metadata, ok := loadImage(w, r, log, op, storage, intID)
+		if !ok {
+			return
+		}
+
+		opts, err := parseTransform(query, metadata, limits)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// the original never changes, so its checksum and the parameters identify the variant
+		source := metadata.Checksum
+		if source == "" {
+			source = metadata.OriginalPath
+		}
+		sum := sha256.Sum256([]byte(source + "?" + opts.Key()))
+		hash := hex.EncodeToString(sum[:])
+		file := storedFile{
+			Key:      hash[:2] + "/" + hash + img_storage.FormatExtension(opts.Format),
+			MimeType: img_storage.MimeType(opts.Format),
+			Checksum: hash,
+		}
+
+		if inm := r.Header.Get("If-None-Match"); inm != "" {
+			if variantNotModified(r.Context(), variants, file.Key, inm, strconv.Quote(hash)) {
+				w.Header().Set("ETag", strconv.Quote(hash))
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			// the condition is evaluated, the variant is sent in full
+			r = r.Clone(r.Context())
+			r.Header.Del("If-None-Match")
+			r.Header.Del("If-Modified-Since")
+		}
+
+		// the variant is cached even if the client which asked for it first goes away
+		ctx := context.WithoutCancel(r.Context())
+		compute := func() ([]byte, error) {
+			return imgStorage.Transform(ctx, metadata.OriginalPath, *opts)
+		}
+		hit, err := variants.Load(ctx, file.Key, compute)
+		if err != nil {
+			log.Error("computing variant error", "op", op, "id", intID, "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		if hit {
+			w.Header().Set("X-Cache", "HIT")
+		} else {
+			w.Header().Set("X-Cache", "MISS")
+		}
+		err = serveFile(w, r, img_storage.ImageStorage{Blobs: variants}, file)
+		if errors.Is(err, blobstore.ErrNotFound) {
+			// a concurrent request has evicted the variant, it is computed again and sent from memory
+			var data []byte
+			if data, err = compute(); err == nil {
+				serveVariant(w, r, file, data)
+			}
+		}
+		if err != nil {
+			log.Error("serving variant error", "op", op, "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}
+	}
+}
+
+// serveVariant sends the variant which is not in the cache; conditions and ranges
+// are handled like for cached variants
+func serveVariant(w http.ResponseWriter, r *http.Request, file storedFile, data []byte) {
+	header := w.Header()
+	header.Del("Last-Modified")
+	header.Set("ETag", strconv.Quote(file.Checksum))
+	header.Set("Content-Type", file.MimeType)
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+}
+
+// variantNotModified evaluates If-None-Match of the variant: a revalidated variant is not
+// computed again even when it has been evicted, while "*" matches only a cached variant
+func variantNotModified(ctx context.Context, variants VariantCache, key, list, etag string) bool {
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			if _, err := variants.Stat(ctx, key); err == nil {
+				return true
+			}
+			continue
+		}
+		if strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// hasTransform reports whether the query asks for a variant instead of the original
+func hasTransform(query url.Values) bool {
+	for _, name := range []string{widthQueryParameter, heightQueryParameter, fitQueryParameter,
+		outputQueryParameter, qualityQueryParameter, watermarkQueryParameter} {
+		if query.Has(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTransform validates the query and normalizes it into transform options;
+// the size of the result must not exceed the limits
+func parseTransform(query url.Values, metadata *models.ImageMetadata, limits TransformLimits) (*img_storage.TransformOptions, error) {
+	width, err := queryDimension(query, widthQueryParameter)
+	if err != nil {
+		return nil, err
+	}
+	height, err := queryDimension(query, heightQueryParameter)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := &img_storage.TransformOptions{}
+	fit := query.Get(fitQueryParameter)
+	if width > 0 || height > 0 {
+		mode, ok := fitModes[cmp.Or(fit, models.FitMode)]
+		if !ok {
+			return nil, fmt.Errorf("unknown fit %q, expected contain, cover, crop or stretch", fit)
+		}
+		opts.Resize = &models.ResizeParameters{Width: width, Height: height, Mode: mode}
+		if err = opts.Resize.Validate(); err != nil {
+			return nil, err
+		}
+		if err = checkOutputSize(opts.Resize, metadata, limits); err != nil {
+			return nil, err
+		}
+	} else if fit != "" {
+		return nil, fmt.Errorf("fit requires w or h")
+	}
+
+	opts.Watermark = query.Get(watermarkQueryParameter)
+	if err = models.ValidateWatermarkText(opts.Watermark); err != nil {
+		return nil, err
+	}
+
+	// the format of the original is kept unless another one is asked for
+	opts.Format = img_storage.OutputFormat(img_storage.FormatByMimeType(metadata.MimeType))
+	if format := strings.ToLower(query.Get(outputQueryParameter)); format != "" {
+		if format == "jpg" {
+			format = "jpeg"
+		}
+		opts.Format = format
+	}
+	if raw := query.Get(qualityQueryParameter); raw != "" {
+		if opts.Quality, err = strconv.Atoi(raw); err != nil {
+			return nil, fmt.Errorf("q must be a number")
+		}
+	}
+	convert := models.ConvertParameters{Format: opts.Format, Quality: opts.Quality}
+	if err = convert.Validate(); err != nil {
+		return nil, err
+	}
+	return opts, nil
+}
+
+// queryDimension parses a positive width or height; an omitted one is 0
+func queryDimension(query url.Values, name string) (int, error) {
+	raw := query.Get(name)
+	if raw == "" {
+		return 0, nil
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil || value <= 0 {
+		return 0, fmt.Errorf("%s must be a positive number", name)
+	}
+	return value, nil
+}
+
+// checkOutputSize compares the size of the resized result with the limits; a dimension which is not
+// requested follows the proportions of the original, so it is derived from the stored size.
+// Transforms without resize keep the size of the original and are not limited.
+func checkOutputSize(resize *models.ResizeParameters, metadata *models.ImageMetadata, limits TransformLimits) error {
+	var width, height int
+	switch {
+	case resize.Width > 0 && resize.Height > 0:
+		width, height = resize.Width, resize.Height
+	case resize.Width > 0 && metadata.Width > 0:
+		width, height = resize.Width, metadata.Height*resize.Width/metadata.Width
+	case resize.Height > 0 && metadata.Height > 0:
+		width, height = metadata.Width*resize.Height/metadata.Height, resize.Height
+	default:
+		width, height = resize.Width, resize.Height
+	}
+
+	if limits.MaxWidth > 0 && width > limits.MaxWidth || limits.MaxHeight > 0 && height > limits.MaxHeight {
+		return fmt.Errorf("result %dx%d exceeds the maximum size %dx%d, set smaller w or h",
+			width, height, limits.MaxWidth, limits.MaxHeight)
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/png"
+	img_storage "imageProcessor/internal/img-storage"
+	"imageProcessor/internal/models"
+	storagePkg "imageProcessor/internal/storage"
+	"io"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"time"
+)
+
+type WatermarkAssetGetter interface {
+	GetWatermarkAsset(id int) (*models.WatermarkAsset, error)
+	GetFont(tenantID int, family, weight string) (*models.Font, error)
+}
+
+type WatermarkAssetStorage interface {
+	WatermarkAssetGetter
+	SetWatermarkAsset(asset *models.WatermarkAsset) (int, error)
+	ListWatermarkAssets(tenantID int, allTenants bool) ([]models.WatermarkAsset, error)
+}
+
+const (
+	watermarkForm = "watermark"
+	// maxLogoSize limits both the file size and the dimensions of a logo
+	maxLogoSize      = 2 * 1024 * 1024
+	maxLogoDimension = 4096
+)
+
+// WatermarkAssetResponse - description of an uploaded logo
+type WatermarkAssetResponse struct {
+	ID               int       `json:"id"`
+	TenantID         int       `json:"tenant_id,omitempty"`
+	OriginalFilename string    `json:"original_filename"`
+	Width            int       `json:"width"`
+	Height           int       `json:"height"`
+	FileSize         int       `json:"file_size"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+func toWatermarkAssetResponse(asset *models.WatermarkAsset) WatermarkAssetResponse {
+	return WatermarkAssetResponse{
+		ID:               asset.Id,
+		TenantID:         asset.TenantId,
+		OriginalFilename: asset.OriginalFilename,
+		Width:            asset.Width,
+		Height:           asset.Height,
+		FileSize:         asset.FileSize,
+		CreatedAt:        asset.CreatedAt,
+	}
+}
+
+// checkWatermarkAssets makes sure logos and fonts referenced by watermarks exist
+// and belong to the tenant; assets of other tenants are not found
+func checkWatermarkAssets(storage WatermarkAssetGetter, tenantID int, parameters *models.ActionParameters, pipeline []models.Operation) error {
+	all := []*models.ActionParameters{parameters}
+	for _, step := range pipeline {
+		all = append(all, step.Parameters)
+	}
+	for _, p := range all {
+		if p == nil || p.Watermark == nil {
+			continue
+		}
+		if p.Watermark.Logo == nil {
+			if err := checkFont(storage, tenantID, p.Watermark.Font, p.Watermark.FontWeight); err != nil {
+				return err
+			}
+			continue
+		}
+		asset, err := storage.GetWatermarkAsset(p.Watermark.Logo.AssetID)
+		if errors.Is(err, storagePkg.ErrAssetNotFound) || err == nil && asset.TenantId != tenantID {
+			return fmt.Errorf("watermark asset %d not found", p.Watermark.Logo.AssetID)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkFont makes sure the font is builtin or uploaded by the tenant
+func checkFont(storage WatermarkAssetGetter, tenantID int, family, weight string) error {
+	if family == "" {
+		return nil
+	}
+	if img_storage.IsBuiltinFontFamily(family) {
+		if _, err := img_storage.LoadFont(family, weight, nil); err != nil {
+			return err
+		}
+		return nil
+	}
+	if _, err := storage.GetFont(tenantID, family, weight); err != nil {
+		if errors.Is(err, storagePkg.ErrFontNotFound) {
+			return fmt.Errorf("font %s %s not found", family, weight)
+		}
+		return err
+	}
+	return nil
+}
+
+// UploadWatermarkAsset stores a PNG logo once so that watermarks can reference it by id
+func UploadWatermarkAsset(log *slog.Logger, storage WatermarkAssetStorage, imgStorage img_storage.ImageStorage) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.UploadWatermarkAsset"
+
+		r.Body = http.MaxBytesReader(w, r.Body, maxLogoSize+1024*1024)
+		if err := r.ParseMultipartForm(maxLogoSize); err != nil {
+			http.Error(w, "Gotten data too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		file, handler, err := r.FormFile(watermarkForm)
+		if err != nil {
+			log.Warn("error getting watermark file", "op", op, "err", err)
+			http.Error(w, "watermark file is required", http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+
+		if handler.Size > maxLogoSize {
+			http.Error(w, "watermark file is too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		// only PNG keeps the alpha channel needed for overlays
+		config, format, err := image.DecodeConfig(file)
+		if err != nil || format != "png" {
+			http.Error(w, "watermark must be a PNG image", http.StatusUnsupportedMediaType)
+			return
+		}
+		if config.Width > maxLogoDimension || config.Height > maxLogoDimension {
+			http.Error(w, fmt.Sprintf("watermark must not exceed %dx%d", maxLogoDimension, maxLogoDimension), http.StatusBadRequest)
+			return
+		}
+		if _, err = file.Seek(0, io.SeekStart); err != nil {
+			log.Error("rewinding watermark file failed", "op", op, "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		key := imgStorage.NewWatermarkAssetKey()
+		if err = imgStorage.Blobs.Put(r.Context(), key, file, handler.Size); err != nil {
+			log.Error("error uploading watermark file", "op", op, "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		// the logo belongs to the tenant of the API key
+		tenantID, _ := tenantScope(r)
+		asset := models.WatermarkAsset{
+			TenantId:         tenantID,
+			OriginalFilename: filepath.Base(handler.Filename),
+			Path:             key,
+			Width:            config.Width,
+			Height:           config.Height,
+			FileSize:         int(handler.Size),
+			CreatedAt:        time.Now(),
+		}
+		asset.Id, err = storage.SetWatermarkAsset(&asset)
+		if err != nil {
+			_ = imgStorage.Blobs.Delete(r.Context(), key)
+			log.Error("adding watermark metadata failed", "op", op, "err", err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(toWatermarkAssetResponse(&asset))
+	}
+}
+
+// ListWatermarkAssets returns logos uploaded by the caller; an admin key sees logos of all tenants
+func ListWatermarkAssets(log *slog.Logger, storage WatermarkAssetStorage) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.ListWatermarkAssets"
+
+		tenantID, allTenants := tenantScope(r)
+		assets, err := storage.ListWatermarkAssets(tenantID, allTenants)
+		if err != nil {
+			log.Error("listing watermark assets failed", "op", op, "err", err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+
+		resp := make([]WatermarkAssetResponse, 0, len(assets))
+		for i := range assets {
+			resp = append(resp, toWatermarkAssetResponse(&assets[i]))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(resp)
+	}
+}
@@ -0,0 +1,60 @@
+package img_storage
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+)
+
+// blobsDir is a key prefix of uploaded originals; they are named by the sha256
+// of their content and sharded by its first bytes
+const blobsDir = "blobs"
+
+// BlobKey returns the key of one stored copy of the original with the checksum,
+// e.g. blobs/ab/cd/abcd...-1f2e3d4c; every upload writes its own copy,
+// so a copy removed with the last image is never shared with a new upload
+func (ims *ImageStorage) BlobKey(checksum, copyID string) string {
+	return path.Join(blobsDir, checksum[:2], checksum[2:4], checksum+"-"+copyID)
+}
+
+// StoreBlob writes the content into the content-addressed storage as a new copy.
+// Identical content is stored once: the metadata storage keeps the copy of the first upload
+// while it is referenced, and the caller removes its copy when another one is kept.
+func (ims *ImageStorage) StoreBlob(ctx context.Context, r io.Reader) (checksum, key string, size int64, err error) {
+	const op = "img-storage.StoreBlob"
+
+	// the checksum is known only after the content is read,
+	// so the upload is spooled into a local temporary file
+	tmpFile, err := os.CreateTemp("", "upload-*.tmp")
+	if err != nil {
+		return "", "", 0, fmt.Errorf("%s,%w", op, err)
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	hash := sha256.New()
+	size, err = io.Copy(io.MultiWriter(tmpFile, hash), r)
+	if err != nil {
+		return "", "", 0, fmt.Errorf("%s,%w", op, err)
+	}
+
+	copyID := make([]byte, 4)
+	if _, err = rand.Read(copyID); err != nil {
+		return "", "", 0, fmt.Errorf("%s,%w", op, err)
+	}
+	checksum = hex.EncodeToString(hash.Sum(nil))
+	key = ims.BlobKey(checksum, hex.EncodeToString(copyID))
+
+	if _, err = tmpFile.Seek(0, io.SeekStart); err != nil {
+		return "", "", 0, fmt.Errorf("%s,%w", op, err)
+	}
+	if err = ims.Blobs.Put(ctx, key, tmpFile, size); err != nil {
+		return "", "", 0, fmt.Errorf("%s,%w", op, err)
+	}
+	return checksum, key, size, nil
+}
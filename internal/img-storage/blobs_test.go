@@ -0,0 +1,58 @@
+package img_storage
+
+import (
+	"context"
+	"imageProcessor/internal/blobstore"
+	"strings"
+	"testing"
+)
+
+// newTestStorage returns an image storage over a temporary directory
+func newTestStorage(t *testing.T) *ImageStorage {
+	t.Helper()
+	blobs, err := blobstore.NewFileSystem(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &ImageStorage{Blobs: blobs}
+}
+
+func TestStoreBlob(t *testing.T) {
+	ctx := context.Background()
+	ims := newTestStorage(t)
+
+	checksum, key, size, err := ims.StoreBlob(ctx, strings.NewReader("photo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != 5 || !strings.HasPrefix(key, "blobs/"+checksum[:2]+"/"+checksum[2:4]+"/"+checksum+"-") {
+		t.Fatalf("unexpected blob %s %s %d", checksum, key, size)
+	}
+
+	// an identical upload gets the same checksum and its own copy,
+	// the metadata storage decides which copy is kept
+	sameChecksum, sameKey, _, err := ims.StoreBlob(ctx, strings.NewReader("photo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sameChecksum != checksum || sameKey == key {
+		t.Errorf("unexpected copy %s %s of %s %s", sameChecksum, sameKey, checksum, key)
+	}
+
+	otherChecksum, _, _, err := ims.StoreBlob(ctx, strings.NewReader("another photo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if otherChecksum == checksum {
+		t.Errorf("different content shares the checksum %s", checksum)
+	}
+
+	// removing one copy keeps the other one
+	if err = ims.Blobs.Delete(ctx, sameKey); err != nil {
+		t.Fatal(err)
+	}
+	content, err := ims.GetUpdatedImage(ctx, key)
+	if err != nil || string(content) != "photo" {
+		t.Errorf("unexpected content %q, %v", content, err)
+	}
+}
@@ -0,0 +1,117 @@
+package img_storage
+
+import (
+	"fmt"
+	"image"
+	"image/png"
+	"imageProcessor/internal/models"
+	"io"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+// formatMimeTypes maps decoded and output formats to MIME types
+var formatMimeTypes = map[string]string{
+	"jpeg": "image/jpeg",
+	"png":  "image/png",
+	"gif":  "image/gif",
+	"bmp":  "image/bmp",
+	"tiff": "image/tiff",
+	"webp": "image/webp",
+}
+
+// pngCompressionLevels maps names of compression parameter to encoder levels
+var pngCompressionLevels = map[string]png.CompressionLevel{
+	"default": png.DefaultCompression,
+	"none":    png.NoCompression,
+	"speed":   png.BestSpeed,
+	"best":    png.BestCompression,
+}
+
+// EncodeOptions tune the encoder of the output format; zero values select defaults
+type EncodeOptions struct {
+	Quality     int    // JPEG quality 1-100, 95 by default
+	Compression string // PNG compression: default, none, speed, best
+	Colors      int    // GIF palette size 2-256, 256 by default
+}
+
+// EncodeOptionsFromParameters collects encoder options of the convert action
+func EncodeOptionsFromParameters(p *models.ConvertParameters) EncodeOptions {
+	if p == nil {
+		return EncodeOptions{}
+	}
+	return EncodeOptions{
+		Quality:     p.Quality,
+		Compression: p.Compression,
+		Colors:      p.Colors,
+	}
+}
+
+// MimeType returns the MIME type of the format
+func MimeType(format string) string {
+	if mimeType, ok := formatMimeTypes[format]; ok {
+		return mimeType
+	}
+	return "application/octet-stream"
+}
+
+// FormatByMimeType returns the format of the MIME type, empty when it is unknown
+func FormatByMimeType(mimeType string) string {
+	for format, formatMimeType := range formatMimeTypes {
+		if formatMimeType == mimeType {
+			return format
+		}
+	}
+	return ""
+}
+
+// MimeTypeByExtension returns the MIME type of a stored file by its extension
+func MimeTypeByExtension(ext string) string {
+	for format, formatExt := range formatExtensions {
+		if strings.EqualFold(formatExt, ext) {
+			return MimeType(format)
+		}
+	}
+	return MimeType("")
+}
+
+// OutputFormat chooses the format a result of the decoded image is saved in;
+// formats which can only be decoded, like WebP, are saved as PNG
+func OutputFormat(format string) string {
+	if models.OutputFormats[format] {
+		return format
+	}
+	return "png"
+}
+
+// EncodeImage encodes the image into w with the format and the encoder options
+func EncodeImage(w io.Writer, img image.Image, format string, opts EncodeOptions) error {
+	imagingFormat, err := imaging.FormatFromExtension(format)
+	if err != nil {
+		return fmt.Errorf("unsupported output format %q: %w", format, err)
+	}
+
+	quality := opts.Quality
+	if quality == 0 {
+		quality = 95
+	}
+	compression, ok := pngCompressionLevels[opts.Compression]
+	if !ok {
+		compression = png.DefaultCompression
+	}
+	colors := opts.Colors
+	if colors == 0 {
+		colors = 256
+	}
+
+	err = imaging.Encode(w, img, imagingFormat,
+		imaging.JPEGQuality(quality),
+		imaging.PNGCompressionLevel(compression),
+		imaging.GIFNumColors(colors),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to encode image: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package img_storage
+
+import (
+	"bytes"
+	"context"
+	"image/color"
+	"path"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+func TestEncodeImageConvertsInput(t *testing.T) {
+	var tiff bytes.Buffer
+	if err := imaging.Encode(&tiff, imaging.New(30, 20, color.NRGBA{R: 200, A: 255}), imaging.TIFF); err != nil {
+		t.Fatal(err)
+	}
+
+	img, format, err := Decode(&tiff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != "tiff" {
+		t.Fatalf("tiff is expected, got %s", format)
+	}
+
+	ctx := context.Background()
+	ims := newTestStorage(t)
+	key := ims.NewDerivativeKey(1, "convert", FormatExtension("gif"))
+	if _, err = ims.SaveImage(ctx, key, img, "gif", EncodeOptions{Colors: 16}); err != nil {
+		t.Fatal(err)
+	}
+	converted, format, err := ims.DecodeImage(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != "gif" || converted.Bounds().Dx() != 30 {
+		t.Errorf("unexpected result %s %v", format, converted.Bounds())
+	}
+	if mimeType := MimeTypeByExtension(path.Ext(key)); mimeType != "image/gif" {
+		t.Errorf("image/gif is expected, got %s", mimeType)
+	}
+}
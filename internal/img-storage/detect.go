@@ -0,0 +1,93 @@
+package img_storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+)
+
+var (
+	ErrUnsupportedFormat = errors.New("unsupported image format")
+	ErrFormatMismatch    = errors.New("image content does not match its format")
+)
+
+// ImageInfo describes an uploaded image by its content
+type ImageInfo struct {
+	Format   string
+	MimeType string
+	Width    int
+	Height   int
+}
+
+// magicNumbers are signatures at the start of files of the accepted formats
+var magicNumbers = []struct {
+	format string
+	match  func(header []byte) bool
+}{
+	{"jpeg", prefix([]byte{0xFF, 0xD8, 0xFF})},
+	{"png", prefix([]byte("\x89PNG\r\n\x1a\n"))},
+	{"gif", func(header []byte) bool {
+		return bytes.HasPrefix(header, []byte("GIF87a")) || bytes.HasPrefix(header, []byte("GIF89a"))
+	}},
+	{"bmp", prefix([]byte("BM"))},
+	{"tiff", func(header []byte) bool {
+		return bytes.HasPrefix(header, []byte("II*\x00")) || bytes.HasPrefix(header, []byte("MM\x00*"))
+	}},
+	{"webp", func(header []byte) bool {
+		return len(header) >= 12 && bytes.HasPrefix(header, []byte("RIFF")) && bytes.Equal(header[8:12], []byte("WEBP"))
+	}},
+}
+
+func prefix(signature []byte) func(header []byte) bool {
+	return func(header []byte) bool {
+		return bytes.HasPrefix(header, signature)
+	}
+}
+
+// SniffFormat detects the image format by the magic bytes of the file header
+func SniffFormat(header []byte) (string, bool) {
+	for _, magic := range magicNumbers {
+		if magic.match(header) {
+			return magic.format, true
+		}
+	}
+	return "", false
+}
+
+// DetectImage checks the content of the file instead of its name: the format is sniffed
+// from the magic bytes and has to be confirmed by decoding the image header.
+// The reader is rewound to the start afterwards.
+func DetectImage(r io.ReadSeeker) (*ImageInfo, error) {
+	header := make([]byte, 16)
+	n, err := io.ReadFull(r, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedFormat, err)
+	}
+	format, ok := SniffFormat(header[:n])
+	if !ok {
+		return nil, ErrUnsupportedFormat
+	}
+
+	if _, err = r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	config, decodedFormat, err := image.DecodeConfig(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s can not be decoded", ErrFormatMismatch, format)
+	}
+	if decodedFormat != format {
+		return nil, fmt.Errorf("%w: %s signature, %s content", ErrFormatMismatch, format, decodedFormat)
+	}
+	if _, err = r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	return &ImageInfo{
+		Format:   format,
+		MimeType: MimeType(format),
+		Width:    config.Width,
+		Height:   config.Height,
+	}, nil
+}
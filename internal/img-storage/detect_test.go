@@ -0,0 +1,43 @@
+package img_storage
+
+import (
+	"bytes"
+	"errors"
+	"image/color"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+func TestDetectImage(t *testing.T) {
+	var jpeg bytes.Buffer
+	if err := imaging.Encode(&jpeg, imaging.New(30, 20, color.NRGBA{A: 255}), imaging.JPEG); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := DetectImage(bytes.NewReader(jpeg.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.MimeType != "image/jpeg" || info.Width != 30 || info.Height != 20 {
+		t.Errorf("unexpected info %+v", info)
+	}
+
+	tests := []struct {
+		name    string
+		content []byte
+		wantErr error
+	}{
+		{"pdf", []byte("%PDF-1.7\n%\xe2\xe3\xcf\xd3"), ErrUnsupportedFormat},
+		{"empty", nil, ErrUnsupportedFormat},
+		{"png signature with jpeg content", append([]byte("\x89PNG\r\n\x1a\n"), jpeg.Bytes()...), ErrFormatMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := DetectImage(bytes.NewReader(tt.content))
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("%v is expected, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
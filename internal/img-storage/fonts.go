@@ -0,0 +1,126 @@
+package img_storage
+
+import (
+	"context"
+	"fmt"
+	"imageProcessor/internal/models"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/golang/freetype/truetype"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/gomedium"
+	"golang.org/x/image/font/gofont/gomono"
+	"golang.org/x/image/font/gofont/gomonobold"
+	"golang.org/x/image/font/gofont/goregular"
+)
+
+// fontsDir is a key prefix of uploaded fonts
+const fontsDir = "fonts"
+
+// builtinFonts are the Go fonts (BSD license) compiled into the binary,
+// so watermarks never depend on fonts installed on the host
+var builtinFonts = map[string]map[string][]byte{
+	models.DefaultFontFamily: {
+		"regular": goregular.TTF,
+		"medium":  gomedium.TTF,
+		"bold":    gobold.TTF,
+	},
+	"go-mono": {
+		"regular": gomono.TTF,
+		"bold":    gomonobold.TTF,
+	},
+}
+
+var (
+	parsedFontsMu sync.Mutex
+	parsedFonts   = map[string]*truetype.Font{}
+)
+
+// IsBuiltinFontFamily reports whether the family is compiled into the binary;
+// such families can not be replaced by uploads
+func IsBuiltinFontFamily(family string) bool {
+	_, ok := builtinFonts[family]
+	return ok
+}
+
+// BuiltinFontWeights lists weights of the builtin families
+func BuiltinFontWeights() map[string][]string {
+	weights := make(map[string][]string, len(builtinFonts))
+	for family, fonts := range builtinFonts {
+		for _, weight := range []string{"regular", "medium", "bold"} {
+			if _, ok := fonts[weight]; ok {
+				weights[family] = append(weights[family], weight)
+			}
+		}
+	}
+	return weights
+}
+
+// builtinFont returns the parsed builtin font, ok is false if there is no such font
+func builtinFont(family, weight string) (*truetype.Font, bool) {
+	data, ok := builtinFonts[family][weight]
+	if !ok {
+		return nil, false
+	}
+
+	parsedFontsMu.Lock()
+	defer parsedFontsMu.Unlock()
+
+	key := family + "/" + weight
+	if font, ok := parsedFonts[key]; ok {
+		return font, true
+	}
+	// builtin fonts are known to be valid
+	font, err := truetype.Parse(data)
+	if err != nil {
+		return nil, false
+	}
+	parsedFonts[key] = font
+	return font, true
+}
+
+// defaultFont is used when the watermark does not select a font
+func defaultFont() *truetype.Font {
+	font, _ := builtinFont(models.DefaultFontFamily, "regular")
+	return font
+}
+
+// ParseFont checks that data is a TrueType font which can be used by watermarks
+func ParseFont(data []byte) (*truetype.Font, error) {
+	font, err := truetype.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TrueType font: %w", err)
+	}
+	return font, nil
+}
+
+// LoadFontFile reads and parses an uploaded font
+func (ims *ImageStorage) LoadFontFile(ctx context.Context, key string) (*truetype.Font, error) {
+	data, err := ims.GetUpdatedImage(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read font: %w", err)
+	}
+	return ParseFont(data)
+}
+
+// LoadFont returns a builtin font or loads an uploaded one through assets
+func LoadFont(family, weight string, assets WatermarkAssets) (*truetype.Font, error) {
+	if IsBuiltinFontFamily(family) {
+		font, ok := builtinFont(family, weight)
+		if !ok {
+			return nil, fmt.Errorf("font %s %s is not available", family, weight)
+		}
+		return font, nil
+	}
+	if assets == nil {
+		return nil, fmt.Errorf("watermark assets are not available")
+	}
+	return assets.WatermarkFont(family, weight)
+}
+
+// NewFontKey returns a unique key for an uploaded font
+func (ims *ImageStorage) NewFontKey(family, weight string) string {
+	return path.Join(fontsDir, fmt.Sprintf("%s_%s_%d.ttf", family, weight, time.Now().UnixNano()))
+}
@@ -1,25 +1,88 @@
 package img_storage
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
-	"os"
+	"image"
+	"imageProcessor/internal/blobstore"
+	"io"
+	"path"
+	"time"
 )
 
+// derivativesDir is a key prefix of processed results
+const derivativesDir = "derivatives"
+
 type ImageStorage struct {
-	ImgStoragePath string
+	Blobs          blobstore.BlobStore // keeps originals, derivatives and uploaded assets
+	ThumbnailSizes []int               // square sizes produced by the miniature action
+}
+
+// StoredFile describes the content written by SaveImage
+type StoredFile struct {
+	Checksum string // hex encoded sha256
+	Size     int
 }
 
 func (ims *ImageStorage) ToUpdateImage() {
 
 }
 
-func GetUpdatedImage(filePath string) ([]byte, error) {
+// NewDerivativeKey returns a unique key with the extension ext
+// for a result of the action over the image
+func (ims *ImageStorage) NewDerivativeKey(imageID int, action, ext string) string {
+	return path.Join(derivativesDir, fmt.Sprintf("%d_%s_%d%s", imageID, action, time.Now().UnixNano(), ext))
+}
+
+// GetUpdatedImage reads the whole blob, e.g. to embed it into a JSON response
+func (ims *ImageStorage) GetUpdatedImage(ctx context.Context, key string) ([]byte, error) {
 	const op = "img-storage.GetUpdatedImage"
 
-	image, err := os.ReadFile(filePath)
+	reader, err := ims.Blobs.Get(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("%s,%w", op, err)
+	}
+	defer reader.Close()
+
+	image, err := io.ReadAll(reader)
 	if err != nil {
 		return nil, fmt.Errorf("%s,%w", op, err)
 	}
 
 	return image, nil
 }
+
+// DecodeImage reads and decodes the blob once so that
+// several operations can be applied to it in memory
+func (ims *ImageStorage) DecodeImage(ctx context.Context, key string) (image.Image, string, error) {
+	reader, err := ims.Blobs.Get(ctx, key)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to open image: %w", err)
+	}
+	defer reader.Close()
+
+	return Decode(reader)
+}
+
+// SaveImage encodes the image with the format and the encoder options and stores it under the key
+func (ims *ImageStorage) SaveImage(ctx context.Context, key string, img image.Image, format string, opts EncodeOptions) (*StoredFile, error) {
+	var buf bytes.Buffer
+	if err := EncodeImage(&buf, img, format, opts); err != nil {
+		return nil, err
+	}
+	return ims.put(ctx, key, &buf)
+}
+
+// put stores the encoded content and returns its checksum
+func (ims *ImageStorage) put(ctx context.Context, key string, buf *bytes.Buffer) (*StoredFile, error) {
+	checksum := sha256.Sum256(buf.Bytes())
+	stored := &StoredFile{Checksum: hex.EncodeToString(checksum[:]), Size: buf.Len()}
+
+	if err := ims.Blobs.Put(ctx, key, buf, int64(buf.Len())); err != nil {
+		return nil, fmt.Errorf("failed to save file: %w", err)
+	}
+	return stored, nil
+}
@@ -0,0 +1,113 @@
+package img_storage
+
+import (
+	"fmt"
+	"image"
+	"imageProcessor/internal/models"
+	"path"
+	"time"
+
+	"github.com/disintegration/imaging"
+	"github.com/golang/freetype/truetype"
+)
+
+// watermarksDir is a key prefix of uploaded logos
+const watermarksDir = "watermarks"
+
+// WatermarkAssets loads uploaded logos by their id and uploaded fonts by family and weight
+type WatermarkAssets interface {
+	WatermarkAsset(id int) (image.Image, error)
+	WatermarkFont(family, weight string) (*truetype.Font, error)
+}
+
+// LogoConfig конфигурация для наложения логотипа
+type LogoConfig struct {
+	Scale    float64        // ширина логотипа относительно ширины изображения
+	Opacity  float64        // прозрачность 0.0-1.0
+	Position imaging.Anchor // положение логотипа
+	Margin   int            // отступ от краёв изображения в пикселях
+	Tile     bool           // повторять логотип по всему изображению
+}
+
+// LogoConfigFromParameters собирает конфигурацию логотипа из проверенных параметров запроса
+func LogoConfigFromParameters(p *models.LogoParameters) LogoConfig {
+	config := LogoConfig{
+		Scale:    p.Scale,
+		Opacity:  1,
+		Position: imaging.BottomRight,
+		Margin:   10,
+		Tile:     p.Tile,
+	}
+	if p.Opacity != nil {
+		config.Opacity = *p.Opacity
+	}
+	if anchor, ok := anchors[p.Position]; ok {
+		config.Position = anchor
+	}
+	if p.Margin != nil {
+		config.Margin = *p.Margin
+	}
+	return config
+}
+
+// NewWatermarkAssetKey returns a unique key for an uploaded logo
+func (ims *ImageStorage) NewWatermarkAssetKey() string {
+	return path.Join(watermarksDir, fmt.Sprintf("logo_%d.png", time.Now().UnixNano()))
+}
+
+// ApplyLogoWatermark накладывает логотип с учётом его альфа-канала
+// и возвращает новое изображение, исходное не изменяется.
+func ApplyLogoWatermark(img image.Image, logo image.Image, config LogoConfig) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	// Масштабируем логотип относительно ширины изображения
+	logoWidth := int(float64(width) * config.Scale)
+	if logoWidth < 1 {
+		logoWidth = 1
+	}
+	scaled := imaging.Resize(logo, logoWidth, 0, imaging.Lanczos)
+	logoHeight := scaled.Bounds().Dy()
+
+	result := imaging.Clone(img)
+
+	if config.Tile {
+		// Повторяем логотип сеткой с шагом размер логотипа + отступ
+		stepX, stepY := logoWidth+config.Margin, logoHeight+config.Margin
+		for y := config.Margin; y < height; y += stepY {
+			for x := config.Margin; x < width; x += stepX {
+				result = imaging.Overlay(result, scaled, image.Pt(x, y), config.Opacity)
+			}
+		}
+		return result
+	}
+
+	return imaging.Overlay(result, scaled, logoPosition(config.Position, width, height, logoWidth, logoHeight, config.Margin), config.Opacity)
+}
+
+// logoPosition вычисляет левый верхний угол логотипа для положения anchor
+func logoPosition(anchor imaging.Anchor, width, height, logoWidth, logoHeight, margin int) image.Point {
+	left, centerX, right := margin, (width-logoWidth)/2, width-logoWidth-margin
+	top, centerY, bottom := margin, (height-logoHeight)/2, height-logoHeight-margin
+
+	switch anchor {
+	case imaging.TopLeft:
+		return image.Pt(left, top)
+	case imaging.Top:
+		return image.Pt(centerX, top)
+	case imaging.TopRight:
+		return image.Pt(right, top)
+	case imaging.Left:
+		return image.Pt(left, centerY)
+	case imaging.Right:
+		return image.Pt(right, centerY)
+	case imaging.BottomLeft:
+		return image.Pt(left, bottom)
+	case imaging.Bottom:
+		return image.Pt(centerX, bottom)
+	case imaging.BottomRight:
+		return image.Pt(right, bottom)
+	default:
+		return image.Pt(centerX, centerY)
+	}
+}
@@ -0,0 +1,89 @@
+package img_storage
+
+import (
+	"fmt"
+	"image"
+	"imageProcessor/internal/models"
+	"io"
+
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+// formatExtensions maps output formats to file extensions
+var formatExtensions = map[string]string{
+	"jpeg": ".jpg",
+	"png":  ".png",
+	"gif":  ".gif",
+	"bmp":  ".bmp",
+	"tiff": ".tiff",
+	"webp": ".webp",
+}
+
+// FormatExtension returns a file extension for the output format
+func FormatExtension(format string) string {
+	format = OutputFormat(format)
+	if ext, ok := formatExtensions[format]; ok {
+		return ext
+	}
+	return ".png"
+}
+
+// Decode decodes the image from the reader; besides JPEG, PNG and GIF
+// it decodes BMP, TIFF and WebP
+func Decode(r io.Reader) (image.Image, string, error) {
+	img, format, err := image.Decode(r)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decode image: %w", err)
+	}
+	return img, format, nil
+}
+
+// ApplyOperation executes one validated pipeline operation over a decoded image;
+// assets are used to load logos and uploaded fonts of watermarks.
+// Convert does not change pixels, it only selects the output format
+// which is taken into account when the result is saved.
+func ApplyOperation(img image.Image, operation models.Operation, assets WatermarkAssets) (image.Image, error) {
+	parameters := operation.Parameters
+	if parameters == nil {
+		parameters = &models.ActionParameters{}
+	}
+
+	switch operation.Action {
+	case models.ResizeAction:
+		if parameters.Resize == nil {
+			return nil, fmt.Errorf("resize parameters are missing")
+		}
+		return TransformImage(img, ResizeOptionsFromParameters(parameters.Resize)), nil
+	case models.MiniatureAction:
+		if parameters.Miniature == nil || len(parameters.Miniature.Sizes) != 1 {
+			return nil, fmt.Errorf("miniature step requires exactly one size")
+		}
+		return Thumbnail(img, parameters.Miniature.Sizes[0]), nil
+	case models.WatermarkAction:
+		if parameters.Watermark != nil && parameters.Watermark.Logo != nil {
+			if assets == nil {
+				return nil, fmt.Errorf("watermark assets are not available")
+			}
+			logo, err := assets.WatermarkAsset(parameters.Watermark.Logo.AssetID)
+			if err != nil {
+				return nil, err
+			}
+			return ApplyLogoWatermark(img, logo, LogoConfigFromParameters(parameters.Watermark.Logo)), nil
+		}
+		config := WatermarkConfigFromParameters(parameters.Watermark)
+		if !IsBuiltinFontFamily(config.FontFamily) {
+			font, err := LoadFont(config.FontFamily, config.FontWeight, assets)
+			if err != nil {
+				return nil, err
+			}
+			config.Font = font
+		}
+		return WatermarkImage(img, config), nil
+	case models.ConvertAction:
+		return img, nil
+	default:
+		return nil, fmt.Errorf("unknown operation %q", operation.Action)
+	}
+}
@@ -0,0 +1,53 @@
+package img_storage
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"imageProcessor/internal/models"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+func TestApplyOperationPipeline(t *testing.T) {
+	steps := []models.Operation{
+		{Action: models.ResizeAction, Parameters: &models.ActionParameters{
+			Resize: &models.ResizeParameters{Width: 40, Height: 40, Mode: models.FillMode},
+		}},
+		{Action: models.WatermarkAction},
+		{Action: models.ConvertAction, Parameters: &models.ActionParameters{
+			Convert: &models.ConvertParameters{Format: "jpeg"},
+		}},
+	}
+	if err := models.ValidatePipeline(steps); err != nil {
+		t.Fatal(err)
+	}
+
+	var img image.Image = imaging.New(200, 100, color.NRGBA{R: 10, G: 20, B: 30, A: 255})
+	for _, step := range steps {
+		var err error
+		img, err = ApplyOperation(img, step, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", step.Action, err)
+		}
+	}
+
+	if img.Bounds().Dx() != 40 || img.Bounds().Dy() != 40 {
+		t.Fatalf("unexpected size %v", img.Bounds())
+	}
+
+	ctx := context.Background()
+	ims := newTestStorage(t)
+	key := ims.NewDerivativeKey(1, models.PipelineAction, FormatExtension("jpeg"))
+	if _, err := ims.SaveImage(ctx, key, img, "jpeg", EncodeOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	_, format, err := ims.DecodeImage(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != "jpeg" {
+		t.Errorf("jpeg is expected, got %s", format)
+	}
+}
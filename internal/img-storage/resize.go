@@ -1,13 +1,15 @@
 package img_storage
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"image"
 	_ "image/gif"
 	_ "image/jpeg"
 	_ "image/png"
-	"os"
-	"path/filepath"
+	"imageProcessor/internal/models"
+	"path"
 	"strings"
 
 	"github.com/disintegration/imaging"
@@ -18,40 +20,125 @@ import (
 // TODO: implement miniature generator
 // TODO: implement watermark creating function
 
-// ResizeImage is common function for resizing fetched images
-func ResizeImage(imagePath string, width, height int) error {
+// ResizeImage is common function for resizing fetched images;
+// the result is stored under outputKey, the source image stays untouched
+func (ims *ImageStorage) ResizeImage(ctx context.Context, imageKey, outputKey string, width, height int) error {
 	if width <= 0 && height <= 0 {
 		return fmt.Errorf("хотя бы один из параметров (width или height) должен быть больше 0")
 	}
 
 	// Оба параметра заданы - вписываем в размеры
 	if width > 0 && height > 0 {
-		return ResizeToFit(imagePath, width, height)
+		return ims.ResizeToFit(ctx, imageKey, outputKey, width, height)
 	}
 
 	// Задана только ширина
 	if width > 0 {
-		return ResizeByWidth(imagePath, width)
+		return ims.ResizeByWidth(ctx, imageKey, outputKey, width)
 	}
 
 	// Задана только высота
-	return ResizeByHeight(imagePath, height)
+	return ims.ResizeByHeight(ctx, imageKey, outputKey, height)
 }
 
-// Resize resizes the image at the given path to the specified width and height.
-// Overwrites the original file with the resized image.
-func Resize(imagePath string, width, height int) error {
-	// Open the image file
-	file, err := os.Open(imagePath)
+// ResizeOptions describes how an image is fitted into Width x Height
+type ResizeOptions struct {
+	Width  int
+	Height int
+	Mode   string // models.FitMode, models.FillMode, models.CropMode, models.StretchMode
+	Filter imaging.ResampleFilter
+	Anchor imaging.Anchor
+}
+
+var resampleFilters = map[string]imaging.ResampleFilter{
+	"nearest":    imaging.NearestNeighbor,
+	"box":        imaging.Box,
+	"linear":     imaging.Linear,
+	"hermite":    imaging.Hermite,
+	"mitchell":   imaging.MitchellNetravali,
+	"catmullrom": imaging.CatmullRom,
+	"bspline":    imaging.BSpline,
+	"gaussian":   imaging.Gaussian,
+	"bartlett":   imaging.Bartlett,
+	"lanczos":    imaging.Lanczos,
+	"hann":       imaging.Hann,
+	"hamming":    imaging.Hamming,
+	"blackman":   imaging.Blackman,
+	"welch":      imaging.Welch,
+	"cosine":     imaging.Cosine,
+}
+
+var anchors = map[string]imaging.Anchor{
+	"center":       imaging.Center,
+	"top-left":     imaging.TopLeft,
+	"top":          imaging.Top,
+	"top-right":    imaging.TopRight,
+	"left":         imaging.Left,
+	"right":        imaging.Right,
+	"bottom-left":  imaging.BottomLeft,
+	"bottom":       imaging.Bottom,
+	"bottom-right": imaging.BottomRight,
+}
+
+// ResizeOptionsFromParameters converts request parameters into resize options;
+// unknown filter and anchor names fall back to Lanczos and Center
+func ResizeOptionsFromParameters(p *models.ResizeParameters) ResizeOptions {
+	opts := ResizeOptions{
+		Width:  p.Width,
+		Height: p.Height,
+		Mode:   p.Mode,
+		Filter: imaging.Lanczos,
+		Anchor: imaging.Center,
+	}
+	if filter, ok := resampleFilters[p.Filter]; ok {
+		opts.Filter = filter
+	}
+	if anchor, ok := anchors[p.Anchor]; ok {
+		opts.Anchor = anchor
+	}
+	return opts
+}
+
+// ResizeWithOptions resizes the image according to the options
+// and stores the result under outputKey.
+func (ims *ImageStorage) ResizeWithOptions(ctx context.Context, imageKey, outputKey string, opts ResizeOptions) error {
+	if opts.Width <= 0 && opts.Height <= 0 {
+		return fmt.Errorf("хотя бы один из параметров (width или height) должен быть больше 0")
+	}
+
+	img, format, err := ims.DecodeImage(ctx, imageKey)
 	if err != nil {
-		return fmt.Errorf("failed to open image: %w", err)
+		return err
+	}
+
+	return ims.saveImage(ctx, outputKey, TransformImage(img, opts), format)
+}
+
+// TransformImage applies resize options to a decoded image.
+func TransformImage(img image.Image, opts ResizeOptions) *image.NRGBA {
+	switch opts.Mode {
+	case models.FillMode:
+		return imaging.Fill(img, opts.Width, opts.Height, opts.Anchor, opts.Filter)
+	case models.CropMode:
+		return imaging.CropAnchor(img, opts.Width, opts.Height, opts.Anchor)
+	case models.StretchMode:
+		return imaging.Resize(img, opts.Width, opts.Height, opts.Filter)
+	default:
+		// Both parameters are set - fit into them, otherwise keep aspect ratio
+		if opts.Width > 0 && opts.Height > 0 {
+			return imaging.Fit(img, opts.Width, opts.Height, opts.Filter)
+		}
+		return imaging.Resize(img, opts.Width, opts.Height, opts.Filter)
 	}
-	defer file.Close()
+}
 
-	// Decode the image
-	img, format, err := image.Decode(file)
+// Resize resizes the image under the given key to the specified width and height.
+// Stores the resized image under outputKey.
+func (ims *ImageStorage) Resize(ctx context.Context, imageKey, outputKey string, width, height int) error {
+	// Open and decode the image
+	img, format, err := ims.DecodeImage(ctx, imageKey)
 	if err != nil {
-		return fmt.Errorf("failed to decode image: %w", err)
+		return err
 	}
 
 	// Resize the image using Lanczos resampling
@@ -60,7 +147,7 @@ func Resize(imagePath string, width, height int) error {
 	// Determine output format
 	outputFormat := format
 	if outputFormat == "" {
-		ext := strings.ToLower(filepath.Ext(imagePath))
+		ext := strings.ToLower(path.Ext(imageKey))
 		switch ext {
 		case ".jpg", ".jpeg":
 			outputFormat = "jpeg"
@@ -73,126 +160,91 @@ func Resize(imagePath string, width, height int) error {
 		}
 	}
 
-	// Create a temporary file to save the resized image
-	tmpPath := imagePath + ".tmp"
-	tmpFile, err := os.Create(tmpPath)
-	if err != nil {
-		return fmt.Errorf("failed to create temp file: %w", err)
-	}
-
-	// Encode and save to temp file
+	// Encode the resized image
+	var buf bytes.Buffer
 	var encodeErr error
 	switch outputFormat {
 	case "jpeg":
-		encodeErr = imaging.Encode(tmpFile, resized, imaging.JPEG, imaging.JPEGQuality(85))
+		encodeErr = imaging.Encode(&buf, resized, imaging.JPEG, imaging.JPEGQuality(85))
 	case "png":
-		encodeErr = imaging.Encode(tmpFile, resized, imaging.PNG, imaging.PNGCompressionLevel(9))
+		encodeErr = imaging.Encode(&buf, resized, imaging.PNG, imaging.PNGCompressionLevel(9))
 	case "gif":
-		encodeErr = imaging.Encode(tmpFile, resized, imaging.GIF)
+		encodeErr = imaging.Encode(&buf, resized, imaging.GIF)
 	default:
-		encodeErr = imaging.Encode(tmpFile, resized, imaging.PNG, imaging.JPEGQuality(85))
+		encodeErr = imaging.Encode(&buf, resized, imaging.PNG, imaging.JPEGQuality(85))
 	}
-	tmpFile.Close()
 
 	if encodeErr != nil {
-		os.Remove(tmpPath)
 		return fmt.Errorf("failed to encode image: %w", encodeErr)
 	}
 
-	// Replace original file with resized one
-	if err := os.Rename(tmpPath, imagePath); err != nil {
-		os.Remove(tmpPath)
-		return fmt.Errorf("failed to replace original file: %w", err)
+	// Store resized image under its key
+	if _, err := ims.put(ctx, outputKey, &buf); err != nil {
+		return fmt.Errorf("failed to save resized file: %w", err)
 	}
 
 	return nil
 }
 
 // ResizeToFit resizes the image to fit within the given dimensions while preserving aspect ratio.
-func ResizeToFit(imagePath string, maxWidth, maxHeight int) error {
-	file, err := os.Open(imagePath)
-	if err != nil {
-		return fmt.Errorf("failed to open image: %w", err)
-	}
-	defer file.Close()
-
-	img, format, err := image.Decode(file)
+func (ims *ImageStorage) ResizeToFit(ctx context.Context, imageKey, outputKey string, maxWidth, maxHeight int) error {
+	img, format, err := ims.DecodeImage(ctx, imageKey)
 	if err != nil {
-		return fmt.Errorf("failed to decode image: %w", err)
+		return err
 	}
 
 	// Resize to fit while preserving aspect ratio
 	resized := imaging.Fit(img, maxWidth, maxHeight, imaging.Lanczos)
 
 	// Save the resized image
-	return saveImage(imagePath, resized, format)
+	return ims.saveImage(ctx, outputKey, resized, format)
 }
 
 // ResizeByWidth resizes the image to the specified width, preserving aspect ratio.
-func ResizeByWidth(imagePath string, width int) error {
-	file, err := os.Open(imagePath)
+func (ims *ImageStorage) ResizeByWidth(ctx context.Context, imageKey, outputKey string, width int) error {
+	img, format, err := ims.DecodeImage(ctx, imageKey)
 	if err != nil {
-		return fmt.Errorf("failed to open image: %w", err)
-	}
-	defer file.Close()
-
-	img, format, err := image.Decode(file)
-	if err != nil {
-		return fmt.Errorf("failed to decode image: %w", err)
+		return err
 	}
 
 	resized := imaging.Resize(img, width, 0, imaging.Lanczos)
 
-	return saveImage(imagePath, resized, format)
+	return ims.saveImage(ctx, outputKey, resized, format)
 }
 
 // ResizeByHeight resizes the image to the specified height, preserving aspect ratio.
-func ResizeByHeight(imagePath string, height int) error {
-	file, err := os.Open(imagePath)
+func (ims *ImageStorage) ResizeByHeight(ctx context.Context, imageKey, outputKey string, height int) error {
+	img, format, err := ims.DecodeImage(ctx, imageKey)
 	if err != nil {
-		return fmt.Errorf("failed to open image: %w", err)
-	}
-	defer file.Close()
-
-	img, format, err := image.Decode(file)
-	if err != nil {
-		return fmt.Errorf("failed to decode image: %w", err)
+		return err
 	}
 
 	resized := imaging.Resize(img, 0, height, imaging.Lanczos)
 
-	return saveImage(imagePath, resized, format)
+	return ims.saveImage(ctx, outputKey, resized, format)
 }
 
-// saveImage saves the image to the specified path with the given format.
-func saveImage(imagePath string, img *image.NRGBA, format string) error {
-	tmpPath := imagePath + ".tmp"
-	tmpFile, err := os.Create(tmpPath)
-	if err != nil {
-		return fmt.Errorf("failed to create temp file: %w", err)
-	}
-
+// saveImage stores the image under the key with the given format.
+func (ims *ImageStorage) saveImage(ctx context.Context, outputKey string, img *image.NRGBA, format string) error {
+	var buf bytes.Buffer
 	var encodeErr error
 	switch format {
 	case "jpeg":
-		encodeErr = imaging.Encode(tmpFile, img, imaging.JPEG, imaging.JPEGQuality(85))
+		encodeErr = imaging.Encode(&buf, img, imaging.JPEG, imaging.JPEGQuality(85))
 	case "png":
-		encodeErr = imaging.Encode(tmpFile, img, imaging.PNG, imaging.PNGCompressionLevel(9))
+		encodeErr = imaging.Encode(&buf, img, imaging.PNG, imaging.PNGCompressionLevel(9))
 	case "gif":
-		encodeErr = imaging.Encode(tmpFile, img, imaging.GIF)
+		encodeErr = imaging.Encode(&buf, img, imaging.GIF)
 	default:
-		encodeErr = imaging.Encode(tmpFile, img, imaging.JPEG, imaging.JPEGQuality(85))
+		encodeErr = imaging.Encode(&buf, img, imaging.JPEG, imaging.JPEGQuality(85))
 	}
-	tmpFile.Close()
 
 	if encodeErr != nil {
-		os.Remove(tmpPath)
 		return fmt.Errorf("failed to encode image: %w", encodeErr)
 	}
 
-	if err := os.Rename(tmpPath, imagePath); err != nil {
-		os.Remove(tmpPath)
-		return fmt.Errorf("failed to replace original file: %w", err)
+	if _, err := ims.put(ctx, outputKey, &buf); err != nil {
+		return fmt.Errorf("failed to save resized file: %w", err)
 	}
 
 	return nil
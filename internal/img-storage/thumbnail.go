@@ -0,0 +1,89 @@
+package img_storage
+
+import (
+	"image"
+
+	"github.com/disintegration/imaging"
+)
+
+// smartAnchorSample limits the size of the copy used to choose an anchor
+const smartAnchorSample = 128
+
+// IsThumbnailSize reports whether the size is among the configured presets
+func (ims *ImageStorage) IsThumbnailSize(size int) bool {
+	for _, preset := range ims.ThumbnailSizes {
+		if preset == size {
+			return true
+		}
+	}
+	return false
+}
+
+// Thumbnail creates a square miniature of the image;
+// the cropped area is chosen by SmartAnchor
+func Thumbnail(img image.Image, size int) *image.NRGBA {
+	return imaging.Fill(img, size, size, SmartAnchor(img), imaging.Lanczos)
+}
+
+// SmartAnchor chooses the part of the image which keeps the most details
+// when it is cropped to a square. The amount of details is estimated
+// by the gradient energy of a small grayscale copy; the center wins ties.
+func SmartAnchor(img image.Image) imaging.Anchor {
+	bounds := img.Bounds()
+	if bounds.Dx() == bounds.Dy() {
+		return imaging.Center
+	}
+	landscape := bounds.Dx() > bounds.Dy()
+
+	sample := imaging.Grayscale(imaging.Fit(img, smartAnchorSample, smartAnchorSample, imaging.Box))
+	width, height := sample.Bounds().Dx(), sample.Bounds().Dy()
+
+	// energy along the long side of the image
+	length, side := height, width
+	if landscape {
+		length, side = width, height
+	}
+	energy := make([]int, length)
+	for y := 0; y < height-1; y++ {
+		for x := 0; x < width-1; x++ {
+			i := y*sample.Stride + x*4
+			value := int(sample.Pix[i])
+			gradient := abs(value-int(sample.Pix[i+4])) + abs(value-int(sample.Pix[i+sample.Stride]))
+			if landscape {
+				energy[x] += gradient
+			} else {
+				energy[y] += gradient
+			}
+		}
+	}
+
+	windowEnergy := func(start int) int {
+		sum := 0
+		for i := start; i < start+side && i < length; i++ {
+			sum += energy[i]
+		}
+		return sum
+	}
+
+	best, bestEnergy := imaging.Center, windowEnergy((length-side)/2)
+	if e := windowEnergy(0); e > bestEnergy {
+		best, bestEnergy = imaging.Top, e
+		if landscape {
+			best = imaging.Left
+		}
+	}
+	if e := windowEnergy(length - side); e > bestEnergy {
+		best = imaging.Bottom
+		if landscape {
+			best = imaging.Right
+		}
+	}
+	return best
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
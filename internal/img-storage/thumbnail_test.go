@@ -0,0 +1,34 @@
+package img_storage
+
+import (
+	"image/color"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+func TestThumbnailSmartAnchor(t *testing.T) {
+	// flat landscape image with a checkerboard on the right side
+	img := imaging.New(300, 100, color.NRGBA{R: 200, G: 200, B: 200, A: 255})
+	for y := 0; y < 100; y++ {
+		for x := 200; x < 300; x++ {
+			if (x/5+y/5)%2 == 0 {
+				img.Set(x, y, color.NRGBA{A: 255})
+			}
+		}
+	}
+
+	if anchor := SmartAnchor(img); anchor != imaging.Right {
+		t.Errorf("right anchor is expected, got %v", anchor)
+	}
+
+	thumbnail := Thumbnail(img, 64)
+	if thumbnail.Bounds().Dx() != 64 || thumbnail.Bounds().Dy() != 64 {
+		t.Errorf("unexpected thumbnail size %v", thumbnail.Bounds())
+	}
+
+	flat := imaging.New(100, 300, color.NRGBA{A: 255})
+	if anchor := SmartAnchor(flat); anchor != imaging.Center {
+		t.Errorf("center anchor is expected for a flat image, got %v", anchor)
+	}
+}
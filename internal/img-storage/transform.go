@@ -0,0 +1,63 @@
+package img_storage
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"imageProcessor/internal/models"
+	"net/url"
+	"strconv"
+)
+
+// TransformOptions describe a variant of the original computed on request
+type TransformOptions struct {
+	Resize    *models.ResizeParameters // validated parameters, nil keeps the size of the original
+	Watermark string                   // text of the watermark, empty - no watermark
+	Format    string                   // output format
+	Quality   int                      // jpeg quality, 0 - default
+}
+
+// Key returns the normalized form of the options: equal variants have equal keys
+// whatever order and spelling of the request parameters were
+func (o TransformOptions) Key() string {
+	values := url.Values{}
+	if o.Resize != nil {
+		values.Set("w", strconv.Itoa(o.Resize.Width))
+		values.Set("h", strconv.Itoa(o.Resize.Height))
+		values.Set("fit", o.Resize.Mode)
+		values.Set("filter", o.Resize.Filter)
+		values.Set("anchor", o.Resize.Anchor)
+	}
+	if o.Watermark != "" {
+		values.Set("wm", o.Watermark)
+	}
+	values.Set("fmt", o.Format)
+	if o.Quality != 0 {
+		values.Set("q", strconv.Itoa(o.Quality))
+	}
+	// Encode sorts parameters by name
+	return values.Encode()
+}
+
+// Transform decodes the original stored under the key, resizes and watermarks it
+// and returns the variant encoded with the output format
+func (ims *ImageStorage) Transform(ctx context.Context, key string, opts TransformOptions) ([]byte, error) {
+	img, _, err := ims.DecodeImage(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	var result image.Image = img
+	if opts.Resize != nil {
+		result = TransformImage(result, ResizeOptionsFromParameters(opts.Resize))
+	}
+	if opts.Watermark != "" {
+		result = WatermarkImage(result, WatermarkConfigFromParameters(&models.WatermarkParameters{Text: opts.Watermark}))
+	}
+
+	var buf bytes.Buffer
+	if err = EncodeImage(&buf, result, opts.Format, EncodeOptions{Quality: opts.Quality}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
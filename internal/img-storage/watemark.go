@@ -1,18 +1,22 @@
 package img_storage
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"image"
 	"image/color"
 	_ "image/gif"
 	_ "image/jpeg"
 	_ "image/png"
-	"os"
-	"path/filepath"
+	"imageProcessor/internal/models"
+	"math"
+	"path"
 	"strings"
 
 	"github.com/disintegration/imaging"
 	"github.com/fogleman/gg"
+	"github.com/golang/freetype/truetype"
 )
 
 // WatermarkConfig конфигурация для нанесения водяного знака
@@ -26,6 +30,20 @@ type WatermarkConfig struct {
 	PositionY string      // "top", "center", "bottom"
 	OffsetX   int         // дополнительное смещение по X
 	OffsetY   int         // дополнительное смещение по Y
+
+	FontFamily  string         // семейство шрифта, встроенное или загруженное
+	FontWeight  string         // "regular", "medium", "bold"
+	Font        *truetype.Font // загруженный шрифт (nil = встроенный FontFamily/FontWeight)
+	StrokeWidth float64        // толщина обводки в пикселях (0 = без обводки)
+	StrokeColor color.Color    // цвет обводки
+	ShadowX     int            // смещение тени по X
+	ShadowY     int            // смещение тени по Y
+	ShadowColor color.Color    // цвет тени (nil = без тени)
+
+	Tile     bool // повторять текст сеткой по всему изображению, позиция и смещение не используются
+	SpacingX int  // расстояние между повторами в строке (0 = высота текста)
+	SpacingY int  // расстояние между строками (0 = высота текста)
+	Stagger  bool // сдвигать каждую вторую строку на половину шага
 }
 
 // DefaultWatermarkConfig возвращает конфигурацию по умолчанию
@@ -40,28 +58,98 @@ func DefaultWatermarkConfig() *WatermarkConfig {
 		PositionY: "center",
 		OffsetX:   0,
 		OffsetY:   0,
+
+		FontFamily:  models.DefaultFontFamily,
+		FontWeight:  "regular",
+		StrokeColor: color.RGBA{0, 0, 0, 255},
 	}
 }
 
-// ApplyWatermark наносит водяной знак на изображение согласно конфигурации.
+// WatermarkConfigFromParameters собирает конфигурацию из параметров запроса;
+// незаданные параметры берутся из конфигурации по умолчанию
+func WatermarkConfigFromParameters(p *models.WatermarkParameters) *WatermarkConfig {
+	config := DefaultWatermarkConfig()
+	if p == nil {
+		return config
+	}
+
+	if p.Text != "" {
+		config.Text = p.Text
+	}
+	config.FontSize = p.FontSize
+	if p.Opacity != nil {
+		config.Opacity = *p.Opacity
+	}
+	if p.Rotation != nil {
+		config.Rotation = *p.Rotation
+	}
+	if p.PositionX != "" {
+		config.PositionX = p.PositionX
+	}
+	if p.PositionY != "" {
+		config.PositionY = p.PositionY
+	}
+	config.OffsetX = p.OffsetX
+	config.OffsetY = p.OffsetY
+	if p.Font != "" {
+		config.FontFamily = p.Font
+	}
+	if p.FontWeight != "" {
+		config.FontWeight = p.FontWeight
+	}
+	if p.Stroke != nil {
+		config.StrokeWidth = p.Stroke.Width
+		if strokeColor, err := models.ParseHexColor(p.Stroke.Color); err == nil {
+			config.StrokeColor = strokeColor
+		}
+	}
+	if p.Shadow != nil {
+		config.ShadowX = p.Shadow.OffsetX
+		config.ShadowY = p.Shadow.OffsetY
+		config.ShadowColor = color.RGBA{0, 0, 0, 128}
+		if shadowColor, err := models.ParseHexColor(p.Shadow.Color); err == nil {
+			config.ShadowColor = shadowColor
+		}
+	}
+	if p.Tile != nil {
+		config.Tile = true
+		config.SpacingX = p.Tile.SpacingX
+		config.SpacingY = p.Tile.SpacingY
+		config.Stagger = p.Tile.Stagger
+	}
+	if p.Color != "" {
+		// цвет уже проверен при валидации запроса
+		if textColor, err := models.ParseHexColor(p.Color); err == nil {
+			config.Color = textColor
+		}
+	}
+	return config
+}
+
+// ApplyWatermark наносит водяной знак на изображение согласно конфигурации
+// и сохраняет результат под ключом outputKey, исходный файл не изменяется.
 // Если config = nil, используются настройки по умолчанию.
-func ApplyWatermark(imagePath string, config *WatermarkConfig) error {
+func (ims *ImageStorage) ApplyWatermark(ctx context.Context, imageKey, outputKey string, config *WatermarkConfig) error {
 	// Используем дефолтную конфигурацию если не передана
 	if config == nil {
 		config = DefaultWatermarkConfig()
 	}
 
-	// Открываем изображение
-	file, err := os.Open(imagePath)
+	// Открываем и декодируем изображение
+	img, format, err := ims.DecodeImage(ctx, imageKey)
 	if err != nil {
-		return fmt.Errorf("не удалось открыть изображение: %w", err)
+		return fmt.Errorf("не удалось декодировать изображение: %w", err)
 	}
-	defer file.Close()
 
-	// Декодируем изображение
-	img, format, err := image.Decode(file)
-	if err != nil {
-		return fmt.Errorf("не удалось декодировать изображение: %w", err)
+	// Сохраняем изображение
+	return ims.saveWatermarkedImage(ctx, outputKey, WatermarkImage(img, config), format)
+}
+
+// WatermarkImage наносит водяной знак на уже декодированное изображение
+// и возвращает новое изображение, исходное не изменяется.
+func WatermarkImage(img image.Image, config *WatermarkConfig) image.Image {
+	if config == nil {
+		config = DefaultWatermarkConfig()
 	}
 
 	// Получаем размеры изображения
@@ -69,9 +157,9 @@ func ApplyWatermark(imagePath string, config *WatermarkConfig) error {
 	width := bounds.Dx()
 	height := bounds.Dy()
 
-	// Создаём контекст для рисования
+	// Рисуем текст на прозрачном слое, прозрачность применяется при наложении,
+	// поэтому тень и обводка под текстом не просвечивают сквозь него
 	dc := gg.NewContext(width, height)
-	dc.DrawImage(img, 0, 0)
 
 	// Устанавливаем размер шрифта
 	fontSize := config.FontSize
@@ -79,70 +167,123 @@ func ApplyWatermark(imagePath string, config *WatermarkConfig) error {
 		fontSize = float64(width) / 15 // автоматический размер
 	}
 
-	// Загружаем шрифт (используем дефолтный если Arial недоступен)
-	//if err := dc.LoadFontFace("/System/Library/Fonts/Helvetica.ttc", fontSize); err != nil {
-	//	// Fallback на встроенный шрифт
-	//	basicFont := gg.NewFontFace(int(fontSize))
-	//	dc.SetFontFace(basicFont)
-	//}
-
-	// Устанавливаем цвет с учётом прозрачности
-	r, g, b, a := config.Color.RGBA()
-	textColor := color.RGBA{
-		R: uint8(r >> 8),
-		G: uint8(g >> 8),
-		B: uint8(b >> 8),
-		A: uint8(float64(a>>8) * config.Opacity),
+	// Шрифт встроен в бинарник, поэтому размер всегда соответствует изображению
+	font := config.Font
+	if font == nil {
+		var ok bool
+		if font, ok = builtinFont(config.FontFamily, config.FontWeight); !ok {
+			font = defaultFont()
+		}
 	}
-	dc.SetColor(textColor)
+	dc.SetFontFace(truetype.NewFace(font, &truetype.Options{Size: fontSize}))
 
 	// Вычисляем позицию текста
 	textWidth, textHeight := dc.MeasureString(config.Text)
 
-	var x, y float64
+	if config.Tile {
+		drawTiledText(dc, config, textWidth, textHeight)
+	} else {
+		var x, y float64
 
-	// Позиция по X
-	switch config.PositionX {
-	case "left":
-		x = textWidth / 2
-	case "right":
-		x = float64(width) - textWidth/2
-	default: // "center"
-		x = float64(width) / 2
-	}
+		// Позиция по X
+		switch config.PositionX {
+		case "left":
+			x = textWidth / 2
+		case "right":
+			x = float64(width) - textWidth/2
+		default: // "center"
+			x = float64(width) / 2
+		}
+
+		// Позиция по Y
+		switch config.PositionY {
+		case "top":
+			y = textHeight
+		case "bottom":
+			y = float64(height) - textHeight
+		default: // "center"
+			y = float64(height) / 2
+		}
+
+		// Применяем смещение
+		x += float64(config.OffsetX)
+		y += float64(config.OffsetY)
 
-	// Позиция по Y
-	switch config.PositionY {
-	case "top":
-		y = textHeight
-	case "bottom":
-		y = float64(height) - textHeight
-	default: // "center"
-		y = float64(height) / 2
+		// Поворачиваем и рисуем текст
+		if config.Rotation != 0 {
+			dc.RotateAbout(gg.Radians(config.Rotation), x, y)
+		}
+		drawText(dc, config, x, y)
 	}
 
-	// Применяем смещение
-	x += float64(config.OffsetX)
-	y += float64(config.OffsetY)
+	// Накладываем слой с текстом с учётом прозрачности
+	return imaging.Overlay(img, dc.Image(), bounds.Min, config.Opacity)
+}
 
-	// Поворачиваем и рисуем текст
-	if config.Rotation != 0 {
-		dc.RotateAbout(gg.Radians(config.Rotation), x, y)
+// drawText рисует тень, обводку и сам текст с центром в точке (x, y)
+func drawText(dc *gg.Context, config *WatermarkConfig, x, y float64) {
+	if config.ShadowColor != nil {
+		dc.SetColor(config.ShadowColor)
+		dc.DrawStringAnchored(config.Text, x+float64(config.ShadowX), y+float64(config.ShadowY), 0.5, 0.5)
 	}
+
+	// Обводка: копии текста по окружностям радиусом до StrokeWidth
+	if config.StrokeWidth > 0 {
+		dc.SetColor(config.StrokeColor)
+		for radius := 1.0; radius <= config.StrokeWidth; radius++ {
+			steps := int(math.Ceil(2 * math.Pi * radius))
+			for i := 0; i < steps; i++ {
+				angle := 2 * math.Pi * float64(i) / float64(steps)
+				dc.DrawStringAnchored(config.Text, x+radius*math.Cos(angle), y+radius*math.Sin(angle), 0.5, 0.5)
+			}
+		}
+	}
+
+	dc.SetColor(config.Color)
 	dc.DrawStringAnchored(config.Text, x, y, 0.5, 0.5)
+}
 
-	// Получаем итоговое изображение
-	watermarked := dc.Image()
+// drawTiledText повторяет текст сеткой, повёрнутой на config.Rotation вокруг центра.
+// Сетка покрывает диагональ изображения, поэтому после поворота углы не остаются пустыми.
+func drawTiledText(dc *gg.Context, config *WatermarkConfig, textWidth, textHeight float64) {
+	width, height := float64(dc.Width()), float64(dc.Height())
+	centerX, centerY := width/2, height/2
 
-	// Сохраняем изображение
-	return saveWatermarkedImage(imagePath, watermarked, format)
+	spacingX, spacingY := float64(config.SpacingX), float64(config.SpacingY)
+	if config.SpacingX == 0 {
+		spacingX = textHeight
+	}
+	if config.SpacingY == 0 {
+		spacingY = textHeight
+	}
+	// шаг не меньше пикселя, иначе пустой текст зациклит отрисовку
+	stepX := math.Max(textWidth+spacingX, 1)
+	stepY := math.Max(textHeight+spacingY, 1)
+
+	if config.Rotation != 0 {
+		dc.RotateAbout(gg.Radians(config.Rotation), centerX, centerY)
+	}
+
+	half := math.Hypot(width, height) / 2
+	rows := int(math.Ceil(half/stepY)) + 1
+	columns := int(math.Ceil(half/stepX)) + 1
+	for row := -rows; row <= rows; row++ {
+		shift := 0.0
+		if config.Stagger && row%2 != 0 {
+			shift = stepX / 2
+		}
+		y := centerY + float64(row)*stepY
+		for column := -columns; column <= columns; column++ {
+			drawText(dc, config, centerX+float64(column)*stepX+shift, y)
+		}
+	}
 }
 
 // saveWatermarkedImage сохраняет изображение с водяным знаком
-func saveWatermarkedImage(imagePath string, img image.Image, format string) error {
+func (ims *ImageStorage) saveWatermarkedImage(ctx context.Context, outputKey string, img image.Image, format string) error {
 	// Определяем формат если не указан
 	if format == "" {
-		ext := strings.ToLower(filepath.Ext(imagePath))
+		ext := strings.ToLower(path.Ext(outputKey))
 		switch ext {
 		case ".jpg", ".jpeg":
 			format = "jpeg"
@@ -155,36 +296,27 @@ func saveWatermarkedImage(imagePath string, img image.Image, format string) erro
 		}
 	}
 
-	// Создаём временный файл
-	tmpPath := imagePath + ".tmp"
-	tmpFile, err := os.Create(tmpPath)
-	if err != nil {
-		return fmt.Errorf("не удалось создать временный файл: %w", err)
-	}
-
 	// Кодируем изображение
+	var buf bytes.Buffer
 	var encodeErr error
 	switch format {
 	case "jpeg":
-		encodeErr = imaging.Encode(tmpFile, img, imaging.JPEG, imaging.JPEGQuality(95))
+		encodeErr = imaging.Encode(&buf, img, imaging.JPEG, imaging.JPEGQuality(95))
 	case "png":
-		encodeErr = imaging.Encode(tmpFile, img, imaging.PNG)
+		encodeErr = imaging.Encode(&buf, img, imaging.PNG)
 	case "gif":
-		encodeErr = imaging.Encode(tmpFile, img, imaging.GIF)
+		encodeErr = imaging.Encode(&buf, img, imaging.GIF)
 	default:
-		encodeErr = imaging.Encode(tmpFile, img, imaging.PNG)
+		encodeErr = imaging.Encode(&buf, img, imaging.PNG)
 	}
-	tmpFile.Close()
 
 	if encodeErr != nil {
-		os.Remove(tmpPath)
 		return fmt.Errorf("не удалось закодировать изображение: %w", encodeErr)
 	}
 
-	// Заменяем оригинальный файл
-	if err := os.Rename(tmpPath, imagePath); err != nil {
-		os.Remove(tmpPath)
-		return fmt.Errorf("не удалось заменить оригинальный файл: %w", err)
+	// Сохраняем результат под ключом назначения
+	if _, err := ims.put(ctx, outputKey, &buf); err != nil {
+		return fmt.Errorf("не удалось сохранить файл: %w", err)
 	}
 
 	return nil
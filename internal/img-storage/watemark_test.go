@@ -0,0 +1,73 @@
+package img_storage
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+func TestWatermarkImageTile(t *testing.T) {
+	img := imaging.New(200, 200, color.NRGBA{A: 255})
+	config := DefaultWatermarkConfig()
+	config.Text = "stock"
+	config.Opacity = 1
+	config.Tile = true
+	config.Stagger = true
+
+	result := WatermarkImage(img, config)
+
+	// every quadrant has to be covered, so cropping can not remove the watermark
+	for _, quadrant := range []image.Rectangle{
+		image.Rect(0, 0, 100, 100),
+		image.Rect(100, 0, 200, 100),
+		image.Rect(0, 100, 100, 200),
+		image.Rect(100, 100, 200, 200),
+	} {
+		if !hasBrightPixel(result, quadrant) {
+			t.Errorf("no watermark in %v", quadrant)
+		}
+	}
+}
+
+func hasBrightPixel(img image.Image, rect image.Rectangle) bool {
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			if r, _, _, _ := img.At(x, y).RGBA(); r > 0x8000 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func TestWatermarkImageFontSize(t *testing.T) {
+	img := imaging.New(400, 200, color.NRGBA{A: 255})
+	bounds := image.Rect(0, 0, 400, 200)
+
+	brightPixels := func(fontSize float64) int {
+		config := DefaultWatermarkConfig()
+		config.Opacity = 1
+		config.Rotation = 0
+		config.FontSize = fontSize
+		config.FontWeight = "bold"
+		result := WatermarkImage(img, config)
+
+		count := 0
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				if r, _, _, _ := result.At(x, y).RGBA(); r > 0x8000 {
+					count++
+				}
+			}
+		}
+		return count
+	}
+
+	// the embedded font has to scale with the requested size
+	small, large := brightPixels(12), brightPixels(48)
+	if small == 0 || large < small*4 {
+		t.Errorf("text does not scale: %d pixels at 12pt, %d pixels at 48pt", small, large)
+	}
+}
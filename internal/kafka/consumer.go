@@ -1,75 +1,143 @@
 package kafka
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	consumer2 "imageProcessor/internal/kafka/consumer"
-	"imageProcessor/internal/storage/sqlite"
+	"imageProcessor/internal/queue"
 	"log/slog"
-	"sync"
-	"time"
 
 	"github.com/IBM/sarama"
 )
 
-func Consumer(log *slog.Logger, brokers []string, topic string, doneChannel <-chan struct{}, storage *sqlite.StorageSqlite) error {
-	const op = "kafka.NewConsumer"
+// Queue is the Kafka backend of the queue: messages are produced by the producer
+// and consumed by the consumer group, several workers with the same group share the partitions
+type Queue struct {
+	*KafkaProducer
+	*DeadLetterReplayer
+	brokers []string
+	group   string
+	log     *slog.Logger
+}
+
+func NewQueue(brokers []string, topic, group string, log *slog.Logger) (*Queue, error) {
+	const op = "kafka.NewQueue"
 	// validate fetched brokers
 	if len(brokers) == 0 {
-		return fmt.Errorf("%s,%s", op, "brokers list is empty")
+		return nil, fmt.Errorf("%s,%s", op, "brokers list is empty")
+	}
+
+	producer, err := NewProducer(brokers, topic, log)
+	if err != nil {
+		return nil, fmt.Errorf("%s,%w", op, err)
 	}
+	return &Queue{
+		KafkaProducer:      producer,
+		DeadLetterReplayer: NewDeadLetterReplayer(brokers, topic, producer),
+		brokers:            brokers,
+		group:              group,
+		log:                log,
+	}, nil
+}
 
-	// init consumer
+// Consume joins the consumer group and delivers messages of the topics.
+// Offsets are committed only after a message is acknowledged,
+// so a message is delivered at least once.
+func (q *Queue) Consume(ctx context.Context, topics []string, handle queue.HandleFunc) error {
+	const op = "kafka.Queue.Consume"
+
+	// init consumer group
 	config := sarama.NewConfig()
 	config.Version = sarama.V3_9_1_0
 	config.Consumer.Return.Errors = true
+	config.Consumer.Offsets.Initial = sarama.OffsetOldest // a new group does not miss produced messages
+	config.Consumer.Offsets.AutoCommit.Enable = false
+	config.Consumer.Group.Rebalance.GroupStrategies = []sarama.BalanceStrategy{sarama.NewBalanceStrategyRoundRobin()}
 
-	consumer, err := sarama.NewConsumer(brokers, config)
+	consumerGroup, err := sarama.NewConsumerGroup(q.brokers, q.group, config)
 	if err != nil {
-		return fmt.Errorf("NewConsumer error; %s, %w", op, err)
+		return fmt.Errorf("NewConsumerGroup error; %s, %w", op, err)
 	}
-	defer consumer.Close()
+	defer consumerGroup.Close()
 
-	// get topics
-	partitions, err := consumer.Partitions(topic)
-	if err != nil {
-		return fmt.Errorf("get broker partitions error; %s, %w", op, err)
+	go func() {
+		for err := range consumerGroup.Errors() {
+			q.log.Error("consumer group error", "op", op, "err", err)
+		}
+	}()
+
+	handler := &groupHandler{log: q.log, handle: handle}
+	for {
+		// Consume returns on every rebalance and is called again to rejoin the group
+		err := consumerGroup.Consume(ctx, topics, handler)
+		if errors.Is(err, sarama.ErrClosedConsumerGroup) {
+			break
+		}
+		if err != nil {
+			q.log.Error("consuming error", "op", op, "err", err)
+		}
+		if ctx.Err() != nil {
+			break
+		}
 	}
+	return nil
+}
 
-	var wg sync.WaitGroup
+// groupHandler delivers messages of claimed partitions of one consumer group session
+type groupHandler struct {
+	log    *slog.Logger
+	handle queue.HandleFunc
+}
+
+func (h *groupHandler) Setup(session sarama.ConsumerGroupSession) error {
+	h.log.Info("partitions are assigned", "member", session.MemberID(), "generation", session.GenerationID(), "claims", session.Claims())
+	return nil
+}
 
-	for _, partition := range partitions {
-		wg.Add(1)
-		go func(p int32) {
-			defer wg.Done()
+func (h *groupHandler) Cleanup(session sarama.ConsumerGroupSession) error {
+	// offsets of processed messages are already committed, the commit flushes anything left
+	session.Commit()
+	h.log.Info("partitions are revoked", "member", session.MemberID(), "generation", session.GenerationID())
+	return nil
+}
 
-			partitionConsumer, err := consumer.ConsumePartition(topic, p, sarama.OffsetNewest) // to consumer all tasks w/o misses
-			if err != nil {
-				log.Error("create partition consumer error", "op", op, "err", err)
+// ConsumeClaim hands messages to the handler; the offset is not committed
+// until the message is acknowledged, on a rebalance the next owner
+// of the partition receives released messages again
+func (h *groupHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	ctx := session.Context()
+	tracker := newOffsetTracker(session)
+	// the claim is released only after its taken messages are finished
+	defer tracker.wait()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg, ok := <-claim.Messages():
+			if !ok {
+				return nil
 			}
-			defer partitionConsumer.Close()
-
-			for {
-				select {
-				case _, ok := <-doneChannel:
-					if !ok {
-						log.Info(fmt.Sprintf("Partition %d is closed down", p), "op", op)
-						return
-					}
-				case msg := <-partitionConsumer.Messages():
-					log.Info("Get message", "partition", p)
-					time.Sleep(15 * time.Second)
-					err := consumer2.ConsumedHandler(msg.Value, storage, log)
-					if err != nil {
-						log.Error("Consumer handler failed;", "err", err)
-					}
-				case err := <-partitionConsumer.Errors():
-					log.Error("getting message from partition error", "op", op, "err", err)
-				}
+			h.log.Debug("message is received", "topic", msg.Topic, "partition", msg.Partition, "offset", msg.Offset)
+
+			tracked := tracker.add(msg)
+			delivery := queue.NewDelivery(msg.Topic, msg.Key, msg.Value, headers(msg),
+				func() { tracker.complete(tracked) },
+				func() { tracker.abandon(tracked) })
+			if !h.handle(ctx, delivery) {
+				delivery.Release()
+				return nil
 			}
-		}(partition)
+		}
 	}
+}
 
-	wg.Wait()
-	log.Info("All consumers stopped gracefully")
-	return nil
+func headers(msg *sarama.ConsumerMessage) map[string]string {
+	headers := make(map[string]string, len(msg.Headers))
+	for _, h := range msg.Headers {
+		if h != nil {
+			headers[string(h.Key)] = string(h.Value)
+		}
+	}
+	return headers
 }
@@ -0,0 +1,41 @@
+package consumer
+
+import (
+	"context"
+	"fmt"
+	"image"
+	img_storage "imageProcessor/internal/img-storage"
+	storagePkg "imageProcessor/internal/storage"
+	"imageProcessor/internal/storage/sqlite"
+
+	"github.com/golang/freetype/truetype"
+)
+
+// watermarkAssets loads uploaded logos and fonts through their metadata;
+// only assets of the owner of the processed image are used
+type watermarkAssets struct {
+	ctx        context.Context
+	tenantID   int
+	storage    *sqlite.StorageSqlite
+	imgStorage img_storage.ImageStorage
+}
+
+func (a watermarkAssets) WatermarkAsset(id int) (image.Image, error) {
+	asset, err := a.storage.GetWatermarkAsset(id)
+	if err != nil {
+		return nil, err
+	}
+	if asset.TenantId != a.tenantID {
+		return nil, fmt.Errorf("watermark asset %d, %w", id, storagePkg.ErrAssetNotFound)
+	}
+	logo, _, err := a.imgStorage.DecodeImage(a.ctx, asset.Path)
+	return logo, err
+}
+
+func (a watermarkAssets) WatermarkFont(family, weight string) (*truetype.Font, error) {
+	font, err := a.storage.GetFont(a.tenantID, family, weight)
+	if err != nil {
+		return nil, err
+	}
+	return a.imgStorage.LoadFontFile(a.ctx, font.Path)
+}
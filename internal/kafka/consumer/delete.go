@@ -0,0 +1,68 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	img_storage "imageProcessor/internal/img-storage"
+	"imageProcessor/internal/models"
+	storagePkg "imageProcessor/internal/storage"
+	"imageProcessor/internal/storage/sqlite"
+	"log/slog"
+)
+
+// deleteImage removes derivatives, the row and the original of the image marked as deleted;
+// every step can be repeated, so a failed command is retried from the beginning
+func deleteImage(ctx context.Context, kafkaMessage models.KafkaMessage, storage *sqlite.StorageSqlite, imgStorage img_storage.ImageStorage, log *slog.Logger) error {
+	const op = "kafka.consumer.deleteImage"
+
+	metadata, err := storage.GetImageMetadata(kafkaMessage.Id)
+	if errors.Is(err, storagePkg.ErrImageNotFound) {
+		// a redelivered command finds the image already removed
+		log.Debug("image is already removed", slog.Int("Id", kafkaMessage.Id))
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%s,%w", op, err)
+	}
+	// jobs of the image do not change the deleted status, so another status means
+	// the id belongs to an image which was not requested to be deleted
+	if metadata.Status != deletedStatus {
+		return permanent(fmt.Errorf("%s, image is not marked as deleted", op))
+	}
+
+	derivatives, err := storage.GetDerivatives(kafkaMessage.Id)
+	if err != nil {
+		return fmt.Errorf("%s,%w", op, err)
+	}
+	for _, derivative := range derivatives {
+		if err = imgStorage.Blobs.Delete(ctx, derivative.Path); err != nil {
+			return fmt.Errorf("removing derivative file failed; %s,%w", op, err)
+		}
+	}
+	if err = storage.DeleteDerivatives(kafkaMessage.Id); err != nil {
+		return fmt.Errorf("%s,%w", op, err)
+	}
+
+	// deleting the image metadata together with its reference to the original;
+	// the original itself is deleted only when the database released the last reference
+	orphanPath, err := storage.DeleteImage(kafkaMessage.Id)
+	if errors.Is(err, storagePkg.ErrImageNotFound) {
+		// a duplicate of the command removed the image meanwhile
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%s,%w", op, err)
+	}
+	if orphanPath != "" {
+		if err = imgStorage.Blobs.Delete(ctx, orphanPath); err != nil {
+			return fmt.Errorf("removing original file failed; %s,%w", op, err)
+		}
+	}
+
+	log.Debug("image is removed",
+		slog.Int("Id", kafkaMessage.Id),
+		slog.Int("derivatives", len(derivatives)),
+	)
+	return nil
+}
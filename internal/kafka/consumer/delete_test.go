@@ -0,0 +1,128 @@
+package consumer
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"image"
+	"image/png"
+	"imageProcessor/internal/blobstore"
+	img_storage "imageProcessor/internal/img-storage"
+	"imageProcessor/internal/models"
+	storagePkg "imageProcessor/internal/storage"
+	"imageProcessor/internal/storage/sqlite"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestStorage creates the database from migrations/schema.sql and a file storage in temporary directories
+func newTestStorage(t *testing.T) (*sqlite.StorageSqlite, img_storage.ImageStorage) {
+	t.Helper()
+	schema, err := os.ReadFile("../../../migrations/schema.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbPath := filepath.Join(t.TempDir(), "storage.db")
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(string(schema))
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storage, err := sqlite.New(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blobs, err := blobstore.NewFileSystem(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return storage, img_storage.ImageStorage{Blobs: blobs}
+}
+
+func TestDeleteWhileJobIsProcessed(t *testing.T) {
+	ctx := context.Background()
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	storage, imgStorage := newTestStorage(t)
+
+	var original bytes.Buffer
+	if err := png.Encode(&original, image.NewRGBA(image.Rect(0, 0, 256, 256))); err != nil {
+		t.Fatal(err)
+	}
+	parameters := &models.ActionParameters{Resize: &models.ResizeParameters{Width: 128, Height: 128}}
+
+	for i := 0; i < 20; i++ {
+		checksum, key, size, err := imgStorage.StoreBlob(ctx, bytes.NewReader(original.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		metadata := models.ImageMetadata{
+			OriginalPath: key, Checksum: checksum, MimeType: "image/png", Width: 256, Height: 256,
+			FileSize: int(size), Status: pendingStatus, Action: resizeAction, Parameters: parameters,
+		}
+		job := models.KafkaMessage{Action: resizeAction, Parameters: parameters}
+		id, err := storage.SetUpload(&metadata, nil, &job)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if metadata.OriginalPath != key {
+			t.Fatalf("image %d shares a removed copy %s", id, metadata.OriginalPath)
+		}
+		jobMessage, _ := json.Marshal(job)
+
+		// the job and the delete request with its command run at the same time
+		var wg sync.WaitGroup
+		var jobErr, deleteErr error
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			jobErr = ConsumedHandler(context.Background(), jobMessage, false, storage, imgStorage, nil, log)
+		}()
+		go func() {
+			defer wg.Done()
+			// the delete is requested at different moments of the job
+			time.Sleep(time.Duration(i%10) * time.Millisecond)
+			deleteJobID, err := storage.RequestDelete(id)
+			if err != nil {
+				deleteErr = err
+				return
+			}
+			deleteMessage, _ := json.Marshal(models.KafkaMessage{Id: id, Action: models.DeleteAction, JobId: deleteJobID})
+			deleteErr = ConsumedHandler(context.Background(), deleteMessage, false, storage, imgStorage, nil, log)
+		}()
+		wg.Wait()
+
+		if jobErr != nil {
+			t.Errorf("image %d: the job must finish or give up on the deleted image: %v", id, jobErr)
+		}
+		if deleteErr != nil {
+			t.Fatalf("image %d: the delete command failed: %v", id, deleteErr)
+		}
+		if _, err = storage.GetImageMetadata(id); !errors.Is(err, storagePkg.ErrImageNotFound) {
+			t.Fatalf("image %d must be removed, got %v", id, err)
+		}
+		if derivatives, err := storage.GetDerivatives(id); err != nil || len(derivatives) != 0 {
+			t.Fatalf("image %d: derivatives must be removed, got %v %v", id, derivatives, err)
+		}
+	}
+
+	// neither the originals nor results of the jobs are left behind
+	files, err := imgStorage.Blobs.List(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("files are left after the images are deleted: %v", files)
+	}
+}
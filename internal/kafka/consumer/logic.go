@@ -1,13 +1,17 @@
 package consumer
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	img_storage "imageProcessor/internal/img-storage"
 	"imageProcessor/internal/models"
+	"imageProcessor/internal/queue"
+	storagePkg "imageProcessor/internal/storage"
 	"imageProcessor/internal/storage/sqlite"
 	"log/slog"
-	"os"
+	"path"
 )
 
 // offered actions with images
@@ -15,42 +19,157 @@ var actions = map[string]bool{
 	resizeAction:    true,
 	miniatureAction: true,
 	watermarkAction: true,
+	convertAction:   true,
+	pipelineAction:  true,
 }
 
-var tmpResizeParameters = [2]int{20, 25}
-
 const (
-	resizeAction    = "resize"
-	miniatureAction = "miniature"
-	watermarkAction = "watermark"
+	resizeAction    = models.ResizeAction
+	miniatureAction = models.MiniatureAction
+	watermarkAction = models.WatermarkAction
+	convertAction   = models.ConvertAction
+	pipelineAction  = models.PipelineAction
+	presetAction    = models.PresetAction
 )
 
 // Statuses
 const (
+	pendingStatus    = "pending"
 	processingStatus = "processing"
 	modifiedStatus   = "modified"
 	failedStatus     = "failed"
+	completedStatus  = "completed" // status of a finished pipeline and its steps
+	deletedStatus    = "deleted"   // the image waits for the delete command
 )
 
-// ConsumedHandler is designed to handle fetched messages
-func ConsumedHandler(msg []byte, storage *sqlite.StorageSqlite, log *slog.Logger) error {
+// errImageDeleted reports that the image was deleted before its job was processed;
+// the storage reports it when a status or a result of the job is written after the delete request
+var errImageDeleted = storagePkg.ErrImageDeleted
+
+// permanentError marks failures which another attempt can not fix,
+// e.g. a malformed message or invalid parameters
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent lets the queue route the message straight to the dead-letter topic
+func (e *permanentError) Permanent() bool { return true }
+
+func permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+// IsPermanent reports whether retrying the message is useless
+func IsPermanent(err error) bool {
+	var target *permanentError
+	return errors.As(err, &target)
+}
+
+// ConsumedHandler is designed to handle fetched messages;
+// every action stores its result as a new derivative of the original.
+// The job of the message is moved to processing and then to modified;
+// a failure moves it back to pending while another attempt is expected
+// and to failed after the last attempt or a permanent failure.
+// The limiter bounds operations of every action, including steps of pipelines.
+// A job interrupted by ctx is not failed, it is processed again.
+func ConsumedHandler(ctx context.Context, msg []byte, lastAttempt bool, storage *sqlite.StorageSqlite, imgStorage img_storage.ImageStorage, limiter *queue.ActionLimiter, log *slog.Logger) error {
 	const op = "kafka.consumer.ConsumerHandler"
 	if len(msg) == 0 {
-		return fmt.Errorf("message is empty")
+		return permanent(fmt.Errorf("message is empty"))
 	}
 
 	var kafkaMessage models.KafkaMessage
 	err := json.Unmarshal(msg, &kafkaMessage)
 	if err != nil {
-		return fmt.Errorf("unmarshaled message error; %s, %w", op, err)
+		return permanent(fmt.Errorf("unmarshaled message error; %s, %w", op, err))
 	}
 
-	if _, ok := actions[kafkaMessage.Action]; !ok {
-		return fmt.Errorf("incorrect recived action; %s", op)
+	if kafkaMessage.JobId != 0 {
+		if err = storage.StartJob(kafkaMessage.JobId); err != nil {
+			return fmt.Errorf("%s,%w", op, err)
+		}
+	}
+
+	// the status of an image being deleted is not changed by its delete command
+	deleting := kafkaMessage.Action == models.DeleteAction
+	doneStatus := modifiedStatus
+	if deleting {
+		err = deleteImage(ctx, kafkaMessage, storage, imgStorage, log)
+		doneStatus = deletedStatus
+	} else {
+		err = handleMessage(ctx, kafkaMessage, storage, imgStorage, limiter, log)
+		// the delete command may remove files of the image while the job is running
+		if err != nil && !errors.Is(err, errImageDeleted) && imageDeleted(storage, kafkaMessage.Id) {
+			err = fmt.Errorf("%w; %w", errImageDeleted, err)
+		}
+	}
+	switch {
+	case errors.Is(err, errImageDeleted):
+		finishJob(storage, kafkaMessage.JobId, failedStatus, err, log)
+		return nil
+	case err != nil && (lastAttempt || IsPermanent(err)) && ctx.Err() == nil:
+		finishJob(storage, kafkaMessage.JobId, failedStatus, err, log)
+		if !deleting {
+			if statusErr := storage.UpdateStatus(kafkaMessage.Id, failedStatus); statusErr != nil && !errors.Is(statusErr, errImageDeleted) {
+				log.Error("setting failed status error", "op", op, "err", statusErr)
+			}
+		}
+		return err
+	case err != nil:
+		if kafkaMessage.JobId != 0 {
+			if jobErr := storage.RetryJob(kafkaMessage.JobId, err.Error()); jobErr != nil {
+				log.Error("setting job retry error", "op", op, "err", jobErr)
+			}
+		}
+		if !deleting {
+			if statusErr := storage.UpdateStatus(kafkaMessage.Id, pendingStatus); statusErr != nil && !errors.Is(statusErr, errImageDeleted) {
+				log.Error("setting pending status error", "op", op, "err", statusErr)
+			}
+		}
+		return err
+	}
+	finishJob(storage, kafkaMessage.JobId, doneStatus, nil, log)
+	return nil
+}
+
+// imageDeleted reports whether the image is marked as deleted or already removed
+func imageDeleted(storage *sqlite.StorageSqlite, id int) bool {
+	metadata, err := storage.GetImageMetadata(id)
+	return errors.Is(err, storagePkg.ErrImageNotFound) || err == nil && metadata.Status == deletedStatus
+}
+
+// finishJob records the final state of the job, messages without a job are skipped
+func finishJob(storage *sqlite.StorageSqlite, jobID int, status string, jobErr error, log *slog.Logger) {
+	const op = "kafka.consumer.finishJob"
+	if jobID == 0 {
+		return
+	}
+	var reason string
+	if jobErr != nil {
+		reason = jobErr.Error()
+	}
+	if err := storage.FinishJob(jobID, status, reason); err != nil {
+		log.Error("finishing job error", "op", op, "job_id", jobID, "err", err)
+	}
+}
+
+// handleMessage applies the requested action to the original image
+func handleMessage(ctx context.Context, kafkaMessage models.KafkaMessage, storage *sqlite.StorageSqlite, imgStorage img_storage.ImageStorage,
+	limiter *queue.ActionLimiter, log *slog.Logger) error {
+	const op = "kafka.consumer.handleMessage"
+
+	if _, ok := actions[kafkaMessage.Action]; !ok && kafkaMessage.Action != presetAction {
+		return permanent(fmt.Errorf("incorrect recived action; %s", op))
 	}
 	log.Debug("request action is checked", "action", kafkaMessage.Action)
 
 	metadata, err := storage.GetImageMetadata(kafkaMessage.Id)
+	if errors.Is(err, storagePkg.ErrImageNotFound) {
+		return fmt.Errorf("%s,%w", op, errImageDeleted)
+	}
 	if err != nil {
 		return fmt.Errorf("%s,%w", op, err)
 	}
@@ -58,50 +177,92 @@ func ConsumedHandler(msg []byte, storage *sqlite.StorageSqlite, log *slog.Logger
 	log.Debug("consumer receive metadata by id", slog.Int("id", kafkaMessage.Id))
 	log.Debug("image is turn processing")
 
-	if metadata.Status == "deleted" {
-		// deleting the image itself
-		err = os.Remove(metadata.OriginalPath)
-		if err != nil {
-			log.Error("Removing original file failed", "op", op, "err", err)
-			//w.WriteHeader(http.StatusInternalServerError)
-			//w.Write([]byte("file delete failed"))
-			return fmt.Errorf("%s,%w", op, err)
+	// the delete command removes the image with its files, other actions are skipped
+	if metadata.Status == deletedStatus {
+		return fmt.Errorf("%s,%w", op, errImageDeleted)
+	}
+
+	// preset is resolved at processing time to apply its latest version
+	if kafkaMessage.Action == presetAction {
+		if err = resolvePreset(&kafkaMessage, storage, log); err != nil {
+			return fmt.Errorf("%s, %w", op, err)
 		}
-		// deleting the image metadata
-		err = storage.DeleteImage(kafkaMessage.Id)
-		if err != nil {
-			log.Error("metadata deleting error", "op", op, "err", err)
-			//w.WriteHeader(http.StatusInternalServerError)
-			//w.Write([]byte("db delete failed"))
-			return fmt.Errorf("%s,%w", op, err)
+		if _, ok := actions[kafkaMessage.Action]; !ok {
+			return permanent(fmt.Errorf("incorrect preset action; %s", op))
 		}
-		return nil
 	}
 
-	// TODO: add logic to change status
-	// TODO: add worker pool
+	if err = storage.UpdateStatus(kafkaMessage.Id, processingStatus); err != nil {
+		return fmt.Errorf("%s, %w", op, err)
+	}
+
+	// every step of a pipeline takes the limit of its own action
+	if kafkaMessage.Action == pipelineAction {
+		return handlePipeline(ctx, kafkaMessage, metadata, storage, imgStorage, limiter, log)
+	}
+
+	// parameters are sent with the message; the stored copy is used for older messages
+	parameters := kafkaMessage.Parameters
+	if parameters == nil {
+		parameters = metadata.Parameters
+	}
+	if parameters == nil {
+		parameters = &models.ActionParameters{}
+	}
+	if err := parameters.Validate(kafkaMessage.Action); err != nil {
+		return permanent(fmt.Errorf("invalid action parameters; %s, %w", op, err))
+	}
 
+	release, err := limiter.Acquire(ctx, kafkaMessage.Action)
+	if err != nil {
+		return fmt.Errorf("%s, %w", op, err)
+	}
+	defer release()
+
+	if kafkaMessage.Action == miniatureAction {
+		return handleMiniature(ctx, kafkaMessage, metadata, parameters.Miniature, storage, imgStorage, log)
+	}
+
+	img, format, err := imgStorage.DecodeImage(ctx, metadata.OriginalPath)
+	if err != nil {
+		return fmt.Errorf("%s, %w", op, err)
+	}
+	operation := models.Operation{Action: kafkaMessage.Action, Parameters: parameters}
+	result, err := img_storage.ApplyOperation(img, operation, watermarkAssets{ctx: ctx, tenantID: metadata.TenantId, storage: storage, imgStorage: imgStorage})
+	if err != nil {
+		return fmt.Errorf("%s, %w", op, err)
+	}
+
+	// the result keeps the format of the original unless it is converted
+	outputFormat := img_storage.OutputFormat(format)
+	var encodeOptions img_storage.EncodeOptions
+	var appliedParameters any
 	switch kafkaMessage.Action {
 	case resizeAction:
-		err := img_storage.ResizeImage(metadata.OriginalPath, tmpResizeParameters[0], tmpResizeParameters[1])
-		if err != nil {
-			return fmt.Errorf("%s, %w", op, err)
-		}
-	case miniatureAction:
-		// TODO: add miniature function itself
-		// WARN: temporarily ise ResizeImage because this function has the same approach
-		err := img_storage.ResizeImage(metadata.OriginalPath, tmpResizeParameters[0], tmpResizeParameters[1])
-		if err != nil {
-			return fmt.Errorf("%s, %w", op, err)
-		}
+		appliedParameters = parameters.Resize
 	case watermarkAction:
-		err := img_storage.ApplyWatermark(metadata.OriginalPath, img_storage.DefaultWatermarkConfig())
-		if err != nil {
-			return fmt.Errorf("%s, %w", op, err)
-		}
+		appliedParameters = parameters.Watermark
+	case convertAction:
+		outputFormat = parameters.Convert.Format
+		encodeOptions = img_storage.EncodeOptionsFromParameters(parameters.Convert)
+		appliedParameters = parameters.Convert
 	default:
-		return fmt.Errorf("incorrect action; %s, %w", op, err)
+		return fmt.Errorf("incorrect action; %s", op)
+	}
+
+	// the original is never overwritten, every result goes to a separate file
+	outputKey := imgStorage.NewDerivativeKey(kafkaMessage.Id, kafkaMessage.Action, img_storage.FormatExtension(outputFormat))
+	stored, err := imgStorage.SaveImage(ctx, outputKey, result, outputFormat, encodeOptions)
+	if err != nil {
+		return fmt.Errorf("%s, %w", op, err)
+	}
+
+	// register the result as a derivative of the original
+	derivativeID, err := storeDerivative(ctx, storage, imgStorage, kafkaMessage, kafkaMessage.Action, "", appliedParameters, outputKey, stored)
+	if err != nil {
+		return fmt.Errorf("%s, %w", op, err)
 	}
+
 	// after updating change status parameter
 	err = storage.UpdateStatus(kafkaMessage.Id, modifiedStatus)
 	if err != nil {
@@ -109,9 +270,38 @@ func ConsumedHandler(msg []byte, storage *sqlite.StorageSqlite, log *slog.Logger
 	}
 	log.Debug("image is modified",
 		slog.Int("Id", kafkaMessage.Id),
+		slog.Int("derivative_id", derivativeID),
 		slog.String("action", kafkaMessage.Action),
 	)
 
 	return nil
 
 }
+
+// storeDerivative registers the stored blob as a derivative of the image;
+// the blob is removed if it can not be registered
+func storeDerivative(ctx context.Context, storage *sqlite.StorageSqlite, imgStorage img_storage.ImageStorage, kafkaMessage models.KafkaMessage, action, variant string,
+	parameters any, key string, stored *img_storage.StoredFile) (int, error) {
+	encodedParameters, err := json.Marshal(parameters)
+	if err != nil {
+		_ = imgStorage.Blobs.Delete(ctx, key)
+		return 0, err
+	}
+
+	derivativeID, err := storage.SetDerivative(&models.Derivative{
+		ImageId:    kafkaMessage.Id,
+		JobId:      kafkaMessage.JobId,
+		Action:     action,
+		Variant:    variant,
+		Parameters: string(encodedParameters),
+		Path:       key,
+		MimeType:   img_storage.MimeTypeByExtension(path.Ext(key)),
+		FileSize:   stored.Size,
+		Checksum:   stored.Checksum,
+	})
+	if err != nil {
+		_ = imgStorage.Blobs.Delete(ctx, key)
+		return 0, err
+	}
+	return derivativeID, nil
+}
@@ -0,0 +1,61 @@
+package consumer
+
+import (
+	"context"
+	"fmt"
+	img_storage "imageProcessor/internal/img-storage"
+	"imageProcessor/internal/models"
+	"imageProcessor/internal/storage/sqlite"
+	"log/slog"
+	"strconv"
+)
+
+// handleMiniature produces square thumbnails of every requested size;
+// each thumbnail is stored as a derivative with the size as its variant
+func handleMiniature(ctx context.Context, kafkaMessage models.KafkaMessage, metadata *models.ImageMetadata, parameters *models.MiniatureParameters, storage *sqlite.StorageSqlite, imgStorage img_storage.ImageStorage, log *slog.Logger) error {
+	const op = "kafka.consumer.handleMiniature"
+
+	sizes := imgStorage.ThumbnailSizes
+	if parameters != nil && len(parameters.Sizes) > 0 {
+		sizes = parameters.Sizes
+	}
+	if len(sizes) == 0 {
+		return fmt.Errorf("thumbnail sizes are not configured; %s", op)
+	}
+	for _, size := range sizes {
+		if !imgStorage.IsThumbnailSize(size) {
+			return fmt.Errorf("thumbnail size %d is not configured; %s", size, op)
+		}
+	}
+
+	// the original is decoded once for all sizes
+	img, format, err := imgStorage.DecodeImage(ctx, metadata.OriginalPath)
+	if err != nil {
+		return fmt.Errorf("%s, %w", op, err)
+	}
+
+	for _, size := range sizes {
+		outputFormat := img_storage.OutputFormat(format)
+		outputKey := imgStorage.NewDerivativeKey(kafkaMessage.Id, miniatureAction, img_storage.FormatExtension(outputFormat))
+		stored, err := imgStorage.SaveImage(ctx, outputKey, img_storage.Thumbnail(img, size), outputFormat, img_storage.EncodeOptions{})
+		if err != nil {
+			return fmt.Errorf("%s, %w", op, err)
+		}
+
+		applied := models.MiniatureParameters{Sizes: []int{size}}
+		derivativeID, err := storeDerivative(ctx, storage, imgStorage, kafkaMessage, miniatureAction, strconv.Itoa(size), applied, outputKey, stored)
+		if err != nil {
+			return fmt.Errorf("%s, %w", op, err)
+		}
+		log.Debug("thumbnail is created",
+			slog.Int("Id", kafkaMessage.Id),
+			slog.Int("size", size),
+			slog.Int("derivative_id", derivativeID),
+		)
+	}
+
+	if err = storage.UpdateStatus(kafkaMessage.Id, modifiedStatus); err != nil {
+		return fmt.Errorf("%s, %w", op, err)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package consumer
+
+import (
+	"context"
+	"fmt"
+	img_storage "imageProcessor/internal/img-storage"
+	"imageProcessor/internal/models"
+	"imageProcessor/internal/queue"
+	"imageProcessor/internal/storage/sqlite"
+	"log/slog"
+	"time"
+)
+
+// handlePipeline executes all steps of the pipeline over one decoded image;
+// the image is encoded only once after the last step
+func handlePipeline(ctx context.Context, kafkaMessage models.KafkaMessage, metadata *models.ImageMetadata, storage *sqlite.StorageSqlite, imgStorage img_storage.ImageStorage,
+	limiter *queue.ActionLimiter, log *slog.Logger) error {
+	const op = "kafka.consumer.handlePipeline"
+
+	pipeline, err := storage.GetPipeline(kafkaMessage.PipelineId)
+	if err != nil {
+		return fmt.Errorf("%s, %w", op, err)
+	}
+	if pipeline.ImageId != kafkaMessage.Id {
+		return permanent(fmt.Errorf("pipeline %d does not belong to image %d; %s", pipeline.Id, kafkaMessage.Id, op))
+	}
+
+	if err = storage.UpdatePipelineStatus(pipeline.Id, processingStatus); err != nil {
+		return fmt.Errorf("%s, %w", op, err)
+	}
+
+	img, format, err := imgStorage.DecodeImage(ctx, metadata.OriginalPath)
+	if err != nil {
+		_ = storage.UpdatePipelineStatus(pipeline.Id, failedStatus)
+		return fmt.Errorf("%s, %w", op, err)
+	}
+
+	format = img_storage.OutputFormat(format)
+	var encodeOptions img_storage.EncodeOptions
+	operations := make([]models.Operation, 0, len(pipeline.Steps))
+	for i := range pipeline.Steps {
+		step := &pipeline.Steps[i]
+		operations = append(operations, step.Operation)
+
+		startedAt := time.Now()
+		step.Status = processingStatus
+		step.StartedAt = &startedAt
+		if err = storage.UpdatePipelineStep(pipeline.Id, step); err != nil {
+			return fmt.Errorf("%s, %w", op, err)
+		}
+
+		release, err := limiter.Acquire(ctx, step.Operation.Action)
+		if err != nil {
+			return fmt.Errorf("%s, %w", op, err)
+		}
+		result, stepErr := img_storage.ApplyOperation(img, step.Operation, watermarkAssets{ctx: ctx, tenantID: metadata.TenantId, storage: storage, imgStorage: imgStorage})
+		release()
+
+		finishedAt := time.Now()
+		step.FinishedAt = &finishedAt
+		step.DurationMs = finishedAt.Sub(startedAt).Milliseconds()
+		if stepErr != nil {
+			step.Status = failedStatus
+			step.Error = stepErr.Error()
+			_ = storage.UpdatePipelineStep(pipeline.Id, step)
+			_ = storage.UpdatePipelineStatus(pipeline.Id, failedStatus)
+			return fmt.Errorf("step %d failed; %s, %w", step.Position, op, stepErr)
+		}
+
+		step.Status = completedStatus
+		if err = storage.UpdatePipelineStep(pipeline.Id, step); err != nil {
+			return fmt.Errorf("%s, %w", op, err)
+		}
+		log.Debug("pipeline step is completed",
+			slog.Int("pipeline_id", pipeline.Id),
+			slog.Int("position", step.Position),
+			slog.String("action", step.Operation.Action),
+			slog.Int64("duration_ms", step.DurationMs),
+		)
+
+		img = result
+		if step.Operation.Action == models.ConvertAction {
+			format = step.Operation.Parameters.Convert.Format
+			encodeOptions = img_storage.EncodeOptionsFromParameters(step.Operation.Parameters.Convert)
+		}
+	}
+
+	outputKey := imgStorage.NewDerivativeKey(kafkaMessage.Id, pipelineAction, img_storage.FormatExtension(format))
+	stored, err := imgStorage.SaveImage(ctx, outputKey, img, format, encodeOptions)
+	if err != nil {
+		_ = storage.UpdatePipelineStatus(pipeline.Id, failedStatus)
+		return fmt.Errorf("%s, %w", op, err)
+	}
+
+	derivativeID, err := storeDerivative(ctx, storage, imgStorage, kafkaMessage, pipelineAction, "", operations, outputKey, stored)
+	if err != nil {
+		_ = storage.UpdatePipelineStatus(pipeline.Id, failedStatus)
+		return fmt.Errorf("%s, %w", op, err)
+	}
+
+	if err = storage.UpdatePipelineStatus(pipeline.Id, completedStatus); err != nil {
+		return fmt.Errorf("%s, %w", op, err)
+	}
+	if err = storage.UpdateStatus(kafkaMessage.Id, modifiedStatus); err != nil {
+		return fmt.Errorf("%s, %w", op, err)
+	}
+	log.Debug("pipeline is completed",
+		slog.Int("Id", kafkaMessage.Id),
+		slog.Int("pipeline_id", pipeline.Id),
+		slog.Int("derivative_id", derivativeID),
+	)
+
+	return nil
+}
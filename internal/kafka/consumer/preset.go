@@ -0,0 +1,37 @@
+package consumer
+
+import (
+	"errors"
+	"fmt"
+	"imageProcessor/internal/models"
+	storagePkg "imageProcessor/internal/storage"
+	"imageProcessor/internal/storage/sqlite"
+	"log/slog"
+)
+
+// resolvePreset replaces the preset reference of the message with the action
+// and parameters of the preset version taken by the job; the version and
+// the pipeline of a pipeline preset are recorded in the job once,
+// so retries of the message do not create pipelines again
+func resolvePreset(kafkaMessage *models.KafkaMessage, storage *sqlite.StorageSqlite, log *slog.Logger) error {
+	const op = "kafka.consumer.resolvePreset"
+
+	preset, pipelineID, err := storage.ResolveJobPreset(kafkaMessage.JobId, kafkaMessage.Id, kafkaMessage.Preset)
+	if errors.Is(err, storagePkg.ErrPresetNotFound) {
+		return permanent(fmt.Errorf("%s, %w", op, err))
+	}
+	if err != nil {
+		return fmt.Errorf("%s, %w", op, err)
+	}
+
+	kafkaMessage.Action = preset.Action
+	kafkaMessage.Parameters = preset.Parameters
+	kafkaMessage.PipelineId = pipelineID
+	log.Debug("preset is resolved",
+		slog.Int("Id", kafkaMessage.Id),
+		slog.String("preset", preset.Name),
+		slog.Int("version", preset.Version),
+		slog.String("action", preset.Action),
+	)
+	return nil
+}
@@ -0,0 +1,65 @@
+package kafka
+
+import (
+	"sync"
+
+	"github.com/IBM/sarama"
+)
+
+// offsetTracker commits offsets of one claimed partition in order:
+// messages finish in any order on the pool, but an offset is marked only
+// when every earlier message of the partition is finished
+type offsetTracker struct {
+	mu      sync.Mutex
+	session sarama.ConsumerGroupSession
+	pending []*trackedMessage
+	wg      sync.WaitGroup
+}
+
+type trackedMessage struct {
+	msg  *sarama.ConsumerMessage
+	done bool
+}
+
+func newOffsetTracker(session sarama.ConsumerGroupSession) *offsetTracker {
+	return &offsetTracker{session: session}
+}
+
+// add registers the message before it is handed to the pool
+func (t *offsetTracker) add(msg *sarama.ConsumerMessage) *trackedMessage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tracked := &trackedMessage{msg: msg}
+	t.pending = append(t.pending, tracked)
+	t.wg.Add(1)
+	return tracked
+}
+
+// complete marks the message as finished and commits the contiguous finished prefix
+func (t *offsetTracker) complete(tracked *trackedMessage) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	defer t.wg.Done()
+
+	tracked.done = true
+	marked := false
+	for len(t.pending) > 0 && t.pending[0].done {
+		t.session.MarkMessage(t.pending[0].msg, "")
+		t.pending = t.pending[1:]
+		marked = true
+	}
+	if marked {
+		t.session.Commit()
+	}
+}
+
+// abandon releases the message which is not processed in this session;
+// it stays uncommitted together with later messages, so the next owner receives it again
+func (t *offsetTracker) abandon(tracked *trackedMessage) {
+	t.wg.Done()
+}
+
+// wait blocks until every message handed to the pool is finished
+func (t *offsetTracker) wait() {
+	t.wg.Wait()
+}
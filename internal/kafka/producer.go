@@ -1,7 +1,7 @@
 package kafka
 
 import (
-	"encoding/json"
+	"imageProcessor/internal/queue"
 	"log/slog"
 
 	"github.com/IBM/sarama"
@@ -9,18 +9,13 @@ import (
 
 // internal/kafka/producer.go
 
-type Producer interface {
-	SendMessage(message interface{}) error
-	Close() error
-}
-
 type KafkaProducer struct {
 	producer sarama.SyncProducer
 	topic    string
 	logger   *slog.Logger
 }
 
-func NewProducer(brokers []string, topic string, log *slog.Logger) (Producer, error) {
+func NewProducer(brokers []string, topic string, log *slog.Logger) (*KafkaProducer, error) {
 	log.Info("producer is creating...")
 	config := sarama.NewConfig()
 	config.Producer.Return.Successes = true
@@ -34,7 +29,7 @@ func NewProducer(brokers []string, topic string, log *slog.Logger) (Producer, er
 }
 
 func (p *KafkaProducer) SendMessage(message interface{}) error {
-	data, err := json.Marshal(message)
+	key, data, err := queue.Encode(message)
 	if err != nil {
 		return err
 	}
@@ -43,6 +38,10 @@ func (p *KafkaProducer) SendMessage(message interface{}) error {
 		Topic: p.topic,
 		Value: sarama.ByteEncoder(data),
 	}
+	// messages of one image share the partition and keep their order
+	if key != nil {
+		msg.Key = sarama.ByteEncoder(key)
+	}
 
 	partition, offset, err := p.producer.SendMessage(msg)
 	if err != nil { // if message was not sent
@@ -59,6 +58,32 @@ func (p *KafkaProducer) SendMessage(message interface{}) error {
 	return nil
 }
 
+func (p *KafkaProducer) Publish(topic string, key, value []byte, headers map[string]string) error {
+	msg := &sarama.ProducerMessage{
+		Topic: topic,
+		Value: sarama.ByteEncoder(value),
+	}
+	if key != nil {
+		msg.Key = sarama.ByteEncoder(key)
+	}
+	for name, value := range headers {
+		msg.Headers = append(msg.Headers, sarama.RecordHeader{Key: []byte(name), Value: []byte(value)})
+	}
+
+	partition, offset, err := p.producer.SendMessage(msg)
+	if err != nil {
+		p.logger.Error("message was not sent", slog.String("topic", topic), "error", err)
+		return err
+	}
+
+	p.logger.Debug("message sent successfully",
+		slog.String("topic", topic),
+		slog.Int64("partition", int64(partition)),
+		slog.Int64("offset", offset),
+	)
+	return nil
+}
+
 func (p *KafkaProducer) Close() error {
 	p.logger.Info("producer is stopping...")
 	return p.producer.Close()
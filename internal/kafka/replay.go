@@ -0,0 +1,139 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"imageProcessor/internal/queue"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// DeadLetterReplayer moves messages of the dead-letter topic back to the topic.
+// Replayed offsets are committed by its own consumer group,
+// so every dead-lettered message is replayed once.
+type DeadLetterReplayer struct {
+	brokers  []string
+	topic    string
+	group    string
+	producer queue.Producer
+	mu       sync.Mutex
+}
+
+func NewDeadLetterReplayer(brokers []string, topic string, producer queue.Producer) *DeadLetterReplayer {
+	return &DeadLetterReplayer{
+		brokers:  brokers,
+		topic:    topic,
+		group:    queue.DeadLetterTopic(topic) + ".replay",
+		producer: producer,
+	}
+}
+
+// Replay republishes up to limit dead-lettered messages which were not replayed yet;
+// the number of replayed messages is returned. The attempt counter starts again.
+func (r *DeadLetterReplayer) Replay(ctx context.Context, limit int) (int, error) {
+	const op = "kafka.DeadLetterReplayer.Replay"
+
+	// replays are not run concurrently to keep committed offsets consistent
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	config := sarama.NewConfig()
+	config.Version = sarama.V3_9_1_0
+	config.Consumer.Return.Errors = true
+	config.Consumer.Offsets.Initial = sarama.OffsetOldest
+
+	client, err := sarama.NewClient(r.brokers, config)
+	if err != nil {
+		return 0, fmt.Errorf("%s,%w", op, err)
+	}
+	defer client.Close()
+
+	offsets, err := sarama.NewOffsetManagerFromClient(r.group, client)
+	if err != nil {
+		return 0, fmt.Errorf("%s,%w", op, err)
+	}
+	defer offsets.Close()
+
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		return 0, fmt.Errorf("%s,%w", op, err)
+	}
+	defer consumer.Close()
+
+	dlq := queue.DeadLetterTopic(r.topic)
+	partitions, err := client.Partitions(dlq)
+	if err != nil {
+		return 0, fmt.Errorf("%s,%w", op, err)
+	}
+
+	replayed := 0
+	for _, partition := range partitions {
+		if limit > 0 && replayed >= limit {
+			break
+		}
+		n, err := r.replayPartition(ctx, client, offsets, consumer, dlq, partition, limit-replayed)
+		replayed += n
+		if err != nil {
+			return replayed, fmt.Errorf("%s,%w", op, err)
+		}
+	}
+	return replayed, nil
+}
+
+// replayPartition republishes messages of the partition from the committed offset
+// up to the current end of the partition
+func (r *DeadLetterReplayer) replayPartition(ctx context.Context, client sarama.Client, offsets sarama.OffsetManager, consumer sarama.Consumer,
+	dlq string, partition int32, limit int) (int, error) {
+	partitionOffsets, err := offsets.ManagePartition(dlq, partition)
+	if err != nil {
+		return 0, err
+	}
+	defer partitionOffsets.Close()
+
+	next, _ := partitionOffsets.NextOffset()
+	oldest, err := client.GetOffset(dlq, partition, sarama.OffsetOldest)
+	if err != nil {
+		return 0, err
+	}
+	if next < oldest {
+		next = oldest
+	}
+	newest, err := client.GetOffset(dlq, partition, sarama.OffsetNewest)
+	if err != nil {
+		return 0, err
+	}
+	if next >= newest {
+		return 0, nil
+	}
+
+	partitionConsumer, err := consumer.ConsumePartition(dlq, partition, next)
+	if err != nil {
+		return 0, err
+	}
+	defer partitionConsumer.Close()
+
+	replayed := 0
+	for next < newest && (limit <= 0 || replayed < limit) {
+		select {
+		case msg := <-partitionConsumer.Messages():
+			headers := map[string]string{queue.HeaderReplayedAt: time.Now().UTC().Format(time.RFC3339)}
+			if err = r.producer.Publish(r.topic, msg.Key, msg.Value, headers); err != nil {
+				offsets.Commit()
+				return replayed, err
+			}
+			next = msg.Offset + 1
+			partitionOffsets.MarkOffset(next, "")
+			replayed++
+		case consumerErr := <-partitionConsumer.Errors():
+			offsets.Commit()
+			return replayed, consumerErr
+		case <-ctx.Done():
+			offsets.Commit()
+			return replayed, ctx.Err()
+		}
+	}
+	offsets.Commit()
+	return replayed, nil
+}
@@ -1,19 +1,165 @@
 package models
 
+import (
+	"strconv"
+	"time"
+)
+
 // ImageMetadata is used to send some image parameters into
 // metadata sql logic;
 type ImageMetadata struct {
+	Id               int
+	TenantId         int // owner of the image, 0 - uploaded without an API key
 	OriginalFilename string
 	OriginalPath     string
-	MimeType         string
+	Checksum         string // sha256 of the content, the original is shared by identical uploads
+	MimeType         string // detected by the content, e.g. image/jpeg
+	Width            int
+	Height           int
 	FileSize         int
 	Status           string // ["pending", "processing", "modified"]
 	Action           string
+	Parameters       *ActionParameters
+	Preset           string // name of the preset the action is taken from
+	PresetVersion    int    // version of the preset used by the worker
+	CreatedAt        time.Time
 }
 
 // available modified statuses: "resized", "watermarked", "miniatured"
 
+// Derivative describes a file produced by an action over an original image;
+// the original itself is never changed
+type Derivative struct {
+	Id         int
+	ImageId    int
+	JobId      int // job which produced the derivative, 0 for older results
+	Action     string
+	Variant    string // distinguishes several results of one action, e.g. thumbnail size
+	Parameters string // JSON encoded parameters of the action
+	Path       string
+	MimeType   string
+	FileSize   int
+	Checksum   string
+}
+
+// Pipeline is an ordered list of operations executed over one decoded image
+type Pipeline struct {
+	Id      int
+	ImageId int
+	Status  string
+	Steps   []PipelineStep
+}
+
+// PipelineStep keeps the operation of the pipeline together with its execution state
+type PipelineStep struct {
+	Position   int
+	Operation  Operation
+	Status     string // ["pending", "processing", "completed", "failed"]
+	Error      string
+	StartedAt  *time.Time
+	FinishedAt *time.Time
+	DurationMs int64
+}
+
+// Preset names an action or a pipeline with its parameters
+// so that clients do not repeat the same settings
+type Preset struct {
+	Id         int
+	TenantId   int // owner, 0 - created without an API key
+	Name       string
+	Version    int
+	Action     string
+	Parameters *ActionParameters
+	Pipeline   []Operation // set for the pipeline action
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// WatermarkAsset is an uploaded PNG logo used by image watermarks
+type WatermarkAsset struct {
+	Id               int
+	TenantId         int // owner, 0 - uploaded without an API key
+	OriginalFilename string
+	Path             string
+	Width            int
+	Height           int
+	FileSize         int
+	CreatedAt        time.Time
+}
+
+// Font is an uploaded TrueType font available to text watermarks
+type Font struct {
+	Id               int
+	TenantId         int // owner, 0 - uploaded without an API key
+	Family           string
+	Weight           string
+	OriginalFilename string
+	Path             string
+	FileSize         int
+	CreatedAt        time.Time
+}
+
 type KafkaMessage struct {
-	Id     int    `json:"id"`
-	Action string `json:"action"`
+	Id         int               `json:"id"`
+	Action     string            `json:"action"`
+	Parameters *ActionParameters `json:"parameters,omitempty"`
+	PipelineId int               `json:"pipeline_id,omitempty"` // set for the pipeline action
+	Preset     string            `json:"preset,omitempty"`      // set for the preset action, resolved by the worker
+	JobId      int               `json:"job_id,omitempty"`      // job which tracks processing of the message
+}
+
+// MessageKey keeps messages of one image in one partition and on one worker
+func (m KafkaMessage) MessageKey() string {
+	return strconv.Itoa(m.Id)
+}
+
+// Job tracks processing of one requested action by the worker
+type Job struct {
+	Id         int
+	ImageId    int
+	TenantId   int // owner of the image, kept after the image is removed
+	Action     string
+	PipelineId int
+	Status     string // ["pending", "processing", "modified", "deleted", "failed"]
+	Attempts   int
+	Error      string
+	QueuedAt   time.Time
+	StartedAt  *time.Time
+	FinishedAt *time.Time
+}
+
+// OutboxMessage is a message written together with the data it describes;
+// the relay publishes it to the queue after the transaction is committed
+type OutboxMessage struct {
+	Id       int
+	Key      []byte
+	Payload  []byte
+	Attempts int // failed publishing attempts
+}
+
+// QueueMessage is a message of the SQLite queue backend
+type QueueMessage struct {
+	Id          int
+	Topic       string
+	Key         []byte
+	Value       []byte
+	Headers     map[string]string
+	AvailableAt time.Time // the message is not delivered before, e.g. until the retry backoff passes
+}
+
+// Tenant owns uploaded images; requests with its API keys see only its images
+type Tenant struct {
+	Id        int
+	Name      string
+	CreatedAt time.Time
+}
+
+// APIKey authenticates requests of a tenant; only the sha256 of the key is stored
+type APIKey struct {
+	Id        int
+	TenantId  int
+	Name      string
+	KeyHash   string
+	Admin     bool // sees images of all tenants and manages tenants and keys
+	CreatedAt time.Time
 }
@@ -0,0 +1,561 @@
+package models
+
+import (
+	"fmt"
+	"image/color"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Actions which can be requested for an image
+const (
+	ResizeAction    = "resize"
+	MiniatureAction = "miniature"
+	WatermarkAction = "watermark"
+	ConvertAction   = "convert"
+	PipelineAction  = "pipeline" // ordered list of other actions
+	PresetAction    = "preset"   // named action resolved by the worker
+	DeleteAction    = "delete"   // removes the image with its files, not accepted from clients
+)
+
+// OutputFormats lists formats a result can be encoded into
+var OutputFormats = map[string]bool{
+	"jpeg": true,
+	"png":  true,
+	"gif":  true,
+	"bmp":  true,
+	"tiff": true,
+}
+
+// PNGCompressions lists names of PNG compression levels
+var PNGCompressions = map[string]bool{
+	"default": true,
+	"none":    true,
+	"speed":   true,
+	"best":    true,
+}
+
+// maxPipelineSteps limits the length of one pipeline
+const maxPipelineSteps = 16
+
+// Modes of fitting an image into width x height
+const (
+	FitMode     = "fit"     // вписать в размеры с сохранением пропорций
+	FillMode    = "fill"    // заполнить размеры целиком, лишнее обрезается
+	CropMode    = "crop"    // вырезать область без масштабирования
+	StretchMode = "stretch" // точные размеры без сохранения пропорций
+)
+
+var resizeModes = map[string]bool{
+	FitMode:     true,
+	FillMode:    true,
+	CropMode:    true,
+	StretchMode: true,
+}
+
+// ResampleFilters lists names of supported resampling filters
+var ResampleFilters = map[string]bool{
+	"nearest":    true,
+	"box":        true,
+	"linear":     true,
+	"hermite":    true,
+	"mitchell":   true,
+	"catmullrom": true,
+	"bspline":    true,
+	"gaussian":   true,
+	"bartlett":   true,
+	"lanczos":    true,
+	"hann":       true,
+	"hamming":    true,
+	"blackman":   true,
+	"welch":      true,
+	"cosine":     true,
+}
+
+// Anchors lists names of anchor points used by fill and crop modes
+var Anchors = map[string]bool{
+	"center":       true,
+	"top-left":     true,
+	"top":          true,
+	"top-right":    true,
+	"left":         true,
+	"right":        true,
+	"bottom-left":  true,
+	"bottom":       true,
+	"bottom-right": true,
+}
+
+var (
+	positionsX = map[string]bool{"left": true, "center": true, "right": true}
+	positionsY = map[string]bool{"top": true, "center": true, "bottom": true}
+)
+
+// FontWeights lists weights a font family can be uploaded with
+var FontWeights = map[string]bool{
+	"regular": true,
+	"medium":  true,
+	"bold":    true,
+}
+
+// DefaultFontFamily is embedded into the binary and always available
+const DefaultFontFamily = "go"
+
+// MaxWatermarkTextLength bounds watermark text in characters, not bytes
+const MaxWatermarkTextLength = 256
+
+// Limits of the outline and the shadow of watermark text in pixels
+const (
+	maxStrokeWidth  = 20
+	maxShadowOffset = 50
+)
+
+// presetName restricts preset names to be used in forms and URLs as is
+var presetName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// maxDimension limits requested width and height of a result
+const maxDimension = 10000
+
+// ActionParameters carries settings of the requested action;
+// only the field matching the action is taken into account
+type ActionParameters struct {
+	Resize    *ResizeParameters    `json:"resize,omitempty"`
+	Miniature *MiniatureParameters `json:"miniature,omitempty"`
+	Watermark *WatermarkParameters `json:"watermark,omitempty"`
+	Convert   *ConvertParameters   `json:"convert,omitempty"`
+}
+
+// MiniatureParameters selects square thumbnail sizes;
+// sizes must be among the configured presets, all of them are produced by default
+type MiniatureParameters struct {
+	Sizes []int `json:"sizes,omitempty"`
+}
+
+// ConvertParameters sets the format the result is encoded into
+// and options of its encoder; every option belongs to one format
+type ConvertParameters struct {
+	Format      string `json:"format"`                // jpeg, png, gif, bmp, tiff
+	Quality     int    `json:"quality,omitempty"`     // jpeg: 1-100, 95 by default
+	Compression string `json:"compression,omitempty"` // png: default, none, speed, best
+	Colors      int    `json:"colors,omitempty"`      // gif: palette size 2-256, 256 by default
+}
+
+// Operation is one step of a processing pipeline
+type Operation struct {
+	Action     string            `json:"action"`
+	Parameters *ActionParameters `json:"parameters,omitempty"`
+}
+
+// ResizeParameters is used by resize action
+type ResizeParameters struct {
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Mode   string `json:"mode,omitempty"`   // fit (default), fill, crop, stretch
+	Filter string `json:"filter,omitempty"` // lanczos by default
+	Anchor string `json:"anchor,omitempty"` // center by default; used by fill and crop
+}
+
+// WatermarkParameters is used by watermark action;
+// omitted fields are taken from the default watermark config
+type WatermarkParameters struct {
+	Text      string   `json:"text,omitempty"`
+	FontSize  float64  `json:"font_size,omitempty"` // 0 - proportional to the image
+	Opacity   *float64 `json:"opacity,omitempty"`   // 0.0-1.0
+	Rotation  *float64 `json:"rotation,omitempty"`  // degrees
+	PositionX string   `json:"position_x,omitempty"`
+	PositionY string   `json:"position_y,omitempty"`
+	OffsetX   int      `json:"offset_x,omitempty"`
+	OffsetY   int      `json:"offset_y,omitempty"`
+	Color     string   `json:"color,omitempty"` // #RRGGBB or #RRGGBBAA
+
+	Font       string            `json:"font,omitempty"`        // font family, go by default
+	FontWeight string            `json:"font_weight,omitempty"` // regular (default), medium, bold
+	Stroke     *StrokeParameters `json:"stroke,omitempty"`      // outline around the text
+	Shadow     *ShadowParameters `json:"shadow,omitempty"`      // drop shadow under the text
+
+	Tile *TileParameters `json:"tile,omitempty"` // repeat the text over the whole image
+	Logo *LogoParameters `json:"logo,omitempty"` // overlay an uploaded logo instead of text
+}
+
+// StrokeParameters draws an outline keeping the text readable on any background
+type StrokeParameters struct {
+	Width float64 `json:"width"`           // pixels
+	Color string  `json:"color,omitempty"` // #000000 by default
+}
+
+// ShadowParameters draws a copy of the text shifted under it
+type ShadowParameters struct {
+	OffsetX int    `json:"offset_x,omitempty"` // pixels, both offsets are 2 when omitted
+	OffsetY int    `json:"offset_y,omitempty"`
+	Color   string `json:"color,omitempty"` // #00000080 by default
+}
+
+// TileParameters repeats the watermark text in a grid rotated by the watermark rotation,
+// so the watermark can not be removed by cropping
+type TileParameters struct {
+	SpacingX int  `json:"spacing_x,omitempty"` // pixels between repeats in a row, 0 - text height
+	SpacingY int  `json:"spacing_y,omitempty"` // pixels between rows, 0 - text height
+	Stagger  bool `json:"stagger,omitempty"`   // shift every other row by half a step
+}
+
+// LogoParameters places an uploaded PNG logo over the image
+type LogoParameters struct {
+	AssetID  int      `json:"asset_id"`
+	Scale    float64  `json:"scale,omitempty"`    // logo width relative to image width, 0.2 by default
+	Opacity  *float64 `json:"opacity,omitempty"`  // 0.0-1.0, 1 by default
+	Position string   `json:"position,omitempty"` // anchor name, bottom-right by default
+	Margin   *int     `json:"margin,omitempty"`   // pixels from the image edges, 10 by default
+	Tile     bool     `json:"tile,omitempty"`     // repeat the logo over the whole image
+}
+
+// Validate checks parameters against the requested action
+// and fills omitted parameters with defaults where it is possible
+func (p *ActionParameters) Validate(action string) error {
+	switch action {
+	case ResizeAction:
+		if p.Resize == nil {
+			return fmt.Errorf("resize parameters are required")
+		}
+		return p.Resize.Validate()
+	case MiniatureAction:
+		if p.Miniature == nil {
+			p.Miniature = &MiniatureParameters{}
+		}
+		return p.Miniature.Validate()
+	case WatermarkAction:
+		if p.Watermark == nil {
+			p.Watermark = &WatermarkParameters{}
+		}
+		return p.Watermark.Validate()
+	case ConvertAction:
+		if p.Convert == nil {
+			return fmt.Errorf("convert parameters are required")
+		}
+		return p.Convert.Validate()
+	case PipelineAction:
+		return fmt.Errorf("action %q is available only inside a pipeline", action)
+	case PresetAction:
+		return fmt.Errorf("preset is referenced by name, not by action")
+	case "":
+		return fmt.Errorf("action isn't set")
+	default:
+		return fmt.Errorf("unknown action %q", action)
+	}
+}
+
+// ValidatePipeline checks every step of the pipeline;
+// a pipeline is encoded once after all steps, so the last convert step sets the format
+func ValidatePipeline(steps []Operation) error {
+	if len(steps) == 0 {
+		return fmt.Errorf("pipeline is empty")
+	}
+	if len(steps) > maxPipelineSteps {
+		return fmt.Errorf("pipeline must not be longer than %d steps", maxPipelineSteps)
+	}
+
+	for i := range steps {
+		if steps[i].Parameters == nil {
+			steps[i].Parameters = &ActionParameters{}
+		}
+		var err error
+		switch steps[i].Action {
+		case MiniatureAction:
+			// a step produces exactly one image
+			err = steps[i].Parameters.Validate(steps[i].Action)
+			if err == nil && len(steps[i].Parameters.Miniature.Sizes) != 1 {
+				err = fmt.Errorf("miniature step requires exactly one size")
+			}
+		default:
+			err = steps[i].Parameters.Validate(steps[i].Action)
+		}
+		if err != nil {
+			return fmt.Errorf("step %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+func (p *ConvertParameters) Validate() error {
+	if !OutputFormats[p.Format] {
+		return fmt.Errorf("unknown output format %q", p.Format)
+	}
+	if p.Quality != 0 {
+		if p.Format != "jpeg" {
+			return fmt.Errorf("quality is supported only by jpeg")
+		}
+		if p.Quality < 1 || p.Quality > 100 {
+			return fmt.Errorf("quality must be between 1 and 100")
+		}
+	}
+	if p.Compression != "" {
+		if p.Format != "png" {
+			return fmt.Errorf("compression is supported only by png")
+		}
+		if !PNGCompressions[p.Compression] {
+			return fmt.Errorf("unknown png compression %q", p.Compression)
+		}
+	}
+	if p.Colors != 0 {
+		if p.Format != "gif" {
+			return fmt.Errorf("colors are supported only by gif")
+		}
+		if p.Colors < 2 || p.Colors > 256 {
+			return fmt.Errorf("colors must be between 2 and 256")
+		}
+	}
+	return nil
+}
+
+func (p *ResizeParameters) Validate() error {
+	if p.Width < 0 || p.Height < 0 {
+		return fmt.Errorf("width and height must not be negative")
+	}
+	if p.Width > maxDimension || p.Height > maxDimension {
+		return fmt.Errorf("width and height must not exceed %d", maxDimension)
+	}
+	if p.Width == 0 && p.Height == 0 {
+		return fmt.Errorf("width or height must be set")
+	}
+
+	if p.Mode == "" {
+		p.Mode = FitMode
+	}
+	if !resizeModes[p.Mode] {
+		return fmt.Errorf("unknown resize mode %q", p.Mode)
+	}
+	if (p.Mode == FillMode || p.Mode == CropMode) && (p.Width == 0 || p.Height == 0) {
+		return fmt.Errorf("mode %q requires both width and height", p.Mode)
+	}
+
+	if p.Filter == "" {
+		p.Filter = "lanczos"
+	}
+	if !ResampleFilters[p.Filter] {
+		return fmt.Errorf("unknown resampling filter %q", p.Filter)
+	}
+
+	if p.Anchor == "" {
+		p.Anchor = "center"
+	}
+	if !Anchors[p.Anchor] {
+		return fmt.Errorf("unknown anchor %q", p.Anchor)
+	}
+	return nil
+}
+
+func (p *MiniatureParameters) Validate() error {
+	seen := make(map[int]bool, len(p.Sizes))
+	for _, size := range p.Sizes {
+		if size <= 0 || size > maxDimension {
+			return fmt.Errorf("miniature size %d is out of range", size)
+		}
+		if seen[size] {
+			return fmt.Errorf("miniature size %d is duplicated", size)
+		}
+		seen[size] = true
+	}
+	return nil
+}
+
+func (p *WatermarkParameters) Validate() error {
+	if p.Logo != nil {
+		if p.Text != "" || p.FontSize != 0 || p.Color != "" || p.Tile != nil ||
+			p.Font != "" || p.FontWeight != "" || p.Stroke != nil || p.Shadow != nil {
+			return fmt.Errorf("text settings can not be combined with a logo")
+		}
+		return p.Logo.Validate()
+	}
+	if err := ValidateWatermarkText(p.Text); err != nil {
+		return err
+	}
+	if p.FontSize < 0 {
+		return fmt.Errorf("font size must not be negative")
+	}
+	if p.Opacity != nil && (*p.Opacity < 0 || *p.Opacity > 1) {
+		return fmt.Errorf("opacity must be between 0 and 1")
+	}
+	if p.Rotation != nil && (*p.Rotation < -360 || *p.Rotation > 360) {
+		return fmt.Errorf("rotation must be between -360 and 360")
+	}
+	if p.PositionX != "" && !positionsX[p.PositionX] {
+		return fmt.Errorf("unknown horizontal position %q", p.PositionX)
+	}
+	if p.PositionY != "" && !positionsY[p.PositionY] {
+		return fmt.Errorf("unknown vertical position %q", p.PositionY)
+	}
+	if p.Color != "" {
+		if _, err := ParseHexColor(p.Color); err != nil {
+			return err
+		}
+	}
+	if p.Font == "" {
+		p.Font = DefaultFontFamily
+	}
+	if err := ValidateFontFamily(p.Font); err != nil {
+		return err
+	}
+	if p.FontWeight == "" {
+		p.FontWeight = "regular"
+	}
+	if !FontWeights[p.FontWeight] {
+		return fmt.Errorf("unknown font weight %q", p.FontWeight)
+	}
+	if p.Stroke != nil {
+		if err := p.Stroke.Validate(); err != nil {
+			return err
+		}
+	}
+	if p.Shadow != nil {
+		if err := p.Shadow.Validate(); err != nil {
+			return err
+		}
+	}
+	if p.Tile != nil {
+		if p.PositionX != "" || p.PositionY != "" || p.OffsetX != 0 || p.OffsetY != 0 {
+			return fmt.Errorf("position can not be combined with tiling")
+		}
+		return p.Tile.Validate()
+	}
+	return nil
+}
+
+func (p *StrokeParameters) Validate() error {
+	if p.Width <= 0 || p.Width > maxStrokeWidth {
+		return fmt.Errorf("stroke width must be between 0 and %d", maxStrokeWidth)
+	}
+	if p.Color == "" {
+		p.Color = "#000000"
+	}
+	_, err := ParseHexColor(p.Color)
+	return err
+}
+
+func (p *ShadowParameters) Validate() error {
+	if p.OffsetX == 0 && p.OffsetY == 0 {
+		p.OffsetX, p.OffsetY = 2, 2
+	}
+	if abs(p.OffsetX) > maxShadowOffset || abs(p.OffsetY) > maxShadowOffset {
+		return fmt.Errorf("shadow offset must not exceed %d", maxShadowOffset)
+	}
+	if p.Color == "" {
+		p.Color = "#00000080"
+	}
+	_, err := ParseHexColor(p.Color)
+	return err
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func (p *TileParameters) Validate() error {
+	if p.SpacingX < 0 || p.SpacingY < 0 {
+		return fmt.Errorf("tile spacing must not be negative")
+	}
+	if p.SpacingX > maxDimension || p.SpacingY > maxDimension {
+		return fmt.Errorf("tile spacing must not exceed %d", maxDimension)
+	}
+	return nil
+}
+
+// ParseHexColor parses colors in #RRGGBB and #RRGGBBAA forms
+func ParseHexColor(s string) (color.RGBA, error) {
+	hex := strings.TrimPrefix(s, "#")
+	if len(hex) == 6 {
+		hex += "ff"
+	}
+	if len(hex) != 8 {
+		return color.RGBA{}, fmt.Errorf("invalid color %q", s)
+	}
+
+	value, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.RGBA{}, fmt.Errorf("invalid color %q", s)
+	}
+
+	return color.RGBA{
+		R: uint8(value >> 24),
+		G: uint8(value >> 16),
+		B: uint8(value >> 8),
+		A: uint8(value),
+	}, nil
+}
+
+// ValidateWatermarkText checks the length of watermark text of actions and of transform URLs
+func ValidateWatermarkText(text string) error {
+	if utf8.RuneCountInString(text) > MaxWatermarkTextLength {
+		return fmt.Errorf("watermark text must not be longer than %d characters", MaxWatermarkTextLength)
+	}
+	return nil
+}
+
+// ValidateFontFamily checks that the family can be used in forms and file names;
+// the same rules as for preset names are applied
+func ValidateFontFamily(family string) error {
+	if !presetName.MatchString(family) {
+		return fmt.Errorf("font family must match %s", presetName.String())
+	}
+	return nil
+}
+
+// ValidatePresetName checks that the name can be used in forms and URLs
+func ValidatePresetName(name string) error {
+	if !presetName.MatchString(name) {
+		return fmt.Errorf("preset name must match %s", presetName.String())
+	}
+	return nil
+}
+
+// Validate checks the name and the definition of the preset
+func (p *Preset) Validate() error {
+	if err := ValidatePresetName(p.Name); err != nil {
+		return err
+	}
+
+	if p.Action == PipelineAction {
+		if p.Parameters != nil {
+			return fmt.Errorf("pipeline preset takes parameters from its steps")
+		}
+		return ValidatePipeline(p.Pipeline)
+	}
+	if p.Pipeline != nil {
+		return fmt.Errorf("pipeline is allowed only for the pipeline action")
+	}
+	if p.Parameters == nil {
+		p.Parameters = &ActionParameters{}
+	}
+	return p.Parameters.Validate(p.Action)
+}
+
+func (p *LogoParameters) Validate() error {
+	if p.AssetID <= 0 {
+		return fmt.Errorf("logo asset id is required")
+	}
+	if p.Scale == 0 {
+		p.Scale = 0.2
+	}
+	if p.Scale < 0 || p.Scale > 1 {
+		return fmt.Errorf("logo scale must be between 0 and 1")
+	}
+	if p.Opacity != nil && (*p.Opacity < 0 || *p.Opacity > 1) {
+		return fmt.Errorf("opacity must be between 0 and 1")
+	}
+	if p.Position == "" {
+		p.Position = "bottom-right"
+	}
+	if !Anchors[p.Position] {
+		return fmt.Errorf("unknown logo position %q", p.Position)
+	}
+	if p.Margin == nil {
+		margin := 10
+		p.Margin = &margin
+	}
+	if *p.Margin < 0 || *p.Margin > maxDimension {
+		return fmt.Errorf("logo margin is out of range")
+	}
+	return nil
+}
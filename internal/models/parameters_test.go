@@ -0,0 +1,93 @@
+package models
+
+import "testing"
+
+func TestActionParametersValidate(t *testing.T) {
+	opacity := 1.5
+	tests := []struct {
+		name       string
+		action     string
+		parameters ActionParameters
+		wantErr    bool
+	}{
+		{
+			name:       "resize with width only",
+			action:     ResizeAction,
+			parameters: ActionParameters{Resize: &ResizeParameters{Width: 300}},
+		},
+		{
+			name:    "resize without parameters",
+			action:  ResizeAction,
+			wantErr: true,
+		},
+		{
+			name:       "fill requires both dimensions",
+			action:     ResizeAction,
+			parameters: ActionParameters{Resize: &ResizeParameters{Width: 300, Mode: FillMode}},
+			wantErr:    true,
+		},
+		{
+			name:       "unknown filter",
+			action:     ResizeAction,
+			parameters: ActionParameters{Resize: &ResizeParameters{Width: 300, Filter: "bicubic"}},
+			wantErr:    true,
+		},
+		{
+			name:   "watermark defaults",
+			action: WatermarkAction,
+		},
+		{
+			name:       "watermark opacity out of range",
+			action:     WatermarkAction,
+			parameters: ActionParameters{Watermark: &WatermarkParameters{Opacity: &opacity}},
+			wantErr:    true,
+		},
+		{
+			name:       "watermark invalid color",
+			action:     WatermarkAction,
+			parameters: ActionParameters{Watermark: &WatermarkParameters{Color: "#zzzzzz"}},
+			wantErr:    true,
+		},
+		{
+			name:       "convert with jpeg quality",
+			action:     ConvertAction,
+			parameters: ActionParameters{Convert: &ConvertParameters{Format: "jpeg", Quality: 80}},
+		},
+		{
+			name:       "convert option of another format",
+			action:     ConvertAction,
+			parameters: ActionParameters{Convert: &ConvertParameters{Format: "png", Quality: 80}},
+			wantErr:    true,
+		},
+		{
+			name:       "convert into decode only format",
+			action:     ConvertAction,
+			parameters: ActionParameters{Convert: &ConvertParameters{Format: "webp"}},
+			wantErr:    true,
+		},
+		{
+			name:    "unknown action",
+			action:  "rotate",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.parameters.Validate(tt.action)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestResizeParametersDefaults(t *testing.T) {
+	p := ResizeParameters{Width: 100, Height: 50}
+	if err := p.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if p.Mode != FitMode || p.Filter != "lanczos" || p.Anchor != "center" {
+		t.Errorf("unexpected defaults %+v", p)
+	}
+}
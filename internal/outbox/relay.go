@@ -0,0 +1,126 @@
+// Package outbox publishes messages written to the outbox table together with the data
+// they describe; a message of an accepted request is published even when the queue
+// was unavailable while the request was handled.
+package outbox
+
+import (
+	"context"
+	"imageProcessor/internal/models"
+	"imageProcessor/internal/queue"
+	"log/slog"
+	"time"
+)
+
+// lease is the time a claimed message stays hidden from relays of other processes
+const lease = 30 * time.Second
+
+// pruneInterval is the pause between deletions of sent messages,
+// one deletion removes at most pruneBatchSize messages
+const (
+	pruneInterval  = time.Minute
+	pruneBatchSize = 1000
+)
+
+// Store keeps messages of the outbox
+type Store interface {
+	ClaimOutbox(limit int, lease time.Duration) ([]models.OutboxMessage, error)
+	MarkOutboxSent(id int) error
+	RetryOutbox(id int, reason string, availableAt time.Time) error
+	PruneOutbox(sentBefore time.Time, limit int) (int, error)
+}
+
+// Config bounds polling of the outbox and the backoff between failed publishing attempts;
+// sent messages are deleted after Retention, zero keeps them
+type Config struct {
+	PollInterval   time.Duration
+	BatchSize      int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Retention      time.Duration
+}
+
+// Relay publishes messages of the outbox to the topic; a message is marked as sent
+// only after the queue accepts it, so it is published at least once
+type Relay struct {
+	store    Store
+	producer queue.Producer
+	topic    string
+	cfg      Config
+	log      *slog.Logger
+}
+
+func NewRelay(store Store, producer queue.Producer, topic string, cfg Config, log *slog.Logger) *Relay {
+	return &Relay{store: store, producer: producer, topic: topic, cfg: cfg, log: log}
+}
+
+// Run polls the outbox until ctx is cancelled
+func (r *Relay) Run(ctx context.Context) {
+	r.log.Info("outbox relay is starting...")
+	ticker := time.NewTicker(max(r.cfg.PollInterval, 10*time.Millisecond))
+	defer ticker.Stop()
+	pruneTicker := time.NewTicker(pruneInterval)
+	defer pruneTicker.Stop()
+
+	for {
+		// a full batch means more messages are waiting, they are taken without a pause
+		if r.relay() == max(r.cfg.BatchSize, 1) && ctx.Err() == nil {
+			continue
+		}
+		select {
+		case <-ticker.C:
+		case <-pruneTicker.C:
+			r.prune()
+		case <-ctx.Done():
+			r.log.Info("outbox relay is stopped")
+			return
+		}
+	}
+}
+
+// relay publishes one batch of messages and returns the number of claimed messages
+func (r *Relay) relay() int {
+	const op = "outbox.Relay.relay"
+
+	messages, err := r.store.ClaimOutbox(max(r.cfg.BatchSize, 1), lease)
+	if err != nil {
+		r.log.Error("claiming outbox messages failed", "op", op, "err", err)
+		return 0
+	}
+
+	policy := queue.RetryPolicy{InitialBackoff: r.cfg.InitialBackoff, MaxBackoff: r.cfg.MaxBackoff}
+	for _, message := range messages {
+		if err = r.producer.Publish(r.topic, message.Key, message.Payload, nil); err != nil {
+			delay := policy.Backoff(message.Attempts + 1)
+			r.log.Error("publishing outbox message failed", "op", op, "id", message.Id, "attempt", message.Attempts+1, "delay", delay, "err", err)
+			if err = r.store.RetryOutbox(message.Id, err.Error(), time.Now().Add(delay)); err != nil {
+				r.log.Error("outbox message is not rescheduled", "op", op, "id", message.Id, "err", err)
+			}
+			// the queue is likely unavailable, the rest of the batch is taken again after the lease
+			return 0
+		}
+		if err = r.store.MarkOutboxSent(message.Id); err != nil {
+			// the message is published again after the lease, the worker gets a duplicate
+			r.log.Error("outbox message is not marked as sent", "op", op, "id", message.Id, "err", err)
+		}
+	}
+	return len(messages)
+}
+
+// prune deletes messages sent earlier than the retention;
+// a message which is not sent yet is never deleted
+func (r *Relay) prune() int {
+	const op = "outbox.Relay.prune"
+
+	if r.cfg.Retention <= 0 {
+		return 0
+	}
+	deleted, err := r.store.PruneOutbox(time.Now().Add(-r.cfg.Retention), pruneBatchSize)
+	if err != nil {
+		r.log.Error("pruning outbox failed", "op", op, "err", err)
+		return 0
+	}
+	if deleted > 0 {
+		r.log.Debug("sent outbox messages are deleted", "op", op, "count", deleted)
+	}
+	return deleted
+}
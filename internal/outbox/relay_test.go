@@ -0,0 +1,100 @@
+package outbox
+
+import (
+	"errors"
+	"imageProcessor/internal/models"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+type memoryStore struct {
+	pending []models.OutboxMessage
+	sent    []int
+	retried []int
+	sentAt  map[int]time.Time
+}
+
+func (s *memoryStore) ClaimOutbox(limit int, lease time.Duration) ([]models.OutboxMessage, error) {
+	claimed := s.pending[:min(limit, len(s.pending))]
+	s.pending = s.pending[len(claimed):]
+	return claimed, nil
+}
+
+func (s *memoryStore) MarkOutboxSent(id int) error {
+	s.sent = append(s.sent, id)
+	return nil
+}
+
+func (s *memoryStore) RetryOutbox(id int, reason string, availableAt time.Time) error {
+	s.retried = append(s.retried, id)
+	return nil
+}
+
+func (s *memoryStore) PruneOutbox(sentBefore time.Time, limit int) (int, error) {
+	deleted := 0
+	for id, sentAt := range s.sentAt {
+		if deleted < limit && sentAt.Before(sentBefore) {
+			delete(s.sentAt, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+type flakyProducer struct {
+	down      bool
+	published []string
+}
+
+func (p *flakyProducer) SendMessage(message interface{}) error { return nil }
+func (p *flakyProducer) Close() error                          { return nil }
+
+func (p *flakyProducer) Publish(topic string, key, value []byte, headers map[string]string) error {
+	if p.down {
+		return errors.New("kafka: client has run out of available brokers")
+	}
+	p.published = append(p.published, string(value))
+	return nil
+}
+
+func TestRelayPublishesAfterQueueRecovers(t *testing.T) {
+	store := &memoryStore{pending: []models.OutboxMessage{{Id: 1, Payload: []byte("first")}, {Id: 2, Payload: []byte("second")}}}
+	producer := &flakyProducer{down: true}
+	relay := NewRelay(store, producer, "image-upload", Config{BatchSize: 10}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	relay.relay()
+	if len(store.sent) != 0 || len(store.retried) != 1 || store.retried[0] != 1 {
+		t.Fatalf("a failed message must be rescheduled and not marked as sent, sent %v, retried %v", store.sent, store.retried)
+	}
+
+	// the failed message and the rest of the batch are claimed again after the lease
+	store.pending = []models.OutboxMessage{{Id: 1, Payload: []byte("first"), Attempts: 1}, {Id: 2, Payload: []byte("second")}}
+	producer.down = false
+	relay.relay()
+	if len(store.sent) != 2 || len(producer.published) != 2 || producer.published[0] != "first" {
+		t.Errorf("messages must be published in order once the queue is available, published %v", producer.published)
+	}
+}
+
+func TestRelayPrunesSentMessages(t *testing.T) {
+	store := &memoryStore{sentAt: map[int]time.Time{
+		1: time.Now().Add(-48 * time.Hour),
+		2: time.Now().Add(-time.Hour),
+	}}
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	// without a retention sent messages are kept
+	if deleted := NewRelay(store, &flakyProducer{}, "image-upload", Config{}, log).prune(); deleted != 0 {
+		t.Fatalf("messages are deleted without a retention: %d", deleted)
+	}
+
+	relay := NewRelay(store, &flakyProducer{}, "image-upload", Config{Retention: 24 * time.Hour}, log)
+	if deleted := relay.prune(); deleted != 1 {
+		t.Fatalf("expected one message older than the retention to be deleted, got %d", deleted)
+	}
+	if _, ok := store.sentAt[2]; !ok || len(store.sentAt) != 1 {
+		t.Errorf("a message within the retention is deleted: %v", store.sentAt)
+	}
+}
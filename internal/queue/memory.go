@@ -0,0 +1,144 @@
+package queue
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+)
+
+type memoryMessage struct {
+	topic       string
+	key         []byte
+	value       []byte
+	headers     map[string]string
+	availableAt time.Time
+}
+
+// Memory keeps messages in the process, so it works only when the API and the worker
+// run in one process; queued messages are lost on restart
+type Memory struct {
+	mu       sync.Mutex
+	topic    string
+	messages []*memoryMessage
+	notify   chan struct{}
+}
+
+func NewMemory(topic string) *Memory {
+	return &Memory{topic: topic, notify: make(chan struct{}, 1)}
+}
+
+func (q *Memory) SendMessage(message interface{}) error {
+	key, value, err := Encode(message)
+	if err != nil {
+		return err
+	}
+	return q.Publish(q.topic, key, value, nil)
+}
+
+// Publish queues the message; a retried message is not delivered until its x-retry-at time
+func (q *Memory) Publish(topic string, key, value []byte, headers map[string]string) error {
+	msg := &memoryMessage{topic: topic, key: key, value: value, headers: headers}
+	if at, err := time.Parse(time.RFC3339Nano, headers[HeaderRetryAt]); err == nil {
+		msg.availableAt = at
+	}
+
+	q.mu.Lock()
+	q.messages = append(q.messages, msg)
+	q.mu.Unlock()
+
+	if delay := time.Until(msg.availableAt); delay > 0 {
+		time.AfterFunc(delay, q.wake)
+	}
+	q.wake()
+	return nil
+}
+
+// Consume delivers available messages of the topics in the order they were published
+func (q *Memory) Consume(ctx context.Context, topics []string, handle HandleFunc) error {
+	var taken sync.WaitGroup
+	defer taken.Wait()
+
+	for ctx.Err() == nil {
+		msg := q.take(topics)
+		if msg == nil {
+			select {
+			case <-q.notify:
+			case <-ctx.Done():
+			}
+			continue
+		}
+
+		taken.Add(1)
+		delivery := NewDelivery(msg.topic, msg.key, msg.value, msg.headers,
+			taken.Done,
+			func() {
+				defer taken.Done()
+				q.putBack(msg)
+			})
+		if !handle(ctx, delivery) {
+			delivery.Release()
+		}
+	}
+	return nil
+}
+
+// Replay moves dead-lettered messages back to the topic, the attempt counter starts again
+func (q *Memory) Replay(ctx context.Context, limit int) (int, error) {
+	dlq := DeadLetterTopic(q.topic)
+	replayedAt := time.Now().UTC().Format(time.RFC3339)
+
+	q.mu.Lock()
+	replayed := 0
+	for _, msg := range q.messages {
+		if limit > 0 && replayed >= limit {
+			break
+		}
+		if msg.topic != dlq {
+			continue
+		}
+		msg.topic = q.topic
+		msg.headers = map[string]string{HeaderReplayedAt: replayedAt}
+		msg.availableAt = time.Time{}
+		replayed++
+	}
+	q.mu.Unlock()
+
+	q.wake()
+	return replayed, nil
+}
+
+func (q *Memory) Close() error {
+	return nil
+}
+
+// take removes the first available message of the topics
+func (q *Memory) take(topics []string) *memoryMessage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	for i, msg := range q.messages {
+		if msg.availableAt.After(now) || !slices.Contains(topics, msg.topic) {
+			continue
+		}
+		q.messages = append(q.messages[:i], q.messages[i+1:]...)
+		return msg
+	}
+	return nil
+}
+
+// putBack returns the released message to the head of the queue
+func (q *Memory) putBack(msg *memoryMessage) {
+	q.mu.Lock()
+	q.messages = append([]*memoryMessage{msg}, q.messages...)
+	q.mu.Unlock()
+	q.wake()
+}
+
+func (q *Memory) wake() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
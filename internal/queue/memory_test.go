@@ -0,0 +1,81 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+)
+
+type permanentFailure struct{}
+
+func (permanentFailure) Error() string   { return "invalid parameters" }
+func (permanentFailure) Permanent() bool { return true }
+
+func TestMemoryRunRetriesAndDeadLetters(t *testing.T) {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	q := NewMemory("images")
+	policy := RetryPolicy{MaxAttempts: 3, InitialBackoff: 10 * time.Millisecond, MaxBackoff: 10 * time.Millisecond}
+
+	var mu sync.Mutex
+	attempts := map[string]int{}
+	done := make(chan struct{}, 2)
+	process := func(ctx context.Context, value []byte, lastAttempt bool) error {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts[string(value)]++
+		switch string(value) {
+		case "flaky":
+			if attempts["flaky"] == 1 {
+				return errors.New("storage is unavailable")
+			}
+		case "broken":
+			done <- struct{}{}
+			return permanentFailure{}
+		}
+		done <- struct{}{}
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	finished := make(chan error, 1)
+	go func() {
+		finished <- Run(ctx, log, q, "images", policy, PoolConfig{Workers: 2}, process)
+	}()
+
+	_ = q.Publish("images", []byte("1"), []byte("flaky"), nil)
+	_ = q.Publish("images", []byte("2"), []byte("broken"), nil)
+	for range 2 {
+		select {
+		case <-done:
+		case <-time.After(2 * time.Second):
+			t.Fatal("messages are not processed")
+		}
+	}
+	cancel()
+	if err := <-finished; err != nil {
+		t.Fatal(err)
+	}
+
+	if attempts["flaky"] != 2 {
+		t.Errorf("the failed message must be retried once, got %d attempts", attempts["flaky"])
+	}
+	if attempts["broken"] != 1 {
+		t.Errorf("a permanent failure must not be retried, got %d attempts", attempts["broken"])
+	}
+
+	replayed, err := q.Replay(context.Background(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replayed != 1 {
+		t.Errorf("expected the dead-lettered message to be replayed, got %d", replayed)
+	}
+	msg := q.take([]string{"images"})
+	if msg == nil || string(msg.value) != "broken" || msg.headers[HeaderAttempt] != "" {
+		t.Errorf("the replayed message must start again from the first attempt, got %+v", msg)
+	}
+}
@@ -0,0 +1,114 @@
+package queue
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// PoolConfig configures the worker pool of the consumer
+type PoolConfig struct {
+	Workers          int           // number of workers processing messages in parallel
+	QueueDepth       int           // messages waiting for every worker before the consumer blocks
+	SimulatedLatency time.Duration // artificial delay before every message, for demos
+}
+
+type task struct {
+	key string
+	run func()
+}
+
+// WorkerPool runs messages in parallel; messages with the same key go to the same worker,
+// so results of one image are produced in the order of the messages
+type WorkerPool struct {
+	queues  []chan task
+	latency time.Duration
+	wg      sync.WaitGroup
+}
+
+func NewWorkerPool(cfg PoolConfig) *WorkerPool {
+	workers := max(cfg.Workers, 1)
+	pool := &WorkerPool{
+		queues:  make([]chan task, workers),
+		latency: cfg.SimulatedLatency,
+	}
+	for i := range pool.queues {
+		pool.queues[i] = make(chan task, max(cfg.QueueDepth, 0))
+		pool.wg.Add(1)
+		go pool.worker(pool.queues[i])
+	}
+	return pool
+}
+
+// Submit queues the message; it blocks while the queue of the worker is full
+// and reports false when ctx is done before the message is queued
+func (p *WorkerPool) Submit(ctx context.Context, key string, run func()) bool {
+	select {
+	case p.queues[p.workerIndex(key)] <- task{key: key, run: run}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Close waits for queued messages and stops the workers
+func (p *WorkerPool) Close() {
+	for _, queue := range p.queues {
+		close(queue)
+	}
+	p.wg.Wait()
+}
+
+// SimulatedLatency is the configured artificial delay of every message
+func (p *WorkerPool) SimulatedLatency() time.Duration {
+	return p.latency
+}
+
+func (p *WorkerPool) worker(queue <-chan task) {
+	defer p.wg.Done()
+	for t := range queue {
+		t.run()
+	}
+}
+
+func (p *WorkerPool) workerIndex(key string) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return int(h.Sum32() % uint32(len(p.queues)))
+}
+
+// ActionLimiter bounds the number of operations of one action running at once;
+// operations are counted one by one, so steps of pipelines and presets are limited as well
+type ActionLimiter struct {
+	limits map[string]chan struct{}
+}
+
+// NewActionLimiter creates a limiter, actions without a positive limit are not bounded
+func NewActionLimiter(limits map[string]int) *ActionLimiter {
+	l := &ActionLimiter{limits: make(map[string]chan struct{}, len(limits))}
+	for action, limit := range limits {
+		if limit > 0 {
+			l.limits[action] = make(chan struct{}, limit)
+		}
+	}
+	return l
+}
+
+// Acquire waits until the operation of the action may run and returns the function
+// which frees its place; it fails only when ctx is done. A nil limiter bounds nothing.
+func (l *ActionLimiter) Acquire(ctx context.Context, action string) (release func(), err error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	limit, ok := l.limits[action]
+	if !ok {
+		return func() {}, nil
+	}
+	select {
+	case limit <- struct{}{}:
+		return func() { <-limit }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
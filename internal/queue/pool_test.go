@@ -0,0 +1,79 @@
+package queue
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWorkerPoolKeepsOrderByKey(t *testing.T) {
+	pool := NewWorkerPool(PoolConfig{Workers: 4, QueueDepth: 2})
+
+	var mu sync.Mutex
+	processed := map[string][]int{}
+	for i := 0; i < 50; i++ {
+		key := strconv.Itoa(i % 5)
+		position := i
+		pool.Submit(context.Background(), key, func() {
+			mu.Lock()
+			processed[key] = append(processed[key], position)
+			mu.Unlock()
+		})
+	}
+	pool.Close()
+
+	for key, positions := range processed {
+		for i := 1; i < len(positions); i++ {
+			if positions[i] < positions[i-1] {
+				t.Fatalf("messages of key %s are reordered: %v", key, positions)
+			}
+		}
+	}
+}
+
+func TestActionLimiter(t *testing.T) {
+	pool := NewWorkerPool(PoolConfig{Workers: 8})
+	limiter := NewActionLimiter(map[string]int{"watermark": 2})
+
+	var running, peak atomic.Int32
+	for i := 0; i < 16; i++ {
+		pool.Submit(context.Background(), strconv.Itoa(i), func() {
+			// a pipeline takes the limit of every step
+			for _, action := range []string{"resize", "watermark"} {
+				release, err := limiter.Acquire(context.Background(), action)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if action == "watermark" {
+					current := running.Add(1)
+					for {
+						observed := peak.Load()
+						if current <= observed || peak.CompareAndSwap(observed, current) {
+							break
+						}
+					}
+					time.Sleep(5 * time.Millisecond)
+					running.Add(-1)
+				}
+				release()
+			}
+		})
+	}
+	pool.Close()
+
+	if peak.Load() > 2 {
+		t.Errorf("watermark limit is exceeded: %d at once", peak.Load())
+	}
+
+	// a nil limiter bounds nothing
+	var unlimited *ActionLimiter
+	release, err := unlimited.Acquire(context.Background(), "watermark")
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+}
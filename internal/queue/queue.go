@@ -0,0 +1,90 @@
+// Package queue abstracts the message broker between the API and the worker.
+// Kafka is the production backend; the in-memory and SQLite backends let the
+// service run without a broker for local development and tests.
+package queue
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// Backends which can be selected in the configuration
+const (
+	KafkaBackend  = "kafka"
+	MemoryBackend = "memory"
+	SQLiteBackend = "sqlite"
+)
+
+// Producer sends messages to the queue
+type Producer interface {
+	// SendMessage sends the JSON encoded message to the main topic
+	SendMessage(message interface{}) error
+	// Publish sends a raw message with headers to the topic, e.g. to the retry or dead-letter topic
+	Publish(topic string, key, value []byte, headers map[string]string) error
+	Close() error
+}
+
+// HandleFunc takes the delivery; it reports false when ctx is done
+// and the delivery is not taken, then the backend releases it
+type HandleFunc func(ctx context.Context, delivery *Delivery) bool
+
+// Consumer delivers messages of the topics until ctx is cancelled;
+// before returning it waits until taken deliveries are acknowledged or released
+type Consumer interface {
+	Consume(ctx context.Context, topics []string, handle HandleFunc) error
+}
+
+type Queue interface {
+	Producer
+	Consumer
+}
+
+// Replayer moves messages of the dead-letter topic back to processing
+type Replayer interface {
+	Replay(ctx context.Context, limit int) (int, error)
+}
+
+// Delivery is a received message; exactly one of Ack and Release is called for it
+type Delivery struct {
+	Topic   string
+	Key     []byte
+	Value   []byte
+	Headers map[string]string
+	ack     func()
+	release func()
+}
+
+func NewDelivery(topic string, key, value []byte, headers map[string]string, ack, release func()) *Delivery {
+	return &Delivery{Topic: topic, Key: key, Value: value, Headers: headers, ack: ack, release: release}
+}
+
+// Ack confirms the message is processed or routed, it is not delivered again
+func (d *Delivery) Ack() {
+	if d.ack != nil {
+		d.ack()
+	}
+}
+
+// Release gives the message back, it is delivered again
+func (d *Delivery) Release() {
+	if d.release != nil {
+		d.release()
+	}
+}
+
+func (d *Delivery) Header(key string) string {
+	return d.Headers[key]
+}
+
+// Encode marshals the message; messages which provide a key keep their order
+func Encode(message interface{}) (key, value []byte, err error) {
+	value, err = json.Marshal(message)
+	if err != nil {
+		return nil, nil, err
+	}
+	// messages of one image share the partition and keep their order
+	if keyed, ok := message.(interface{ MessageKey() string }); ok {
+		key = []byte(keyed.MessageKey())
+	}
+	return key, value, nil
+}
@@ -0,0 +1,107 @@
+package queue
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+)
+
+// headers of retried and dead-lettered messages
+const (
+	HeaderAttempt       = "x-attempt"         // number of the next processing attempt, starting from 1
+	HeaderRetryAt       = "x-retry-at"        // RFC 3339 time before which a retried message is not processed
+	HeaderError         = "x-error"           // reason of the last failure
+	HeaderPermanent     = "x-error-permanent" // "true" when retrying can not help, e.g. invalid parameters
+	HeaderOriginalTopic = "x-original-topic"
+	HeaderFailedAt      = "x-failed-at"
+	HeaderReplayedAt    = "x-replayed-at" // marks messages moved back from the dead-letter topic
+)
+
+// RetryTopic is the topic of messages waiting for another attempt
+func RetryTopic(topic string) string {
+	return topic + ".retry"
+}
+
+// DeadLetterTopic keeps messages which can not be processed
+func DeadLetterTopic(topic string) string {
+	return topic + ".dlq"
+}
+
+// RetryPolicy bounds the number of attempts and the exponential backoff between them
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// Backoff returns the delay after the failed attempt: initial, 2*initial, 4*initial...
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	delay := p.InitialBackoff
+	for i := 1; i < attempt && delay < p.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if p.MaxBackoff > 0 && delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+	return delay
+}
+
+// failureHandler routes failed messages to the retry or the dead-letter topic
+type failureHandler struct {
+	log       *slog.Logger
+	publisher Producer
+	topic     string
+	policy    RetryPolicy
+}
+
+// handle publishes the failed message for another attempt
+// or to the dead-letter topic when attempts are exhausted or the failure is permanent
+func (f *failureHandler) handle(delivery *Delivery, attempt int, permanent bool, failure error) error {
+	const op = "queue.failureHandler.handle"
+
+	if !permanent && attempt < f.policy.MaxAttempts {
+		delay := f.policy.Backoff(attempt)
+		headers := map[string]string{
+			HeaderAttempt: strconv.Itoa(attempt + 1),
+			HeaderRetryAt: time.Now().Add(delay).UTC().Format(time.RFC3339Nano),
+			HeaderError:   failure.Error(),
+		}
+		if err := f.publisher.Publish(RetryTopic(f.topic), delivery.Key, delivery.Value, headers); err != nil {
+			return fmt.Errorf("%s,%w", op, err)
+		}
+		f.log.Warn("message is scheduled for retry", "op", op, "attempt", attempt, "delay", delay, "err", failure)
+		return nil
+	}
+
+	headers := map[string]string{
+		HeaderAttempt:       strconv.Itoa(attempt),
+		HeaderError:         failure.Error(),
+		HeaderPermanent:     strconv.FormatBool(permanent),
+		HeaderOriginalTopic: f.topic,
+		HeaderFailedAt:      time.Now().UTC().Format(time.RFC3339),
+	}
+	if err := f.publisher.Publish(DeadLetterTopic(f.topic), delivery.Key, delivery.Value, headers); err != nil {
+		return fmt.Errorf("%s,%w", op, err)
+	}
+	f.log.Error("message is moved to the dead-letter topic", "op", op, "attempt", attempt, "err", failure)
+	return nil
+}
+
+// messageAttempt reads the attempt number of the message, a new message is the first attempt
+func messageAttempt(delivery *Delivery) int {
+	attempt, err := strconv.Atoi(delivery.Header(HeaderAttempt))
+	if err != nil || attempt < 1 {
+		return 1
+	}
+	return attempt
+}
+
+// retryAt reads the time before which the retried message must not be processed
+func retryAt(delivery *Delivery) time.Time {
+	at, err := time.Parse(time.RFC3339Nano, delivery.Header(HeaderRetryAt))
+	if err != nil {
+		return time.Time{}
+	}
+	return at
+}
@@ -0,0 +1,118 @@
+package queue
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Second, MaxBackoff: 5 * time.Second}
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, delay := range expected {
+		if got := policy.Backoff(i + 1); got != delay {
+			t.Errorf("attempt %d: expected %v, got %v", i+1, delay, got)
+		}
+	}
+}
+
+func TestMessageAttempt(t *testing.T) {
+	delivery := &Delivery{}
+	if attempt := messageAttempt(delivery); attempt != 1 {
+		t.Errorf("a new message is the first attempt, got %d", attempt)
+	}
+	delivery.Headers = map[string]string{HeaderAttempt: "3"}
+	if attempt := messageAttempt(delivery); attempt != 3 {
+		t.Errorf("expected the third attempt, got %d", attempt)
+	}
+}
+
+func TestRunnerDoesNotBlockOnRetryBackoff(t *testing.T) {
+	pool := NewWorkerPool(PoolConfig{Workers: 1})
+	defer pool.Close()
+
+	processed := make(chan string, 2)
+	r := &runner{
+		log:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+		pool: pool,
+		process: func(ctx context.Context, value []byte, lastAttempt bool) error {
+			processed <- string(value)
+			return nil
+		},
+		failures: &failureHandler{policy: RetryPolicy{MaxAttempts: 3}},
+	}
+
+	ctx := context.Background()
+	acked := make(chan struct{}, 2)
+	ack := func() { acked <- struct{}{} }
+	retried := NewDelivery("images.retry", []byte("1"), []byte("retried"),
+		map[string]string{HeaderAttempt: "2", HeaderRetryAt: time.Now().Add(200 * time.Millisecond).Format(time.RFC3339Nano)}, ack, nil)
+	fresh := NewDelivery("images.retry", []byte("2"), []byte("fresh"), nil, ack, nil)
+
+	started := time.Now()
+	if !r.handle(ctx, retried) || !r.handle(ctx, fresh) {
+		t.Fatal("deliveries must be taken")
+	}
+	if elapsed := time.Since(started); elapsed > 100*time.Millisecond {
+		t.Fatalf("the consumer is blocked by the backoff for %v", elapsed)
+	}
+	for _, expected := range []string{"fresh", "retried"} {
+		select {
+		case value := <-processed:
+			if value != expected {
+				t.Fatalf("expected %q to be processed, got %q", expected, value)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%q is not processed", expected)
+		}
+		<-acked
+	}
+
+	// a scheduled delivery is released when the consumer stops
+	ctx, cancel := context.WithCancel(context.Background())
+	released := make(chan struct{})
+	delayed := NewDelivery("images.retry", []byte("3"), []byte("delayed"),
+		map[string]string{HeaderRetryAt: time.Now().Add(time.Hour).Format(time.RFC3339Nano)}, ack, func() { close(released) })
+	r.handle(ctx, delayed)
+	cancel()
+	select {
+	case <-released:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the scheduled delivery is not released after the consumer stopped")
+	}
+}
+
+func TestRunnerReleasesInterruptedMessage(t *testing.T) {
+	pool := NewWorkerPool(PoolConfig{Workers: 1})
+	defer pool.Close()
+
+	started := make(chan struct{})
+	r := &runner{
+		log:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+		pool: pool,
+		process: func(ctx context.Context, value []byte, lastAttempt bool) error {
+			close(started)
+			<-ctx.Done()
+			return ctx.Err()
+		},
+		// the publisher is not set, routing the interrupted message would fail the test
+		failures: &failureHandler{policy: RetryPolicy{MaxAttempts: 1}},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	released := make(chan struct{})
+	delivery := NewDelivery("images", []byte("1"), []byte("long"), nil,
+		func() { t.Error("the interrupted message must not be acknowledged") }, func() { close(released) })
+	if !r.handle(ctx, delivery) {
+		t.Fatal("the delivery must be taken")
+	}
+	<-started
+	cancel()
+	select {
+	case <-released:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the interrupted message is not released")
+	}
+}
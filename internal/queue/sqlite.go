@@ -0,0 +1,123 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"imageProcessor/internal/models"
+	"imageProcessor/internal/storage"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	sqlitePollInterval = 200 * time.Millisecond
+	// sqliteLease is the time a taken message stays hidden from other workers;
+	// a worker which crashed without acknowledging the message gives it back when the lease expires
+	sqliteLease = 5 * time.Minute
+)
+
+// MessageStore keeps messages of the SQLite backend
+type MessageStore interface {
+	EnqueueMessage(msg *models.QueueMessage) error
+	ClaimQueueMessage(topics []string, lease time.Duration) (*models.QueueMessage, error)
+	AckQueueMessage(id int) error
+	ReleaseQueueMessage(id int) error
+	MoveQueueMessages(from, to string, headers map[string]string, limit int) (int, error)
+}
+
+// SQLite keeps messages in the database of the service, so the API and the worker
+// started as separate commands share the queue without a broker
+type SQLite struct {
+	store MessageStore
+	topic string
+	log   *slog.Logger
+}
+
+func NewSQLite(store MessageStore, topic string, log *slog.Logger) *SQLite {
+	return &SQLite{store: store, topic: topic, log: log}
+}
+
+func (q *SQLite) SendMessage(message interface{}) error {
+	key, value, err := Encode(message)
+	if err != nil {
+		return err
+	}
+	return q.Publish(q.topic, key, value, nil)
+}
+
+// Publish stores the message; a retried message is not delivered until its x-retry-at time
+func (q *SQLite) Publish(topic string, key, value []byte, headers map[string]string) error {
+	const op = "queue.SQLite.Publish"
+
+	msg := &models.QueueMessage{Topic: topic, Key: key, Value: value, Headers: headers}
+	if at, err := time.Parse(time.RFC3339Nano, headers[HeaderRetryAt]); err == nil {
+		msg.AvailableAt = at
+	}
+	if err := q.store.EnqueueMessage(msg); err != nil {
+		return fmt.Errorf("%s,%w", op, err)
+	}
+	return nil
+}
+
+// Consume polls the database for available messages of the topics
+func (q *SQLite) Consume(ctx context.Context, topics []string, handle HandleFunc) error {
+	const op = "queue.SQLite.Consume"
+
+	var taken sync.WaitGroup
+	defer taken.Wait()
+
+	for ctx.Err() == nil {
+		msg, err := q.store.ClaimQueueMessage(topics, sqliteLease)
+		if err != nil {
+			if !errors.Is(err, storage.ErrQueueEmpty) {
+				q.log.Error("claiming message failed", "op", op, "err", err)
+			}
+			sleep(ctx, sqlitePollInterval)
+			continue
+		}
+
+		taken.Add(1)
+		delivery := NewDelivery(msg.Topic, msg.Key, msg.Value, msg.Headers,
+			func() {
+				defer taken.Done()
+				if err := q.store.AckQueueMessage(msg.Id); err != nil {
+					q.log.Error("acknowledging message failed, it is delivered again after the lease", "op", op, "err", err)
+				}
+			},
+			func() {
+				defer taken.Done()
+				if err := q.store.ReleaseQueueMessage(msg.Id); err != nil {
+					q.log.Error("releasing message failed, it is delivered again after the lease", "op", op, "err", err)
+				}
+			})
+		if !handle(ctx, delivery) {
+			delivery.Release()
+		}
+	}
+	return nil
+}
+
+// Replay moves dead-lettered messages back to the topic, the attempt counter starts again;
+// limit <= 0 moves all of them
+func (q *SQLite) Replay(ctx context.Context, limit int) (int, error) {
+	const op = "queue.SQLite.Replay"
+
+	// a negative LIMIT of SQLite means no limit
+	if limit <= 0 {
+		limit = -1
+	}
+
+	headers := map[string]string{HeaderReplayedAt: time.Now().UTC().Format(time.RFC3339)}
+	replayed, err := q.store.MoveQueueMessages(DeadLetterTopic(q.topic), q.topic, headers, limit)
+	if err != nil {
+		return 0, fmt.Errorf("%s,%w", op, err)
+	}
+	return replayed, nil
+}
+
+// Close does nothing, the database is closed by its owner
+func (q *SQLite) Close() error {
+	return nil
+}
@@ -0,0 +1,49 @@
+package queue
+
+import (
+	"context"
+	"database/sql"
+	"imageProcessor/internal/storage/sqlite"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSQLiteReplayWithoutLimit(t *testing.T) {
+	schema, err := os.ReadFile("../../migrations/schema.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbPath := filepath.Join(t.TempDir(), "storage.db")
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(string(schema))
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage, err := sqlite.New(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q := NewSQLite(storage, "images", slog.New(slog.NewTextHandler(io.Discard, nil)))
+	for _, value := range []string{"a", "b", "c"} {
+		if err = q.Publish(DeadLetterTopic("images"), nil, []byte(value), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// like the other backends, a limit which is not positive replays everything
+	replayed, err := q.Replay(context.Background(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replayed != 3 {
+		t.Errorf("expected all dead-lettered messages to be replayed, got %d", replayed)
+	}
+}
@@ -0,0 +1,152 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"imageProcessor/internal/models"
+	"log/slog"
+	"strconv"
+	"time"
+)
+
+// redeliveryDelay is a pause before a message is processed again
+// when its failure can not be published to the retry or dead-letter topic
+const redeliveryDelay = 5 * time.Second
+
+// Processor handles the message value; lastAttempt is set when a failure
+// moves the message to the dead-letter topic instead of another retry.
+// ctx is done when the worker stops, the interrupted message is delivered again.
+type Processor func(ctx context.Context, value []byte, lastAttempt bool) error
+
+// IsPermanent reports whether retrying the failed message is useless;
+// such errors provide a Permanent method
+func IsPermanent(err error) bool {
+	var target interface{ Permanent() bool }
+	return errors.As(err, &target) && target.Permanent()
+}
+
+// Run consumes messages of the topic and of its retry topic on the worker pool until ctx is cancelled.
+// A message is acknowledged only after it is processed or routed to the retry
+// or dead-letter topic, so it is delivered at least once.
+func Run(ctx context.Context, log *slog.Logger, q Queue, topic string, policy RetryPolicy, poolConfig PoolConfig, process Processor) error {
+	const op = "queue.Run"
+
+	pool := NewWorkerPool(poolConfig)
+	defer pool.Close()
+
+	r := &runner{
+		log:      log,
+		pool:     pool,
+		failures: &failureHandler{log: log, publisher: q, topic: topic, policy: policy},
+		process:  process,
+	}
+	if err := q.Consume(ctx, []string{topic, RetryTopic(topic)}, r.handle); err != nil {
+		return fmt.Errorf("%s,%w", op, err)
+	}
+
+	log.Info("All consumers stopped gracefully")
+	return nil
+}
+
+// runner hands deliveries to the worker pool
+type runner struct {
+	log      *slog.Logger
+	pool     *WorkerPool
+	failures *failureHandler
+	process  Processor
+}
+
+func (r *runner) handle(ctx context.Context, delivery *Delivery) bool {
+	r.log.Info("Get message", "topic", delivery.Topic)
+
+	// a retried message waits for its backoff on a timer,
+	// the consumer goes on delivering other messages of the partition meanwhile
+	if delay := time.Until(retryAt(delivery)); delay > 0 {
+		if ctx.Err() != nil {
+			return false
+		}
+		r.schedule(ctx, delay, delivery)
+		return true
+	}
+	return r.submit(ctx, delivery)
+}
+
+// schedule submits the taken delivery to the worker pool after the delay;
+// the delivery is released when ctx is done before, so it is delivered again
+func (r *runner) schedule(ctx context.Context, delay time.Duration, delivery *Delivery) {
+	go func() {
+		if !sleep(ctx, delay) || !r.submit(ctx, delivery) {
+			delivery.Release()
+		}
+	}()
+}
+
+func (r *runner) submit(ctx context.Context, delivery *Delivery) bool {
+	return r.pool.Submit(ctx, messageKey(delivery), func() { r.run(ctx, delivery) })
+}
+
+// run processes the message on a worker of the pool; the message is not acknowledged
+// until it is handled or routed, a released message is delivered again
+func (r *runner) run(ctx context.Context, delivery *Delivery) {
+	const op = "queue.runner.run"
+
+	if !sleep(ctx, r.pool.SimulatedLatency()) {
+		delivery.Release()
+		return
+	}
+	for {
+		err := r.processMessage(ctx, delivery)
+		if err == nil {
+			break
+		}
+		r.log.Error("message is not routed, it is processed again", "op", op, "err", err)
+		if !sleep(ctx, redeliveryDelay) {
+			delivery.Release()
+			return
+		}
+	}
+	delivery.Ack()
+}
+
+// processMessage runs the processor and routes a failed message to the retry or dead-letter topic;
+// an error means the message is neither processed nor routed
+func (r *runner) processMessage(ctx context.Context, delivery *Delivery) error {
+	attempt := messageAttempt(delivery)
+	err := r.process(ctx, delivery.Value, attempt >= r.failures.policy.MaxAttempts)
+	if err == nil {
+		return nil
+	}
+	// processing interrupted by the shutdown is not an attempt, the message is released
+	if ctx.Err() != nil {
+		return fmt.Errorf("processing is interrupted; %w", err)
+	}
+	r.log.Error("Consumer handler failed;", "attempt", attempt, "err", err)
+	return r.failures.handle(delivery, attempt, IsPermanent(err), err)
+}
+
+// messageKey returns the key which keeps messages of one image in order
+func messageKey(delivery *Delivery) string {
+	if len(delivery.Key) > 0 {
+		return string(delivery.Key)
+	}
+	var message models.KafkaMessage
+	_ = json.Unmarshal(delivery.Value, &message)
+	return strconv.Itoa(message.Id)
+}
+
+// sleep waits for the delay and reports false when ctx is done
+func sleep(ctx context.Context, delay time.Duration) bool {
+	if delay <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,170 @@
+// Package signing creates and verifies HMAC-signed URLs of images: a URL grants access
+// to one variant of one image until it expires, optionally only from one client IP.
+// Keys are identified by id, so a key is rotated without breaking URLs signed before.
+package signing
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// query parameters of a signed URL
+const (
+	ExpiresParameter   = "expires"
+	ClientIPParameter  = "ip"
+	KeyIDParameter     = "kid"
+	SignatureParameter = "signature"
+)
+
+// minSecretLength is the shortest accepted secret in bytes
+const minSecretLength = 16
+
+var (
+	ErrNotSigned        = errors.New("url is not signed")
+	ErrExpired          = errors.New("url is expired")
+	ErrUnknownKey       = errors.New("signing key is unknown")
+	ErrInvalidSignature = errors.New("signature is invalid")
+	ErrClientIP         = errors.New("url is signed for another client")
+)
+
+// Config lists signing keys by id; URLs are signed with the active key
+// and verified with the key named in the URL
+type Config struct {
+	Keys       map[string]string
+	ActiveKey  string
+	DefaultTTL time.Duration
+	MaxTTL     time.Duration
+}
+
+// Claims are what a signed URL grants
+type Claims struct {
+	ImageID   int
+	Variant   string // path after /image/{id} with the query, e.g. thumbnail/128 or ?w=300; empty for the original
+	ExpiresAt time.Time
+	ClientIP  string // empty - any client
+}
+
+type Signer struct {
+	keys      map[string][]byte
+	activeKey string
+	cfg       Config
+	now       func() time.Time
+}
+
+func NewSigner(cfg Config) (*Signer, error) {
+	const op = "signing.NewSigner"
+
+	if _, ok := cfg.Keys[cfg.ActiveKey]; !ok {
+		return nil, fmt.Errorf("%s, active key %q is not among the keys", op, cfg.ActiveKey)
+	}
+	keys := make(map[string][]byte, len(cfg.Keys))
+	for id, secret := range cfg.Keys {
+		if len(secret) < minSecretLength {
+			return nil, fmt.Errorf("%s, secret of key %q is shorter than %d bytes", op, id, minSecretLength)
+		}
+		keys[id] = []byte(secret)
+	}
+	if cfg.DefaultTTL <= 0 || cfg.MaxTTL < cfg.DefaultTTL {
+		return nil, fmt.Errorf("%s, default ttl must be positive and not longer than max ttl", op)
+	}
+	return &Signer{keys: keys, activeKey: cfg.ActiveKey, cfg: cfg, now: time.Now}, nil
+}
+
+// ExpiresAt returns the expiry of a URL living for ttl; zero ttl selects the default one
+func (s *Signer) ExpiresAt(ttl time.Duration) (time.Time, error) {
+	if ttl == 0 {
+		ttl = s.cfg.DefaultTTL
+	}
+	if ttl < 0 || ttl > s.cfg.MaxTTL {
+		return time.Time{}, fmt.Errorf("ttl must be between 0 and %s", s.cfg.MaxTTL)
+	}
+	return s.now().Add(ttl).Truncate(time.Second), nil
+}
+
+// Sign returns the URL of the variant with the query granting the claims
+func (s *Signer) Sign(claims Claims) string {
+	path, rawQuery, _ := strings.Cut(claims.Variant, "?")
+	query, _ := url.ParseQuery(rawQuery)
+	query.Set(ExpiresParameter, strconv.FormatInt(claims.ExpiresAt.Unix(), 10))
+	if claims.ClientIP != "" {
+		query.Set(ClientIPParameter, claims.ClientIP)
+	}
+	query.Set(KeyIDParameter, s.activeKey)
+	query.Set(SignatureParameter, s.signature(s.keys[s.activeKey], claims))
+
+	u := "/image/" + strconv.Itoa(claims.ImageID)
+	if path != "" {
+		u += "/" + path
+	}
+	return u + "?" + query.Encode()
+}
+
+// Verify checks the signature of the request of the image variant;
+// path is the part of the URL path after /image/{id}
+func (s *Signer) Verify(imageID int, path string, query url.Values, clientIP string) error {
+	signature := query.Get(SignatureParameter)
+	if signature == "" {
+		return ErrNotSigned
+	}
+	secret, ok := s.keys[query.Get(KeyIDParameter)]
+	if !ok {
+		return ErrUnknownKey
+	}
+	expires, err := strconv.ParseInt(query.Get(ExpiresParameter), 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	claims := Claims{
+		ImageID:   imageID,
+		Variant:   Variant(path, query),
+		ExpiresAt: time.Unix(expires, 0),
+		ClientIP:  query.Get(ClientIPParameter),
+	}
+	if !hmac.Equal([]byte(signature), []byte(s.signature(secret, claims))) {
+		return ErrInvalidSignature
+	}
+	// the signature is checked first, so the claims below can be trusted
+	if !s.now().Before(claims.ExpiresAt) {
+		return ErrExpired
+	}
+	if claims.ClientIP != "" && claims.ClientIP != clientIP {
+		return ErrClientIP
+	}
+	return nil
+}
+
+// Variant normalizes the path after /image/{id} and the query without the signing parameters,
+// so a URL grants exactly the variant it was signed for
+func Variant(path string, query url.Values) string {
+	variant := strings.Trim(path, "/")
+	rest := url.Values{}
+	for name, values := range query {
+		switch name {
+		case ExpiresParameter, ClientIPParameter, KeyIDParameter, SignatureParameter:
+		default:
+			rest[name] = values
+		}
+	}
+	if len(rest) > 0 {
+		variant += "?" + rest.Encode()
+	}
+	return variant
+}
+
+// signature is the base64url HMAC-SHA256 of the claims
+func (s *Signer) signature(secret []byte, claims Claims) string {
+	path, rawQuery, _ := strings.Cut(claims.Variant, "?")
+	query, _ := url.ParseQuery(rawQuery)
+
+	mac := hmac.New(sha256.New, secret)
+	_, _ = fmt.Fprintf(mac, "%d\n%s\n%d\n%s", claims.ImageID, Variant(path, query), claims.ExpiresAt.Unix(), claims.ClientIP)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
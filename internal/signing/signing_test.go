@@ -0,0 +1,81 @@
+package signing
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func verifyURL(t *testing.T, signer *Signer, signed, clientIP string) error {
+	t.Helper()
+	u, err := url.Parse(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := strings.TrimPrefix(u.Path, "/image/1")
+	return signer.Verify(1, path, u.Query(), clientIP)
+}
+
+func TestSignerVerify(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	cfg := Config{
+		Keys:       map[string]string{"old": "0123456789abcdef-old", "new": "0123456789abcdef-new"},
+		ActiveKey:  "old",
+		DefaultTTL: time.Minute,
+		MaxTTL:     time.Hour,
+	}
+	oldSigner, err := NewSigner(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldSigner.now = func() time.Time { return now }
+
+	expiresAt, err := oldSigner.ExpiresAt(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := oldSigner.Sign(Claims{ImageID: 1, Variant: "thumbnail/128?w=300&fit=cover", ExpiresAt: expiresAt, ClientIP: "10.0.0.1"})
+	if !strings.HasPrefix(signed, "/image/1/thumbnail/128?") {
+		t.Fatalf("unexpected url %q", signed)
+	}
+
+	// the key is rotated: URLs signed with the previous key are still accepted
+	cfg.ActiveKey = "new"
+	signer, err := NewSigner(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer.now = oldSigner.now
+
+	if err = verifyURL(t, signer, signed, "10.0.0.1"); err != nil {
+		t.Errorf("expected a valid url, got %v", err)
+	}
+	tests := []struct {
+		name     string
+		url      string
+		clientIP string
+		want     error
+	}{
+		{"another variant", strings.Replace(signed, "w=300", "w=3000", 1), "10.0.0.1", ErrInvalidSignature},
+		{"another image", strings.Replace(signed, "/image/1/", "/image/1/../2/", 1), "10.0.0.1", ErrInvalidSignature},
+		{"another client", signed, "10.0.0.2", ErrClientIP},
+		{"not signed", "/image/1/thumbnail/128?w=300&fit=cover", "10.0.0.1", ErrNotSigned},
+		{"unknown key", strings.Replace(signed, "kid=old", "kid=lost", 1), "10.0.0.1", ErrUnknownKey},
+	}
+	for _, tt := range tests {
+		if err = verifyURL(t, signer, tt.url, tt.clientIP); !errors.Is(err, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, err)
+		}
+	}
+
+	signer.now = func() time.Time { return now.Add(time.Minute) }
+	if err = verifyURL(t, signer, signed, "10.0.0.1"); !errors.Is(err, ErrExpired) {
+		t.Errorf("expected an expired url, got %v", err)
+	}
+
+	if _, err = signer.ExpiresAt(2 * time.Hour); err == nil {
+		t.Error("ttl longer than the maximum must be rejected")
+	}
+}
@@ -0,0 +1,34 @@
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// acquireBlob adds a reference to the blob, registering it on the first upload;
+// the returned path is the copy shared by all images with the checksum,
+// it differs from path when the content is already stored
+func acquireBlob(tx *sql.Tx, checksum, path string, size int) (storedPath string, err error) {
+	err = tx.QueryRow(`
+	INSERT INTO blobs(checksum, path, size, ref_count)
+	VALUES ($1,$2,$3,1)
+	ON CONFLICT(checksum) DO UPDATE SET ref_count = ref_count + 1
+	RETURNING path;
+	`, checksum, path, size).Scan(&storedPath)
+	return storedPath, err
+}
+
+// releaseBlob removes a reference to the blob; the blob row is deleted with the last
+// reference and its path is returned, so the file is removed only when the transaction
+// has released it. A later identical upload registers its own copy of the content.
+func releaseBlob(tx *sql.Tx, checksum string) (orphanPath string, err error) {
+	_, err = tx.Exec(`UPDATE blobs SET ref_count = ref_count - 1 WHERE checksum = $1;`, checksum)
+	if err != nil {
+		return "", err
+	}
+	err = tx.QueryRow(`DELETE FROM blobs WHERE checksum = $1 AND ref_count <= 0 RETURNING path;`, checksum).Scan(&orphanPath)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return orphanPath, err
+}
@@ -0,0 +1,102 @@
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"imageProcessor/internal/models"
+	"imageProcessor/internal/storage"
+)
+
+// SetFont registers an uploaded font; a tenant can upload a family and weight pair only once
+func (s *StorageSqlite) SetFont(font *models.Font) (id int, err error) {
+	const op = "sqlite.SetFont"
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("%s,%w", op, err)
+	}
+	defer tx.Rollback()
+
+	var exists int
+	err = tx.QueryRow(`SELECT COUNT(*) FROM fonts WHERE tenant_id IS $1 AND family = $2 AND weight = $3;`,
+		nullTenant(font.TenantId), font.Family, font.Weight).Scan(&exists)
+	if err != nil {
+		return 0, fmt.Errorf("%s,%w", op, err)
+	}
+	if exists > 0 {
+		return 0, fmt.Errorf("%s,%w", op, storage.ErrFontExists)
+	}
+
+	err = tx.QueryRow(`
+	INSERT INTO fonts(tenant_id, family, weight, original_filename, path, file_size)
+	VALUES ($1,$2,$3,$4,$5,$6)
+	RETURNING id;
+	`, nullTenant(font.TenantId), font.Family, font.Weight, font.OriginalFilename, font.Path, font.FileSize).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("%s,%w", op, err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("%s,%w", op, err)
+	}
+	return id, nil
+}
+
+// GetFont returns the font of the family and weight uploaded by the tenant
+func (s *StorageSqlite) GetFont(tenantID int, family, weight string) (*models.Font, error) {
+	const op = "sqlite.GetFont"
+
+	row := s.db.QueryRow(`
+	SELECT id, tenant_id, family, weight, original_filename, path, file_size, created_at FROM fonts
+	WHERE tenant_id IS $1 AND family = $2 AND weight = $3;
+	`, nullTenant(tenantID), family, weight)
+
+	font, err := scanFont(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%s,%w", op, storage.ErrFontNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s,%w", op, err)
+	}
+	return font, nil
+}
+
+// ListFonts returns fonts of the tenant, of every tenant when allTenants is set
+func (s *StorageSqlite) ListFonts(tenantID int, allTenants bool) ([]models.Font, error) {
+	const op = "sqlite.ListFonts"
+
+	rows, err := s.db.Query(`
+	SELECT id, tenant_id, family, weight, original_filename, path, file_size, created_at FROM fonts
+	WHERE $1 OR tenant_id IS $2
+	ORDER BY family, weight, id;
+	`, allTenants, nullTenant(tenantID))
+	if err != nil {
+		return nil, fmt.Errorf("%s,%w", op, err)
+	}
+	defer rows.Close()
+
+	var fonts []models.Font
+	for rows.Next() {
+		font, err := scanFont(rows)
+		if err != nil {
+			return nil, fmt.Errorf("%s,%w", op, err)
+		}
+		fonts = append(fonts, *font)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s,%w", op, err)
+	}
+	return fonts, nil
+}
+
+func scanFont(row rowScanner) (*models.Font, error) {
+	var font models.Font
+	var tenantID sql.NullInt64
+	err := row.Scan(&font.Id, &tenantID, &font.Family, &font.Weight, &font.OriginalFilename, &font.Path, &font.FileSize, &font.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	font.TenantId = int(tenantID.Int64)
+	return &font, nil
+}
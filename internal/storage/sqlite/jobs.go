@@ -0,0 +1,124 @@
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"imageProcessor/internal/models"
+	"imageProcessor/internal/storage"
+	"time"
+)
+
+func insertJob(tx *sql.Tx, job *models.Job) (id int, err error) {
+	err = tx.QueryRow(`
+	INSERT INTO jobs(image_id, action, pipeline_id, tenant_id)
+	VALUES ($1,$2,$3,(SELECT tenant_id FROM images WHERE id = $1))
+	RETURNING id;
+	`, job.ImageId, job.Action, sql.NullInt64{Int64: int64(job.PipelineId), Valid: job.PipelineId != 0}).Scan(&id)
+	return id, err
+}
+
+func (s *StorageSqlite) GetJob(id int) (*models.Job, error) {
+	const op = "sqlite.GetJob"
+
+	var job models.Job
+	var pipelineID, tenantID sql.NullInt64
+	var jobError sql.NullString
+	var startedAt, finishedAt sql.NullTime
+	err := s.db.QueryRow(`
+	SELECT id, image_id, tenant_id, action, pipeline_id, status, attempts, error, queued_at, started_at, finished_at FROM jobs
+	WHERE id = $1;
+	`, id).Scan(&job.Id, &job.ImageId, &tenantID, &job.Action, &pipelineID, &job.Status, &job.Attempts, &jobError,
+		&job.QueuedAt, &startedAt, &finishedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%s,%w", op, storage.ErrJobNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s,%w", op, err)
+	}
+
+	job.PipelineId = int(pipelineID.Int64)
+	job.TenantId = int(tenantID.Int64)
+	job.Error = jobError.String
+	if startedAt.Valid {
+		job.StartedAt = &startedAt.Time
+	}
+	if finishedAt.Valid {
+		job.FinishedAt = &finishedAt.Time
+	}
+	return &job, nil
+}
+
+// StartJob moves the job to processing and counts the attempt;
+// the result of a previous attempt is cleared
+func (s *StorageSqlite) StartJob(id int) error {
+	const op = "sqlite.StartJob"
+
+	res, err := s.db.Exec(`UPDATE jobs
+		SET status = 'processing', attempts = attempts + 1, error = NULL, started_at = $1, finished_at = NULL
+		WHERE id = $2`, time.Now().UTC(), id)
+	if err != nil {
+		return fmt.Errorf("%s,%w", op, err)
+	}
+	if rows, err := res.RowsAffected(); err == nil && rows == 0 {
+		return fmt.Errorf("%s,%w", op, storage.ErrJobNotFound)
+	}
+	return nil
+}
+
+// FinishJob records the final status of the job and the reason of a failure
+func (s *StorageSqlite) FinishJob(id int, status, reason string) error {
+	const op = "sqlite.FinishJob"
+
+	_, err := s.db.Exec(`UPDATE jobs
+		SET status = $1, error = $2, finished_at = $3
+		WHERE id = $4`, status, sql.NullString{String: reason, Valid: reason != ""}, time.Now().UTC(), id)
+	if err != nil {
+		return fmt.Errorf("%s,%w", op, err)
+	}
+	return nil
+}
+
+// RetryJob moves the failed job back to pending until the next attempt;
+// the reason of the failure is kept for clients
+func (s *StorageSqlite) RetryJob(id int, reason string) error {
+	const op = "sqlite.RetryJob"
+
+	_, err := s.db.Exec(`UPDATE jobs
+		SET status = 'pending', error = $1, finished_at = NULL
+		WHERE id = $2`, reason, id)
+	if err != nil {
+		return fmt.Errorf("%s,%w", op, err)
+	}
+	return nil
+}
+
+// GetJobDerivatives returns results produced by the job
+func (s *StorageSqlite) GetJobDerivatives(jobID int) ([]models.Derivative, error) {
+	const op = "sqlite.GetJobDerivatives"
+
+	rows, err := s.db.Query(`
+	SELECT id, image_id, action, variant, parameters, path, mime_type, file_size, checksum FROM derivatives
+	WHERE job_id = $1
+	ORDER BY id;
+	`, jobID)
+	if err != nil {
+		return nil, fmt.Errorf("%s,%w", op, err)
+	}
+	defer rows.Close()
+
+	var derivatives []models.Derivative
+	for rows.Next() {
+		derivative := models.Derivative{JobId: jobID}
+		err = rows.Scan(&derivative.Id, &derivative.ImageId, &derivative.Action, &derivative.Variant, &derivative.Parameters,
+			&derivative.Path, &derivative.MimeType, &derivative.FileSize, &derivative.Checksum)
+		if err != nil {
+			return nil, fmt.Errorf("%s,%w", op, err)
+		}
+		derivatives = append(derivatives, derivative)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s,%w", op, err)
+	}
+	return derivatives, nil
+}
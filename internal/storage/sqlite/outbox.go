@@ -0,0 +1,228 @@
+package sqlite
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"imageProcessor/internal/models"
+	"imageProcessor/internal/storage"
+	"slices"
+	"time"
+)
+
+// SetUpload stores the uploaded image with its pipeline, the job and the message for the worker
+// in one transaction, so an accepted upload is always published by the outbox relay.
+// Ids of the image, the pipeline and the job are set in the message.
+func (s *StorageSqlite) SetUpload(metadata *models.ImageMetadata, pipeline []models.Operation, message *models.KafkaMessage) (id int, err error) {
+	const op = "sqlite.SetUpload"
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("%s,%w", op, err)
+	}
+	defer tx.Rollback()
+
+	if id, err = insertImage(tx, metadata); err != nil {
+		return 0, fmt.Errorf("%s,%w", op, err)
+	}
+	message.Id = id
+	if err = enqueueJob(tx, pipeline, message); err != nil {
+		return 0, fmt.Errorf("%s,%w", op, err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("%s,%w", op, err)
+	}
+	return id, nil
+}
+
+// RequestAction queues another action over the stored original of the image;
+// ids of the pipeline and the job are set in the message
+func (s *StorageSqlite) RequestAction(pipeline []models.Operation, message *models.KafkaMessage) error {
+	const op = "sqlite.RequestAction"
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("%s,%w", op, err)
+	}
+	defer tx.Rollback()
+
+	if err = checkImageActive(tx, message.Id); err != nil {
+		return fmt.Errorf("%s,%w", op, err)
+	}
+
+	if err = enqueueJob(tx, pipeline, message); err != nil {
+		return fmt.Errorf("%s,%w", op, err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("%s,%w", op, err)
+	}
+	return nil
+}
+
+// checkImageActive reports whether the image exists and is not deleted
+func checkImageActive(tx *sql.Tx, id int) error {
+	var status sql.NullString
+	err := tx.QueryRow(`SELECT status FROM images WHERE id = $1`, id).Scan(&status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return storage.ErrImageNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if status.String == deletedStatus {
+		return storage.ErrImageDeleted
+	}
+	return nil
+}
+
+// enqueueJob creates the pipeline and the job of the message and writes the message to the outbox
+func enqueueJob(tx *sql.Tx, pipeline []models.Operation, message *models.KafkaMessage) (err error) {
+	if pipeline != nil {
+		if message.PipelineId, err = insertPipeline(tx, message.Id, pipeline); err != nil {
+			return err
+		}
+	}
+
+	// the job tracks the request until the worker finishes it
+	message.JobId, err = insertJob(tx, &models.Job{ImageId: message.Id, Action: message.Action, PipelineId: message.PipelineId})
+	if err != nil {
+		return err
+	}
+	return insertOutbox(tx, message)
+}
+
+// RequestDelete marks the image as deleted and queues the delete command for the worker
+// in one transaction; the returned job tracks removal of the files and the row
+func (s *StorageSqlite) RequestDelete(id int) (jobID int, err error) {
+	const op = "sqlite.RequestDelete"
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("%s,%w", op, err)
+	}
+	defer tx.Rollback()
+
+	if err = checkImageActive(tx, id); err != nil {
+		return 0, fmt.Errorf("%s,%w", op, err)
+	}
+
+	if _, err = tx.Exec(`UPDATE images SET status = $1 WHERE id = $2`, deletedStatus, id); err != nil {
+		return 0, fmt.Errorf("%s,%w", op, err)
+	}
+	if jobID, err = insertJob(tx, &models.Job{ImageId: id, Action: models.DeleteAction}); err != nil {
+		return 0, fmt.Errorf("%s,%w", op, err)
+	}
+	if err = insertOutbox(tx, &models.KafkaMessage{Id: id, Action: models.DeleteAction, JobId: jobID}); err != nil {
+		return 0, fmt.Errorf("%s,%w", op, err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("%s,%w", op, err)
+	}
+	return jobID, nil
+}
+
+func insertOutbox(tx *sql.Tx, message *models.KafkaMessage) error {
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+	INSERT INTO outbox(message_key, payload, available_at)
+	VALUES ($1,$2,$3);
+	`, message.MessageKey(), payload, time.Now().UnixMilli())
+	return err
+}
+
+// ClaimOutbox takes up to limit messages which are not sent yet for the lease;
+// a message is skipped while an earlier message with its key is not sent,
+// so messages of one image are published in order
+func (s *StorageSqlite) ClaimOutbox(limit int, lease time.Duration) ([]models.OutboxMessage, error) {
+	const op = "sqlite.ClaimOutbox"
+
+	now := time.Now().UnixMilli()
+	rows, err := s.db.Query(`
+	UPDATE outbox SET available_at = $1
+	WHERE id IN (
+		SELECT id FROM outbox AS o
+		WHERE sent_at IS NULL
+			AND available_at <= $2
+			AND NOT EXISTS (
+				SELECT 1 FROM outbox AS earlier
+				WHERE earlier.sent_at IS NULL AND earlier.message_key = o.message_key AND earlier.id < o.id)
+		ORDER BY id
+		LIMIT $3)
+	RETURNING id, message_key, payload, attempts;
+	`, now+lease.Milliseconds(), now, limit)
+	if err != nil {
+		return nil, fmt.Errorf("%s,%w", op, err)
+	}
+	defer rows.Close()
+
+	var messages []models.OutboxMessage
+	for rows.Next() {
+		var message models.OutboxMessage
+		var key sql.NullString
+		if err = rows.Scan(&message.Id, &key, &message.Payload, &message.Attempts); err != nil {
+			return nil, fmt.Errorf("%s,%w", op, err)
+		}
+		if key.Valid {
+			message.Key = []byte(key.String)
+		}
+		messages = append(messages, message)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s,%w", op, err)
+	}
+
+	// RETURNING does not keep the order of the subquery
+	slices.SortFunc(messages, func(a, b models.OutboxMessage) int { return a.Id - b.Id })
+	return messages, nil
+}
+
+// MarkOutboxSent records that the message is published
+func (s *StorageSqlite) MarkOutboxSent(id int) error {
+	const op = "sqlite.MarkOutboxSent"
+
+	if _, err := s.db.Exec(`UPDATE outbox SET sent_at = $1, error = NULL WHERE id = $2`, time.Now().UTC(), id); err != nil {
+		return fmt.Errorf("%s,%w", op, err)
+	}
+	return nil
+}
+
+// RetryOutbox counts a failed publishing attempt of the message,
+// it is not taken again before availableAt
+func (s *StorageSqlite) RetryOutbox(id int, reason string, availableAt time.Time) error {
+	const op = "sqlite.RetryOutbox"
+
+	_, err := s.db.Exec(`UPDATE outbox SET attempts = attempts + 1, error = $1, available_at = $2 WHERE id = $3`,
+		reason, availableAt.UnixMilli(), id)
+	if err != nil {
+		return fmt.Errorf("%s,%w", op, err)
+	}
+	return nil
+}
+
+// PruneOutbox deletes up to limit messages sent before sentBefore and returns their number
+func (s *StorageSqlite) PruneOutbox(sentBefore time.Time, limit int) (int, error) {
+	const op = "sqlite.PruneOutbox"
+
+	res, err := s.db.Exec(`
+	DELETE FROM outbox
+	WHERE id IN (
+		SELECT id FROM outbox
+		WHERE sent_at IS NOT NULL AND sent_at < $1
+		LIMIT $2);
+	`, sentBefore.UTC(), limit)
+	if err != nil {
+		return 0, fmt.Errorf("%s,%w", op, err)
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("%s,%w", op, err)
+	}
+	return int(deleted), nil
+}
@@ -0,0 +1,292 @@
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"imageProcessor/internal/models"
+	"imageProcessor/internal/storage"
+)
+
+// CreatePreset stores a new preset of the tenant together with its first version
+func (s *StorageSqlite) CreatePreset(preset *models.Preset) (id int, err error) {
+	const op = "sqlite.CreatePreset"
+
+	parameters, err := encodeParameters(preset.Parameters)
+	if err != nil {
+		return 0, fmt.Errorf("%s,%w", op, err)
+	}
+	pipeline, err := encodePipeline(preset.Pipeline)
+	if err != nil {
+		return 0, fmt.Errorf("%s,%w", op, err)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("%s,%w", op, err)
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	err = tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM presets WHERE tenant_id IS $1 AND name = $2)`,
+		nullTenant(preset.TenantId), preset.Name).Scan(&exists)
+	if err != nil {
+		return 0, fmt.Errorf("%s,%w", op, err)
+	}
+	if exists {
+		return 0, fmt.Errorf("%s,%w", op, storage.ErrPresetExists)
+	}
+
+	err = tx.QueryRow(`
+	INSERT INTO presets(tenant_id, name, version, action, parameters, pipeline)
+	VALUES ($1,$2,1,$3,$4,$5)
+	RETURNING id;
+	`, nullTenant(preset.TenantId), preset.Name, preset.Action, parameters, pipeline).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("%s,%w", op, err)
+	}
+
+	_, err = tx.Exec(`
+	INSERT INTO preset_versions(preset_id, version, action, parameters, pipeline)
+	VALUES ($1,1,$2,$3,$4);
+	`, id, preset.Action, parameters, pipeline)
+	if err != nil {
+		return 0, fmt.Errorf("%s,%w", op, err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("%s,%w", op, err)
+	}
+	return id, nil
+}
+
+// UpdatePreset replaces the definition of the preset of the tenant and returns its new version;
+// previous versions are kept in preset_versions
+func (s *StorageSqlite) UpdatePreset(preset *models.Preset) (version int, err error) {
+	const op = "sqlite.UpdatePreset"
+
+	parameters, err := encodeParameters(preset.Parameters)
+	if err != nil {
+		return 0, fmt.Errorf("%s,%w", op, err)
+	}
+	pipeline, err := encodePipeline(preset.Pipeline)
+	if err != nil {
+		return 0, fmt.Errorf("%s,%w", op, err)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("%s,%w", op, err)
+	}
+	defer tx.Rollback()
+
+	var id int
+	err = tx.QueryRow(`
+	UPDATE presets
+	SET version = version + 1, action = $1, parameters = $2, pipeline = $3, updated_at = CURRENT_TIMESTAMP
+	WHERE tenant_id IS $4 AND name = $5
+	RETURNING id, version;
+	`, preset.Action, parameters, pipeline, nullTenant(preset.TenantId), preset.Name).Scan(&id, &version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("%s,%w", op, storage.ErrPresetNotFound)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("%s,%w", op, err)
+	}
+
+	_, err = tx.Exec(`
+	INSERT INTO preset_versions(preset_id, version, action, parameters, pipeline)
+	VALUES ($1,$2,$3,$4,$5);
+	`, id, version, preset.Action, parameters, pipeline)
+	if err != nil {
+		return 0, fmt.Errorf("%s,%w", op, err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("%s,%w", op, err)
+	}
+	return version, nil
+}
+
+// GetPreset returns the current version of the preset of the tenant
+func (s *StorageSqlite) GetPreset(tenantID int, name string) (*models.Preset, error) {
+	const op = "sqlite.GetPreset"
+
+	row := s.db.QueryRow(`
+	SELECT id, tenant_id, name, version, action, parameters, pipeline, created_at, updated_at FROM presets
+	WHERE tenant_id IS $1 AND name = $2;
+	`, nullTenant(tenantID), name)
+
+	preset, err := scanPreset(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%s,%w", op, storage.ErrPresetNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s,%w", op, err)
+	}
+	return preset, nil
+}
+
+// ResolveJobPreset returns the version of the preset the job is processed with;
+// the preset is looked up among presets of the owner of the image.
+// The first attempt takes the current version, creates the pipeline of a pipeline preset
+// and records both in the job, so later attempts reuse them instead of resolving again.
+// The used version is recorded in the image metadata, the action of the image is kept.
+func (s *StorageSqlite) ResolveJobPreset(jobID, imageID int, name string) (preset *models.Preset, pipelineID int, err error) {
+	const op = "sqlite.ResolveJobPreset"
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, 0, fmt.Errorf("%s,%w", op, err)
+	}
+	defer tx.Rollback()
+
+	var version, jobPipelineID sql.NullInt64
+	if jobID != 0 {
+		err = tx.QueryRow(`SELECT preset_version, pipeline_id FROM jobs WHERE id = $1`, jobID).Scan(&version, &jobPipelineID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, 0, fmt.Errorf("%s,%w", op, storage.ErrJobNotFound)
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("%s,%w", op, err)
+		}
+	}
+
+	// a retried job is processed with the version taken by its first attempt
+	if version.Valid {
+		preset, err = scanPreset(tx.QueryRow(`
+		SELECT p.id, p.tenant_id, p.name, v.version, v.action, v.parameters, v.pipeline, v.created_at, v.created_at
+		FROM presets AS p JOIN preset_versions AS v ON v.preset_id = p.id
+		WHERE p.tenant_id IS (SELECT tenant_id FROM images WHERE id = $1) AND p.name = $2 AND v.version = $3;
+		`, imageID, name, version.Int64))
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, 0, fmt.Errorf("%s,%w", op, storage.ErrPresetNotFound)
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("%s,%w", op, err)
+		}
+		return preset, int(jobPipelineID.Int64), nil
+	}
+
+	preset, err = scanPreset(tx.QueryRow(`
+	SELECT id, tenant_id, name, version, action, parameters, pipeline, created_at, updated_at FROM presets
+	WHERE tenant_id IS (SELECT tenant_id FROM images WHERE id = $1) AND name = $2;
+	`, imageID, name))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, 0, fmt.Errorf("%s,%w", op, storage.ErrPresetNotFound)
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("%s,%w", op, err)
+	}
+
+	if preset.Action == models.PipelineAction {
+		if pipelineID, err = insertPipeline(tx, imageID, preset.Pipeline); err != nil {
+			return nil, 0, fmt.Errorf("%s,%w", op, err)
+		}
+	}
+	if jobID != 0 {
+		_, err = tx.Exec(`UPDATE jobs SET preset_version = $1, pipeline_id = $2 WHERE id = $3`,
+			preset.Version, sql.NullInt64{Int64: int64(pipelineID), Valid: pipelineID != 0}, jobID)
+		if err != nil {
+			return nil, 0, fmt.Errorf("%s,%w", op, err)
+		}
+	}
+	_, err = tx.Exec(`UPDATE images SET preset = $1, preset_version = $2 WHERE id = $3`, preset.Name, preset.Version, imageID)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%s,%w", op, err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, 0, fmt.Errorf("%s,%w", op, err)
+	}
+	return preset, pipelineID, nil
+}
+
+// ListPresets returns presets of the tenant, of every tenant when allTenants is set
+func (s *StorageSqlite) ListPresets(tenantID int, allTenants bool) ([]models.Preset, error) {
+	const op = "sqlite.ListPresets"
+
+	rows, err := s.db.Query(`
+	SELECT id, tenant_id, name, version, action, parameters, pipeline, created_at, updated_at FROM presets
+	WHERE $1 OR tenant_id IS $2
+	ORDER BY name, id;
+	`, allTenants, nullTenant(tenantID))
+	if err != nil {
+		return nil, fmt.Errorf("%s,%w", op, err)
+	}
+	defer rows.Close()
+
+	var presets []models.Preset
+	for rows.Next() {
+		preset, err := scanPreset(rows)
+		if err != nil {
+			return nil, fmt.Errorf("%s,%w", op, err)
+		}
+		presets = append(presets, *preset)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s,%w", op, err)
+	}
+	return presets, nil
+}
+
+// DeletePreset removes the preset of the tenant with all its versions
+func (s *StorageSqlite) DeletePreset(tenantID int, name string) error {
+	const op = "sqlite.DeletePreset"
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("%s,%w", op, err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+	DELETE FROM preset_versions WHERE preset_id = (SELECT id FROM presets WHERE tenant_id IS $1 AND name = $2)
+	`, nullTenant(tenantID), name)
+	if err != nil {
+		return fmt.Errorf("%s,%w", op, err)
+	}
+	res, err := tx.Exec(`DELETE FROM presets WHERE tenant_id IS $1 AND name = $2`, nullTenant(tenantID), name)
+	if err != nil {
+		return fmt.Errorf("%s,%w", op, err)
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s,%w", op, err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("%s,%w", op, storage.ErrPresetNotFound)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("%s,%w", op, err)
+	}
+	return nil
+}
+
+// rowScanner is implemented by both sql.Row and sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanPreset(row rowScanner) (*models.Preset, error) {
+	var preset models.Preset
+	var parameters, pipeline sql.NullString
+	var tenantID sql.NullInt64
+	err := row.Scan(&preset.Id, &tenantID, &preset.Name, &preset.Version, &preset.Action, &parameters, &pipeline,
+		&preset.CreatedAt, &preset.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	preset.TenantId = int(tenantID.Int64)
+
+	preset.Parameters, err = decodeParameters(parameters)
+	if err != nil {
+		return nil, err
+	}
+	preset.Pipeline, err = decodePipeline(pipeline)
+	if err != nil {
+		return nil, err
+	}
+	return &preset, nil
+}
@@ -0,0 +1,136 @@
+package sqlite
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"imageProcessor/internal/models"
+	"imageProcessor/internal/storage"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EnqueueMessage stores the message of the queue backend;
+// it is delivered not earlier than msg.AvailableAt
+func (s *StorageSqlite) EnqueueMessage(msg *models.QueueMessage) error {
+	const op = "sqlite.EnqueueMessage"
+
+	headers, err := json.Marshal(msg.Headers)
+	if err != nil {
+		return fmt.Errorf("%s,%w", op, err)
+	}
+	availableAt := msg.AvailableAt
+	if availableAt.IsZero() {
+		availableAt = time.Now()
+	}
+
+	_, err = s.db.Exec(`
+	INSERT INTO queue_messages(topic, message_key, value, headers, available_at)
+	VALUES ($1,$2,$3,$4,$5);
+	`, msg.Topic, sql.NullString{String: string(msg.Key), Valid: msg.Key != nil}, msg.Value, string(headers), availableAt.UnixMilli())
+	if err != nil {
+		return fmt.Errorf("%s,%w", op, err)
+	}
+	return nil
+}
+
+// ClaimQueueMessage takes the oldest available message of the topics for the lease;
+// a message is skipped while another message with its key is taken, so messages
+// of one key are processed in order even by several workers.
+// A message which is not acknowledged before the lease expires is delivered again.
+func (s *StorageSqlite) ClaimQueueMessage(topics []string, lease time.Duration) (*models.QueueMessage, error) {
+	const op = "sqlite.ClaimQueueMessage"
+
+	now := time.Now().UnixMilli()
+	args := []any{now + lease.Milliseconds(), now}
+	placeholders := make([]string, len(topics))
+	for i, topic := range topics {
+		args = append(args, topic)
+		placeholders[i] = "$" + strconv.Itoa(len(args))
+	}
+
+	var msg models.QueueMessage
+	var key sql.NullString
+	var headers sql.NullString
+	var availableAt int64
+	err := s.db.QueryRow(`
+	UPDATE queue_messages SET locked_until = $1
+	WHERE id = (
+		SELECT id FROM queue_messages
+		WHERE topic IN (`+strings.Join(placeholders, ",")+`)
+			AND available_at <= $2
+			AND (locked_until IS NULL OR locked_until <= $2)
+			AND (message_key IS NULL OR message_key NOT IN (
+				SELECT message_key FROM queue_messages WHERE locked_until > $2 AND message_key IS NOT NULL))
+		ORDER BY id
+		LIMIT 1)
+	RETURNING id, topic, message_key, value, headers, available_at;
+	`, args...).Scan(&msg.Id, &msg.Topic, &key, &msg.Value, &headers, &availableAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%s,%w", op, storage.ErrQueueEmpty)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s,%w", op, err)
+	}
+
+	if key.Valid {
+		msg.Key = []byte(key.String)
+	}
+	if headers.Valid && headers.String != "" {
+		if err = json.Unmarshal([]byte(headers.String), &msg.Headers); err != nil {
+			return nil, fmt.Errorf("%s,%w", op, err)
+		}
+	}
+	msg.AvailableAt = time.UnixMilli(availableAt)
+	return &msg, nil
+}
+
+// AckQueueMessage removes the processed message
+func (s *StorageSqlite) AckQueueMessage(id int) error {
+	const op = "sqlite.AckQueueMessage"
+
+	if _, err := s.db.Exec(`DELETE FROM queue_messages WHERE id = $1`, id); err != nil {
+		return fmt.Errorf("%s,%w", op, err)
+	}
+	return nil
+}
+
+// ReleaseQueueMessage returns the taken message, it is delivered again
+func (s *StorageSqlite) ReleaseQueueMessage(id int) error {
+	const op = "sqlite.ReleaseQueueMessage"
+
+	if _, err := s.db.Exec(`UPDATE queue_messages SET locked_until = NULL WHERE id = $1`, id); err != nil {
+		return fmt.Errorf("%s,%w", op, err)
+	}
+	return nil
+}
+
+// MoveQueueMessages moves up to limit oldest messages of the topic to another topic;
+// moved messages get the headers and are available at once
+func (s *StorageSqlite) MoveQueueMessages(from, to string, headers map[string]string, limit int) (int, error) {
+	const op = "sqlite.MoveQueueMessages"
+
+	encoded, err := json.Marshal(headers)
+	if err != nil {
+		return 0, fmt.Errorf("%s,%w", op, err)
+	}
+
+	res, err := s.db.Exec(`
+	UPDATE queue_messages SET topic = $1, headers = $2, available_at = $3, locked_until = NULL
+	WHERE id IN (
+		SELECT id FROM queue_messages
+		WHERE topic = $4
+		ORDER BY id
+		LIMIT $5);
+	`, to, string(encoded), time.Now().UnixMilli(), from, limit)
+	if err != nil {
+		return 0, fmt.Errorf("%s,%w", op, err)
+	}
+	moved, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("%s,%w", op, err)
+	}
+	return int(moved), nil
+}
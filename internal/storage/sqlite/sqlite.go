@@ -2,13 +2,19 @@ package sqlite
 
 import (
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"imageProcessor/internal/models"
+	"imageProcessor/internal/storage"
 	"sync"
 
 	_ "modernc.org/sqlite"
 )
 
+// deletedStatus marks an image waiting for the worker to remove it
+const deletedStatus = "deleted"
+
 type StorageSqlite struct {
 	db *sql.DB
 	mu sync.RWMutex
@@ -29,67 +35,160 @@ func New(storagePath string) (*StorageSqlite, error) {
 	return &StorageSqlite{db: db}, nil
 }
 
-// SetMetadata function is used to store any image
-// into local storage - /uploads;
-// And this function create image metadata in sqlite
-func (s *StorageSqlite) SetMetadata(metadata *models.ImageMetadata) (id int, err error) {
-	const op = "sqlite.UploadImage"
-
-	row := s.db.QueryRow(`
-	INSERT INTO images(original_filename, original_path, mime_type, file_size, status, action)
-	VALUES ($1,$2,$3,$4,$5,$6)
-	RETURNING id;
-	`, metadata.OriginalFilename, metadata.OriginalPath, metadata.MimeType, metadata.FileSize, metadata.Status, metadata.Action)
-
-	err = row.Scan(&id)
+// insertImage creates the image row and takes a reference to its blob;
+// OriginalPath of the metadata is replaced with the shared copy of the blob
+func insertImage(tx *sql.Tx, metadata *models.ImageMetadata) (id int, err error) {
+	parameters, err := encodeParameters(metadata.Parameters)
 	if err != nil {
-		return 0, fmt.Errorf("%s %w", op, err)
+		return 0, err
 	}
-	return
+
+	// identical uploads share one blob, every image holds a reference to it
+	if metadata.Checksum != "" {
+		if metadata.OriginalPath, err = acquireBlob(tx, metadata.Checksum, metadata.OriginalPath, metadata.FileSize); err != nil {
+			return 0, err
+		}
+	}
+
+	err = tx.QueryRow(`
+	INSERT INTO images(original_filename, original_path, checksum, mime_type, width, height, file_size, status, action, parameters, preset, tenant_id)
+	VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12)
+	RETURNING id;
+	`, metadata.OriginalFilename, metadata.OriginalPath, sql.NullString{String: metadata.Checksum, Valid: metadata.Checksum != ""},
+		metadata.MimeType, metadata.Width, metadata.Height, metadata.FileSize, metadata.Status, metadata.Action, parameters,
+		sql.NullString{String: metadata.Preset, Valid: metadata.Preset != ""},
+		nullTenant(metadata.TenantId)).Scan(&id)
+	return id, err
 }
 
 func (s *StorageSqlite) GetImageMetadata(id int) (*models.ImageMetadata, error) {
 	const op = "sqlite.GetImageMetadata"
 
 	var metadata models.ImageMetadata
+	var parameters, preset, checksum sql.NullString
+	var width, height, presetVersion, tenantID sql.NullInt64
 	row := s.db.QueryRow(`
-	SELECT original_path, original_path, mime_type, file_size, status, action FROM images
+	SELECT id, tenant_id, original_filename, original_path, checksum, mime_type, width, height, file_size, status, action, parameters, preset, preset_version
+	FROM images
 	WHERE id = $1;
 	`, id)
 
-	err := row.Scan(&metadata.OriginalFilename, &metadata.OriginalPath, &metadata.MimeType, &metadata.FileSize, &metadata.Status, &metadata.Action)
+	err := row.Scan(&metadata.Id, &tenantID, &metadata.OriginalFilename, &metadata.OriginalPath, &checksum, &metadata.MimeType, &width, &height,
+		&metadata.FileSize, &metadata.Status, &metadata.Action, &parameters, &preset, &presetVersion)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%s,%w", op, storage.ErrImageNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s,%w", op, err)
+	}
+
+	metadata.Parameters, err = decodeParameters(parameters)
 	if err != nil {
 		return nil, fmt.Errorf("%s,%w", op, err)
 	}
+	metadata.TenantId = int(tenantID.Int64)
+	metadata.Checksum = checksum.String
+	metadata.Width = int(width.Int64)
+	metadata.Height = int(height.Int64)
+	metadata.Preset = preset.String
+	metadata.PresetVersion = int(presetVersion.Int64)
 
 	return &metadata, nil
 }
 
-func (s *StorageSqlite) DeleteImage(id int) error {
+// ListImages returns up to limit images with ids greater than afterID ordered by id;
+// images of other tenants are skipped unless allTenants is set, deleted images are skipped
+func (s *StorageSqlite) ListImages(tenantID int, allTenants bool, afterID, limit int) ([]models.ImageMetadata, error) {
+	const op = "sqlite.ListImages"
+
+	rows, err := s.db.Query(`
+	SELECT id, tenant_id, original_filename, mime_type, width, height, file_size, status, action, created_at
+	FROM images
+	WHERE id > $1 AND status != $2 AND ($3 OR tenant_id IS $4)
+	ORDER BY id
+	LIMIT $5;
+	`, afterID, deletedStatus, allTenants, nullTenant(tenantID), limit)
+	if err != nil {
+		return nil, fmt.Errorf("%s,%w", op, err)
+	}
+	defer rows.Close()
+
+	images := make([]models.ImageMetadata, 0)
+	for rows.Next() {
+		var metadata models.ImageMetadata
+		var tenantID, width, height sql.NullInt64
+		var createdAt sql.NullTime
+		err = rows.Scan(&metadata.Id, &tenantID, &metadata.OriginalFilename, &metadata.MimeType, &width, &height,
+			&metadata.FileSize, &metadata.Status, &metadata.Action, &createdAt)
+		if err != nil {
+			return nil, fmt.Errorf("%s,%w", op, err)
+		}
+		metadata.TenantId = int(tenantID.Int64)
+		metadata.Width = int(width.Int64)
+		metadata.Height = int(height.Int64)
+		metadata.CreatedAt = createdAt.Time
+		images = append(images, metadata)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s,%w", op, err)
+	}
+	return images, nil
+}
+
+// DeleteImage removes the image metadata with its pipelines and its reference to the blob;
+// the returned path is the original file which is not referenced anymore and has to be
+// removed by the caller, it is empty while other images share the content.
+// Jobs of the image are kept, clients still can read their final status.
+func (s *StorageSqlite) DeleteImage(id int) (orphanPath string, err error) {
 	const op = "sqlite.DeleteImage"
 
-	res, err := s.db.Exec(`DELETE FROM images WHERE id = $1`, id)
+	tx, err := s.db.Begin()
 	if err != nil {
-		return fmt.Errorf("%s,%w", op, err)
+		return "", fmt.Errorf("%s,%w", op, err)
 	}
+	defer tx.Rollback()
 
-	rowsAffected, err := res.RowsAffected()
+	var checksum, originalPath sql.NullString
+	err = tx.QueryRow(`SELECT checksum, original_path FROM images WHERE id = $1`, id).Scan(&checksum, &originalPath)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("do not delete string by id=%d; %s,%w", id, op, storage.ErrImageNotFound)
+	}
 	if err != nil {
-		return fmt.Errorf("%s,%w", op, err)
+		return "", fmt.Errorf("%s,%w", op, err)
 	}
 
-	if rowsAffected == 0 {
-		return fmt.Errorf("do not delete string by id=%d; %s,%w", id, op, err)
+	if _, err = tx.Exec(`DELETE FROM pipeline_steps WHERE pipeline_id IN (SELECT id FROM pipelines WHERE image_id = $1)`, id); err != nil {
+		return "", fmt.Errorf("%s,%w", op, err)
 	}
-	return nil
+	if _, err = tx.Exec(`DELETE FROM pipelines WHERE image_id = $1`, id); err != nil {
+		return "", fmt.Errorf("%s,%w", op, err)
+	}
+	if _, err = tx.Exec(`DELETE FROM images WHERE id = $1`, id); err != nil {
+		return "", fmt.Errorf("%s,%w", op, err)
+	}
+
+	// originals uploaded before deduplication have no checksum and are not shared
+	orphanPath = originalPath.String
+	if checksum.Valid {
+		if orphanPath, err = releaseBlob(tx, checksum.String); err != nil {
+			return "", fmt.Errorf("%s,%w", op, err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return "", fmt.Errorf("%s,%w", op, err)
+	}
+	return orphanPath, nil
 }
 
+// UpdateStatus records the processing status set by the worker; an image marked as deleted
+// keeps its status for the delete command, then storage.ErrImageDeleted is returned
 func (s *StorageSqlite) UpdateStatus(id int, status string) error {
 	const op = "sqlite.UpdateStatus"
 
 	res, err := s.db.Exec(`UPDATE images 
 		SET status = $1
-		WHERE id = $2`, status, id)
+		WHERE id = $2 AND status != $3`, status, id, deletedStatus)
 	if err != nil {
 		return fmt.Errorf("%s,%w", op, err)
 	}
@@ -99,8 +198,351 @@ func (s *StorageSqlite) UpdateStatus(id int, status string) error {
 		return fmt.Errorf("%s,%w", op, err)
 	}
 
+	// the image is marked as deleted or already removed by the delete command
 	if rowsAffected == 0 {
-		return fmt.Errorf("do not updated string by id=%d; %s,%w", id, op, err)
+		return fmt.Errorf("do not updated string by id=%d; %s,%w", id, op, storage.ErrImageDeleted)
+	}
+	return nil
+}
+
+// SetDerivative stores metadata of a file produced by an action
+// over the original image; a derivative of an image marked as deleted is not stored,
+// so the delete command does not miss it, storage.ErrImageDeleted is returned instead
+func (s *StorageSqlite) SetDerivative(derivative *models.Derivative) (id int, err error) {
+	const op = "sqlite.SetDerivative"
+
+	row := s.db.QueryRow(`
+	INSERT INTO derivatives(image_id, job_id, action, variant, parameters, path, mime_type, file_size, checksum)
+	SELECT $1,$2,$3,$4,$5,$6,$7,$8,$9
+	WHERE EXISTS (SELECT 1 FROM images WHERE id = $1 AND status != $10)
+	RETURNING id;
+	`, derivative.ImageId, sql.NullInt64{Int64: int64(derivative.JobId), Valid: derivative.JobId != 0}, derivative.Action, derivative.Variant, derivative.Parameters, derivative.Path,
+		derivative.MimeType, derivative.FileSize, derivative.Checksum, deletedStatus)
+
+	err = row.Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("%s,%w", op, storage.ErrImageDeleted)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("%s %w", op, err)
+	}
+	return
+}
+
+func (s *StorageSqlite) GetDerivative(imageID, id int) (*models.Derivative, error) {
+	const op = "sqlite.GetDerivative"
+
+	var derivative models.Derivative
+	row := s.db.QueryRow(`
+	SELECT id, image_id, action, variant, parameters, path, mime_type, file_size, checksum FROM derivatives
+	WHERE id = $1 AND image_id = $2;
+	`, id, imageID)
+
+	err := row.Scan(&derivative.Id, &derivative.ImageId, &derivative.Action, &derivative.Variant, &derivative.Parameters,
+		&derivative.Path, &derivative.MimeType, &derivative.FileSize, &derivative.Checksum)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%s,%w", op, storage.ErrDerivativeNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s,%w", op, err)
+	}
+
+	return &derivative, nil
+}
+
+// GetDerivativeByVariant returns the latest derivative produced by the action with the variant
+func (s *StorageSqlite) GetDerivativeByVariant(imageID int, action, variant string) (*models.Derivative, error) {
+	const op = "sqlite.GetDerivativeByVariant"
+
+	var derivative models.Derivative
+	row := s.db.QueryRow(`
+	SELECT id, image_id, action, variant, parameters, path, mime_type, file_size, checksum FROM derivatives
+	WHERE image_id = $1 AND action = $2 AND variant = $3
+	ORDER BY id DESC
+	LIMIT 1;
+	`, imageID, action, variant)
+
+	err := row.Scan(&derivative.Id, &derivative.ImageId, &derivative.Action, &derivative.Variant, &derivative.Parameters,
+		&derivative.Path, &derivative.MimeType, &derivative.FileSize, &derivative.Checksum)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%s,%w", op, storage.ErrDerivativeNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s,%w", op, err)
+	}
+
+	return &derivative, nil
+}
+
+// GetDerivatives returns all derivatives of the image ordered by creation
+func (s *StorageSqlite) GetDerivatives(imageID int) ([]models.Derivative, error) {
+	const op = "sqlite.GetDerivatives"
+
+	rows, err := s.db.Query(`
+	SELECT id, image_id, action, variant, parameters, path, mime_type, file_size, checksum FROM derivatives
+	WHERE image_id = $1
+	ORDER BY id;
+	`, imageID)
+	if err != nil {
+		return nil, fmt.Errorf("%s,%w", op, err)
+	}
+	defer rows.Close()
+
+	var derivatives []models.Derivative
+	for rows.Next() {
+		var derivative models.Derivative
+		err = rows.Scan(&derivative.Id, &derivative.ImageId, &derivative.Action, &derivative.Variant, &derivative.Parameters,
+			&derivative.Path, &derivative.MimeType, &derivative.FileSize, &derivative.Checksum)
+		if err != nil {
+			return nil, fmt.Errorf("%s,%w", op, err)
+		}
+		derivatives = append(derivatives, derivative)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s,%w", op, err)
+	}
+
+	return derivatives, nil
+}
+
+func (s *StorageSqlite) DeleteDerivatives(imageID int) error {
+	const op = "sqlite.DeleteDerivatives"
+
+	_, err := s.db.Exec(`DELETE FROM derivatives WHERE image_id = $1`, imageID)
+	if err != nil {
+		return fmt.Errorf("%s,%w", op, err)
+	}
+	return nil
+}
+
+// encodeParameters prepares action parameters to be stored as JSON text
+func encodeParameters(parameters *models.ActionParameters) (sql.NullString, error) {
+	if parameters == nil {
+		return sql.NullString{}, nil
+	}
+	data, err := json.Marshal(parameters)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+	return sql.NullString{String: string(data), Valid: true}, nil
+}
+
+func decodeParameters(data sql.NullString) (*models.ActionParameters, error) {
+	if !data.Valid || data.String == "" {
+		return nil, nil
+	}
+	var parameters models.ActionParameters
+	if err := json.Unmarshal([]byte(data.String), &parameters); err != nil {
+		return nil, err
+	}
+	return &parameters, nil
+}
+
+// insertPipeline creates the pipeline with its steps in the transaction
+func insertPipeline(tx *sql.Tx, imageID int, steps []models.Operation) (id int, err error) {
+	err = tx.QueryRow(`
+	INSERT INTO pipelines(image_id, status)
+	VALUES ($1,$2)
+	RETURNING id;
+	`, imageID, "pending").Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, step := range steps {
+		parameters, err := encodeParameters(step.Parameters)
+		if err != nil {
+			return 0, err
+		}
+		_, err = tx.Exec(`
+		INSERT INTO pipeline_steps(pipeline_id, position, action, parameters)
+		VALUES ($1,$2,$3,$4);
+		`, id, i+1, step.Action, parameters)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return id, nil
+}
+
+// GetPipeline returns the pipeline with its steps ordered by position
+func (s *StorageSqlite) GetPipeline(id int) (*models.Pipeline, error) {
+	const op = "sqlite.GetPipeline"
+
+	pipeline := models.Pipeline{Id: id}
+	err := s.db.QueryRow(`
+	SELECT image_id, status FROM pipelines
+	WHERE id = $1;
+	`, id).Scan(&pipeline.ImageId, &pipeline.Status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%s,%w", op, storage.ErrPipelineNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s,%w", op, err)
+	}
+
+	rows, err := s.db.Query(`
+	SELECT position, action, parameters, status, error, started_at, finished_at, duration_ms FROM pipeline_steps
+	WHERE pipeline_id = $1
+	ORDER BY position;
+	`, id)
+	if err != nil {
+		return nil, fmt.Errorf("%s,%w", op, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var step models.PipelineStep
+		var parameters, stepError sql.NullString
+		var startedAt, finishedAt sql.NullTime
+		var duration sql.NullInt64
+		err = rows.Scan(&step.Position, &step.Operation.Action, &parameters, &step.Status, &stepError,
+			&startedAt, &finishedAt, &duration)
+		if err != nil {
+			return nil, fmt.Errorf("%s,%w", op, err)
+		}
+		step.Operation.Parameters, err = decodeParameters(parameters)
+		if err != nil {
+			return nil, fmt.Errorf("%s,%w", op, err)
+		}
+		step.Error = stepError.String
+		if startedAt.Valid {
+			step.StartedAt = &startedAt.Time
+		}
+		if finishedAt.Valid {
+			step.FinishedAt = &finishedAt.Time
+		}
+		step.DurationMs = duration.Int64
+		pipeline.Steps = append(pipeline.Steps, step)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s,%w", op, err)
+	}
+
+	return &pipeline, nil
+}
+
+func (s *StorageSqlite) UpdatePipelineStatus(id int, status string) error {
+	const op = "sqlite.UpdatePipelineStatus"
+
+	_, err := s.db.Exec(`UPDATE pipelines SET status = $1 WHERE id = $2`, status, id)
+	if err != nil {
+		return fmt.Errorf("%s,%w", op, err)
+	}
+	return nil
+}
+
+// UpdatePipelineStep records the execution state of one step
+func (s *StorageSqlite) UpdatePipelineStep(pipelineID int, step *models.PipelineStep) error {
+	const op = "sqlite.UpdatePipelineStep"
+
+	_, err := s.db.Exec(`UPDATE pipeline_steps
+		SET status = $1, error = $2, started_at = $3, finished_at = $4, duration_ms = $5
+		WHERE pipeline_id = $6 AND position = $7`,
+		step.Status, step.Error, step.StartedAt, step.FinishedAt, step.DurationMs, pipelineID, step.Position)
+	if err != nil {
+		return fmt.Errorf("%s,%w", op, err)
 	}
 	return nil
 }
+
+func encodePipeline(pipeline []models.Operation) (sql.NullString, error) {
+	if pipeline == nil {
+		return sql.NullString{}, nil
+	}
+	data, err := json.Marshal(pipeline)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+	return sql.NullString{String: string(data), Valid: true}, nil
+}
+
+func decodePipeline(data sql.NullString) ([]models.Operation, error) {
+	if !data.Valid || data.String == "" {
+		return nil, nil
+	}
+	var pipeline []models.Operation
+	if err := json.Unmarshal([]byte(data.String), &pipeline); err != nil {
+		return nil, err
+	}
+	return pipeline, nil
+}
+
+// nullTenant stores the tenant of a request without an API key as NULL;
+// compared with IS, it finds rows of that request as well
+func nullTenant(tenantID int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(tenantID), Valid: tenantID != 0}
+}
+
+func (s *StorageSqlite) SetWatermarkAsset(asset *models.WatermarkAsset) (id int, err error) {
+	const op = "sqlite.SetWatermarkAsset"
+
+	row := s.db.QueryRow(`
+	INSERT INTO watermark_assets(tenant_id, original_filename, path, width, height, file_size)
+	VALUES ($1,$2,$3,$4,$5,$6)
+	RETURNING id;
+	`, nullTenant(asset.TenantId), asset.OriginalFilename, asset.Path, asset.Width, asset.Height, asset.FileSize)
+
+	err = row.Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("%s %w", op, err)
+	}
+	return
+}
+
+func (s *StorageSqlite) GetWatermarkAsset(id int) (*models.WatermarkAsset, error) {
+	const op = "sqlite.GetWatermarkAsset"
+
+	var asset models.WatermarkAsset
+	var tenantID sql.NullInt64
+	row := s.db.QueryRow(`
+	SELECT id, tenant_id, original_filename, path, width, height, file_size, created_at FROM watermark_assets
+	WHERE id = $1;
+	`, id)
+
+	err := row.Scan(&asset.Id, &tenantID, &asset.OriginalFilename, &asset.Path, &asset.Width, &asset.Height, &asset.FileSize, &asset.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%s,%w", op, storage.ErrAssetNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s,%w", op, err)
+	}
+	asset.TenantId = int(tenantID.Int64)
+	return &asset, nil
+}
+
+// ListWatermarkAssets returns logos of the tenant, of every tenant when allTenants is set
+func (s *StorageSqlite) ListWatermarkAssets(tenantID int, allTenants bool) ([]models.WatermarkAsset, error) {
+	const op = "sqlite.ListWatermarkAssets"
+
+	rows, err := s.db.Query(`
+	SELECT id, tenant_id, original_filename, path, width, height, file_size, created_at FROM watermark_assets
+	WHERE $1 OR tenant_id IS $2
+	ORDER BY id;
+	`, allTenants, nullTenant(tenantID))
+	if err != nil {
+		return nil, fmt.Errorf("%s,%w", op, err)
+	}
+	defer rows.Close()
+
+	var assets []models.WatermarkAsset
+	for rows.Next() {
+		var asset models.WatermarkAsset
+		var tenantID sql.NullInt64
+		err = rows.Scan(&asset.Id, &tenantID, &asset.OriginalFilename, &asset.Path, &asset.Width, &asset.Height, &asset.FileSize, &asset.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("%s,%w", op, err)
+		}
+		asset.TenantId = int(tenantID.Int64)
+		assets = append(assets, asset)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s,%w", op, err)
+	}
+	return assets, nil
+}
+
+// Ping checks that the database is reachable, it is used by health endpoints
+func (s *StorageSqlite) Ping() error {
+	return s.db.Ping()
+}
@@ -0,0 +1,106 @@
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"imageProcessor/internal/models"
+	"imageProcessor/internal/storage"
+	"time"
+)
+
+func (s *StorageSqlite) CreateTenant(name string) (*models.Tenant, error) {
+	const op = "sqlite.CreateTenant"
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("%s,%w", op, err)
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	if err = tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM tenants WHERE name = $1)`, name).Scan(&exists); err != nil {
+		return nil, fmt.Errorf("%s,%w", op, err)
+	}
+	if exists {
+		return nil, fmt.Errorf("%s,%w", op, storage.ErrTenantExists)
+	}
+
+	tenant := models.Tenant{Name: name}
+	err = tx.QueryRow(`INSERT INTO tenants(name) VALUES ($1) RETURNING id, created_at;`, name).Scan(&tenant.Id, &tenant.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("%s,%w", op, err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("%s,%w", op, err)
+	}
+	return &tenant, nil
+}
+
+// CreateAPIKey stores the hash of a new key of the tenant
+func (s *StorageSqlite) CreateAPIKey(key *models.APIKey) (id int, err error) {
+	const op = "sqlite.CreateAPIKey"
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("%s,%w", op, err)
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	if err = tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM tenants WHERE id = $1)`, key.TenantId).Scan(&exists); err != nil {
+		return 0, fmt.Errorf("%s,%w", op, err)
+	}
+	if !exists {
+		return 0, fmt.Errorf("%s,%w", op, storage.ErrTenantNotFound)
+	}
+
+	err = tx.QueryRow(`
+	INSERT INTO api_keys(tenant_id, name, key_hash, is_admin)
+	VALUES ($1,$2,$3,$4)
+	RETURNING id;
+	`, key.TenantId, key.Name, key.KeyHash, key.Admin).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("%s,%w", op, err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("%s,%w", op, err)
+	}
+	return id, nil
+}
+
+// GetAPIKey finds a key which is not revoked by its hash
+func (s *StorageSqlite) GetAPIKey(keyHash string) (*models.APIKey, error) {
+	const op = "sqlite.GetAPIKey"
+
+	var key models.APIKey
+	var name sql.NullString
+	err := s.db.QueryRow(`
+	SELECT id, tenant_id, name, key_hash, is_admin, created_at FROM api_keys
+	WHERE key_hash = $1 AND revoked_at IS NULL;
+	`, keyHash).Scan(&key.Id, &key.TenantId, &name, &key.KeyHash, &key.Admin, &key.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%s,%w", op, storage.ErrAPIKeyNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s,%w", op, err)
+	}
+	key.Name = name.String
+	return &key, nil
+}
+
+// RevokeAPIKey stops accepting the key; the row is kept for the audit
+func (s *StorageSqlite) RevokeAPIKey(id int) error {
+	const op = "sqlite.RevokeAPIKey"
+
+	res, err := s.db.Exec(`UPDATE api_keys SET revoked_at = $1 WHERE id = $2 AND revoked_at IS NULL`, time.Now().UTC(), id)
+	if err != nil {
+		return fmt.Errorf("%s,%w", op, err)
+	}
+	if affected, err := res.RowsAffected(); err == nil && affected == 0 {
+		return fmt.Errorf("%s,%w", op, storage.ErrAPIKeyNotFound)
+	}
+	return nil
+}
@@ -0,0 +1,21 @@
+package storage
+
+import "errors"
+
+var (
+	ErrImageNotFound      = errors.New("image not found")
+	ErrImageDeleted       = errors.New("image is deleted")
+	ErrDerivativeNotFound = errors.New("derivative not found")
+	ErrPresetNotFound     = errors.New("preset not found")
+	ErrPresetExists       = errors.New("preset already exists")
+	ErrAssetNotFound      = errors.New("watermark asset not found")
+	ErrFontNotFound       = errors.New("font not found")
+	ErrFontExists         = errors.New("font already exists")
+	ErrBlobNotFound       = errors.New("blob not found")
+	ErrJobNotFound        = errors.New("job not found")
+	ErrPipelineNotFound   = errors.New("pipeline not found")
+	ErrQueueEmpty         = errors.New("no queued messages")
+	ErrTenantNotFound     = errors.New("tenant not found")
+	ErrTenantExists       = errors.New("tenant already exists")
+	ErrAPIKeyNotFound     = errors.New("api key not found")
+)
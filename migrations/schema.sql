@@ -1,10 +1,177 @@
+-- content-addressed originals, a blob is removed with the last image referencing it
+CREATE TABLE blobs (
+    checksum TEXT PRIMARY KEY, -- sha256 of the content
+    path TEXT NOT NULL,
+    size INTEGER,
+    ref_count INTEGER NOT NULL DEFAULT 0,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+-- owners of images, every request with an API key of a tenant sees only its images
+CREATE TABLE tenants (
+    id INTEGER PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE api_keys (
+    id INTEGER PRIMARY KEY,
+    tenant_id INTEGER NOT NULL REFERENCES tenants(id),
+    name TEXT,
+    key_hash TEXT NOT NULL UNIQUE, -- sha256 of the key, the key itself is shown only once
+    is_admin INTEGER NOT NULL DEFAULT 0, -- sees images of all tenants
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    revoked_at DATETIME
+);
+
 CREATE TABLE images (
     id INTEGER PRIMARY KEY,
+    tenant_id INTEGER REFERENCES tenants(id), -- owner, NULL - uploaded without an API key
     original_filename TEXT,
     original_path TEXT,
-    mime_type TEXT,
+    checksum TEXT REFERENCES blobs(checksum), -- content of the original, shared by identical uploads
+    mime_type TEXT, -- detected by the content, e.g. image/jpeg
+    width INTEGER,
+    height INTEGER,
     file_size INTEGER,
     status TEXT DEFAULT 'pending',
     action TEXT, -- type of action: ["resize", "miniature", "watermark"]
+    parameters TEXT, -- JSON encoded parameters of the action
+    preset TEXT, -- name of the preset the action is taken from
+    preset_version INTEGER, -- version of the preset used by the worker
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX images_tenant_id_idx ON images(tenant_id, id);
+
+-- every requested action is tracked by a job until the worker finishes it
+CREATE TABLE jobs (
+    id INTEGER PRIMARY KEY,
+    image_id INTEGER NOT NULL REFERENCES images(id),
+    tenant_id INTEGER, -- owner of the image, kept after the image is removed
+    action TEXT NOT NULL,
+    pipeline_id INTEGER, -- set for the pipeline action and for a resolved pipeline preset
+    preset_version INTEGER, -- version of the preset taken by the first attempt of the job
+    status TEXT NOT NULL DEFAULT 'pending', -- ["pending", "processing", "modified", "deleted", "failed"]
+    attempts INTEGER NOT NULL DEFAULT 0, -- incremented every time the worker starts the job
+    error TEXT, -- reason of the last failure
+    queued_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    started_at DATETIME,
+    finished_at DATETIME
+);
+
+CREATE INDEX jobs_image_id_idx ON jobs(image_id);
+
+-- messages written in the transaction of the accepted request, the relay publishes them to the queue
+CREATE TABLE outbox (
+    id INTEGER PRIMARY KEY,
+    message_key TEXT,
+    payload BLOB NOT NULL, -- JSON encoded message
+    attempts INTEGER NOT NULL DEFAULT 0, -- failed publishing attempts
+    error TEXT, -- reason of the last failure
+    available_at INTEGER NOT NULL, -- unix milliseconds, the next publishing attempt is not made before
+    sent_at DATETIME,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX outbox_pending_idx ON outbox(sent_at, available_at);
+
+-- messages of the sqlite queue backend, a message is deleted when it is acknowledged
+CREATE TABLE queue_messages (
+    id INTEGER PRIMARY KEY,
+    topic TEXT NOT NULL,
+    message_key TEXT, -- messages with the same key are delivered one at a time
+    value BLOB NOT NULL,
+    headers TEXT, -- JSON encoded headers
+    available_at INTEGER NOT NULL, -- unix milliseconds, the message is not delivered before
+    locked_until INTEGER, -- unix milliseconds, lease of the consumer which took the message
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX queue_messages_topic_idx ON queue_messages(topic, available_at);
+
+CREATE TABLE derivatives (
+    id INTEGER PRIMARY KEY,
+    image_id INTEGER NOT NULL REFERENCES images(id),
+    job_id INTEGER REFERENCES jobs(id), -- job which produced the derivative
+    action TEXT, -- action which produced the derivative
+    variant TEXT, -- several results of one action, e.g. thumbnail size
+    parameters TEXT, -- JSON encoded parameters of the action
+    path TEXT,
+    mime_type TEXT,
+    file_size INTEGER,
+    checksum TEXT, -- sha256 of the derivative file
     created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-);
\ No newline at end of file
+);
+
+CREATE INDEX derivatives_image_id_idx ON derivatives(image_id, action, variant);
+
+
+CREATE TABLE pipelines (
+    id INTEGER PRIMARY KEY,
+    image_id INTEGER NOT NULL REFERENCES images(id),
+    status TEXT DEFAULT 'pending',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE pipeline_steps (
+    id INTEGER PRIMARY KEY,
+    pipeline_id INTEGER NOT NULL REFERENCES pipelines(id),
+    position INTEGER NOT NULL, -- order of the step inside the pipeline starting from 1
+    action TEXT,
+    parameters TEXT, -- JSON encoded parameters of the step
+    status TEXT DEFAULT 'pending',
+    error TEXT,
+    started_at DATETIME,
+    finished_at DATETIME,
+    duration_ms INTEGER,
+    UNIQUE (pipeline_id, position)
+);
+
+CREATE TABLE presets (
+    id INTEGER PRIMARY KEY,
+    tenant_id INTEGER REFERENCES tenants(id), -- owner, NULL - created without an API key
+    name TEXT NOT NULL, -- unique within the tenant
+    version INTEGER NOT NULL DEFAULT 1, -- incremented on every update
+    action TEXT NOT NULL, -- single action or "pipeline"
+    parameters TEXT, -- JSON encoded parameters of the action
+    pipeline TEXT, -- JSON encoded list of operations for the pipeline action
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (tenant_id, name)
+);
+
+CREATE TABLE preset_versions (
+    preset_id INTEGER NOT NULL REFERENCES presets(id),
+    version INTEGER NOT NULL,
+    action TEXT NOT NULL,
+    parameters TEXT,
+    pipeline TEXT,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (preset_id, version)
+);
+
+CREATE TABLE watermark_assets (
+    id INTEGER PRIMARY KEY,
+    tenant_id INTEGER REFERENCES tenants(id), -- owner, NULL - uploaded without an API key
+    original_filename TEXT,
+    path TEXT NOT NULL,
+    width INTEGER,
+    height INTEGER,
+    file_size INTEGER,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX watermark_assets_tenant_id_idx ON watermark_assets(tenant_id, id);
+
+CREATE TABLE fonts (
+    id INTEGER PRIMARY KEY,
+    tenant_id INTEGER REFERENCES tenants(id), -- owner, NULL - uploaded without an API key
+    family TEXT NOT NULL,
+    weight TEXT NOT NULL,
+    original_filename TEXT,
+    path TEXT NOT NULL,
+    file_size INTEGER,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (tenant_id, family, weight)
+);